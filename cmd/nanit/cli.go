@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/client"
+	"github.com/indiefan/home_assistant_nanit/pkg/session"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/rs/zerolog"
+)
+
+// cliTimeout - how long CLI commands wait for the camera connection and responses
+const cliTimeout = 30 * time.Second
+
+// cli - context of the commands talking to Nanit without running the bridge
+type cli struct {
+	client *client.NanitClient
+
+	// capture - optional recorder of the camera websocket messages
+	capture *client.Capture
+}
+
+// cliCommand - wraps command handler, reports its error and turns it into an exit code
+func cliCommand(handler func(c *cli, args []string) error, args []string) int {
+	c, err := newCLI()
+	if err == nil {
+		err = handler(c, args)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// newCLI - authorizes against Nanit using the bridge configuration and session
+func newCLI() (*cli, error) {
+	cfg, errs := readConfig(configFile())
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "- %v\n", err)
+		}
+
+		return nil, fmt.Errorf("configuration is invalid (%v errors), see 'nanit config validate'", len(errs))
+	}
+
+	// Keep the output clean unless debugging was requested
+	if level, _ := zerolog.ParseLevel(cfg.LogLevel); level < zerolog.InfoLevel {
+		zerolog.SetGlobalLevel(level)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	}
+
+	var sessionKey []byte
+	if cfg.SessionKey != "" {
+		sessionKey = []byte(cfg.SessionKey)
+	}
+
+	sessionStore := session.InitSessionStore(cfg.SessionFile, sessionKey)
+
+	c := &cli{
+		client: &client.NanitClient{
+			Email:        cfg.Nanit.Email,
+			Password:     cfg.Nanit.Password,
+			RefreshToken: cfg.Nanit.RefreshToken,
+			SessionStore: sessionStore,
+		},
+	}
+
+	if err := c.client.MaybeAuthorize(false); err != nil {
+		return nil, fmt.Errorf("unable to authorize: %w", err)
+	}
+
+	return c, nil
+}
+
+// babies - returns babies from the session, fetches them if there are none
+func (c *cli) babies() ([]baby.Baby, error) {
+	if babies := c.client.SessionStore.GetBabies(); len(babies) > 0 {
+		return babies, nil
+	}
+
+	return c.client.FetchBabies()
+}
+
+// resolveBaby - picks the baby by UID given as the first argument, which can be omitted if there is only one baby
+// Returns remaining arguments
+func (c *cli) resolveBaby(args []string) (baby.Baby, []string, error) {
+	babies, err := c.babies()
+	if err != nil {
+		return baby.Baby{}, nil, err
+	}
+
+	if len(args) > 0 {
+		for _, babyInfo := range babies {
+			if babyInfo.UID == args[0] {
+				return babyInfo, args[1:], nil
+			}
+		}
+	}
+
+	if len(babies) == 1 {
+		return babies[0], args, nil
+	} else if len(babies) == 0 {
+		return baby.Baby{}, nil, errors.New("no babies found on the account")
+	}
+
+	return baby.Baby{}, nil, errors.New("multiple babies found, specify baby UID (see 'nanit babies')")
+}
+
+// withCamera - connects to the camera of the baby and runs the callback once the connection is ready
+// Only the connection is limited by cliTimeout, the callback is responsible for its own timeouts
+func (c *cli) withCamera(babyInfo baby.Baby, callback func(conn *client.WebsocketConnection) error) error {
+	manager := client.NewWebsocketConnectionManager(babyInfo.UID, babyInfo.CameraUID, c.client.SessionStore, c.client, baby.NewStateManager())
+	manager.Capture = c.capture
+
+	var once sync.Once
+	readyC := make(chan struct{})
+	resultC := make(chan error, 1)
+
+	manager.WithReadyConnection(func(conn *client.WebsocketConnection, ctx utils.GracefulContext) {
+		once.Do(func() {
+			close(readyC)
+			resultC <- callback(conn)
+		})
+	})
+
+	runner := utils.RunWithGracefulCancel(manager.RunWithinContext)
+	defer runner.Cancel()
+
+	select {
+	case <-readyC:
+		return <-resultC
+	case <-time.After(cliTimeout):
+		return fmt.Errorf("unable to connect to camera %v within %v", babyInfo.CameraUID, cliTimeout)
+	}
+}
+
+// parseSwitch - parses on / off argument
+func parseSwitch(args []string) (bool, error) {
+	if len(args) == 1 {
+		switch args[0] {
+		case "on":
+			return true, nil
+		case "off":
+			return false, nil
+		}
+	}
+
+	return false, errors.New("expected on or off")
+}
+
+// parseSince - parses time given as RFC3339 or as a duration back from now (ie. 2h)
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q, expected duration (ie. 2h) or RFC3339", value)
+}
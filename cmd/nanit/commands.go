@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/app"
+	"github.com/indiefan/home_assistant_nanit/pkg/client"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const usage = `Usage: nanit [command]
+
+Without a command, the bridge is started.
+
+Commands:
+  config validate [file]              Validates configuration file (default: $NANIT_CONFIG_FILE) with env overrides applied
+  babies                              Lists babies and their cameras
+  state [baby_uid]                    Prints sensor data, night light, settings and status of the camera as JSON
+  light [baby_uid] on|off             Switches the night light
+  standby [baby_uid] on|off           Switches the standby (sleep) mode of the camera
+  events [--since 24h] [baby_uid]     Prints event messages as JSON lines, since accepts duration or RFC3339 time
+  stream-url [baby_uid]               Prints URL of the stream provided by Nanit servers
+  capture [--duration 5m] [baby_uid] [file]
+                                      Records camera websocket messages into the file (default: {camera_uid}-{time}.pb)
+  replay <file> [baby_uid]            Replays captured messages through the bridge handlers and prints resulting state as JSON
+  help                                Shows this help
+
+Baby UID can be omitted if there is only one baby on the account.
+The commands use the same configuration and session as the bridge.
+`
+
+// runCommand - runs CLI subcommand, returns exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "config":
+		if len(args) >= 2 && args[1] == "validate" {
+			return validateConfigCommand(args[2:])
+		}
+	case "babies":
+		return cliCommand(babiesCommand, args[1:])
+	case "state":
+		return cliCommand(stateCommand, args[1:])
+	case "light":
+		return cliCommand(lightCommand, args[1:])
+	case "standby":
+		return cliCommand(standbyCommand, args[1:])
+	case "events":
+		return cliCommand(eventsCommand, args[1:])
+	case "stream-url":
+		return cliCommand(streamURLCommand, args[1:])
+	case "capture":
+		return cliCommand(captureCommand, args[1:])
+	case "replay":
+		return replayCommand(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command: %v\n\n%v", args, usage)
+	return 2
+}
+
+func validateConfigCommand(args []string) int {
+	filename := configFile()
+	if len(args) > 0 {
+		filename = args[0]
+	}
+
+	_, errs := readConfig(filename)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "- %v\n", err)
+		}
+
+		fmt.Fprintf(os.Stderr, "\nConfiguration is invalid (%v errors)\n", len(errs))
+		return 1
+	}
+
+	fmt.Println("Configuration is valid")
+	return 0
+}
+
+func babiesCommand(c *cli, args []string) error {
+	babies, err := c.client.FetchBabies()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "BABY UID\tCAMERA UID\tNAME")
+	for _, babyInfo := range babies {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", babyInfo.UID, babyInfo.CameraUID, babyInfo.Name)
+	}
+
+	return w.Flush()
+}
+
+func stateCommand(c *cli, args []string) error {
+	babyInfo, _, err := c.resolveBaby(args)
+	if err != nil {
+		return err
+	}
+
+	requests := []struct {
+		name    string
+		reqType client.RequestType
+		request *client.Request
+	}{
+		{"sensor_data", client.RequestType_GET_SENSOR_DATA, &client.Request{GetSensorData: &client.GetSensorData{All: utils.ConstRefBool(true)}}},
+		{"control", client.RequestType_GET_CONTROL, &client.Request{GetControl_: &client.GetControl{NightLight: utils.ConstRefBool(true), NightLightTimeout: utils.ConstRefBool(true)}}},
+		{"settings", client.RequestType_GET_SETTINGS, &client.Request{}},
+		{"status", client.RequestType_GET_STATUS, &client.Request{GetStatus_: &client.GetStatus{All: utils.ConstRefBool(true)}}},
+	}
+
+	state := make(map[string]json.RawMessage)
+
+	err = c.withCamera(babyInfo, func(conn *client.WebsocketConnection) error {
+		for _, r := range requests {
+			ctx, cancel := context.WithTimeout(context.Background(), cliTimeout)
+			res, err := conn.Do(ctx, r.reqType, r.request)
+			cancel()
+
+			if err != nil {
+				// Not all cameras support all requests, report the problem and continue
+				state[r.name], _ = json.Marshal(map[string]string{"error": err.Error()})
+				continue
+			}
+
+			state[r.name], err = protojson.Marshal(res)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(out))
+	return nil
+}
+
+func lightCommand(c *cli, args []string) error {
+	babyInfo, args, err := c.resolveBaby(args)
+	if err != nil {
+		return err
+	}
+
+	enabled, err := parseSwitch(args)
+	if err != nil {
+		return err
+	}
+
+	nightLight := client.Control_LIGHT_OFF
+	if enabled {
+		nightLight = client.Control_LIGHT_ON
+	}
+
+	return c.withCamera(babyInfo, func(conn *client.WebsocketConnection) error {
+		ctx, cancel := context.WithTimeout(context.Background(), cliTimeout)
+		defer cancel()
+
+		return client.NewCamera(conn).PutControl(ctx, &client.Control{NightLight: &nightLight})
+	})
+}
+
+func standbyCommand(c *cli, args []string) error {
+	babyInfo, args, err := c.resolveBaby(args)
+	if err != nil {
+		return err
+	}
+
+	enabled, err := parseSwitch(args)
+	if err != nil {
+		return err
+	}
+
+	return c.withCamera(babyInfo, func(conn *client.WebsocketConnection) error {
+		ctx, cancel := context.WithTimeout(context.Background(), cliTimeout)
+		defer cancel()
+
+		return client.NewCamera(conn).PutSettings(ctx, &client.Settings{SleepMode: &enabled})
+	})
+}
+
+func eventsCommand(c *cli, args []string) error {
+	flags := flag.NewFlagSet("events", flag.ContinueOnError)
+	sinceStr := flags.String("since", "24h", "duration (ie. 2h) or RFC3339 time")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	since, err := parseSince(*sinceStr, time.Now())
+	if err != nil {
+		return err
+	}
+
+	babyInfo, _, err := c.resolveBaby(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	messages, err := c.client.FetchMessagesSince(babyInfo.UID, since)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	for _, msg := range messages {
+		if err := encoder.Encode(msg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func streamURLCommand(c *cli, args []string) error {
+	babyInfo, _, err := c.resolveBaby(args)
+	if err != nil {
+		return err
+	}
+
+	authToken, _ := c.client.SessionStore.GetAuthToken()
+	fmt.Println(client.RemoteStreamURL(babyInfo.UID, authToken))
+	return nil
+}
+
+func captureCommand(c *cli, args []string) error {
+	flags := flag.NewFlagSet("capture", flag.ContinueOnError)
+	duration := flags.Duration("duration", 5*time.Minute, "how long to capture (interrupt stops the capture earlier)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	babyInfo, args, err := c.resolveBaby(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	filename := client.CaptureFilename(".", babyInfo.CameraUID, time.Now())
+	if len(args) > 0 {
+		filename = args[0]
+	}
+
+	c.capture, err = client.NewCapture(filename)
+	if err != nil {
+		return err
+	}
+
+	defer c.capture.Close()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	fmt.Fprintf(os.Stderr, "Capturing into %v for %v\n", filename, *duration)
+
+	return c.withCamera(babyInfo, func(conn *client.WebsocketConnection) error {
+		// Ask for the current state, so that the capture contains all the responses
+		conn.SendRequest(client.RequestType_GET_SENSOR_DATA, &client.Request{GetSensorData: &client.GetSensorData{All: utils.ConstRefBool(true)}})
+		conn.SendRequest(client.RequestType_GET_CONTROL, &client.Request{GetControl_: &client.GetControl{NightLight: utils.ConstRefBool(true)}})
+		conn.SendRequest(client.RequestType_GET_SETTINGS, &client.Request{})
+		conn.SendRequest(client.RequestType_GET_STATUS, &client.Request{GetStatus_: &client.GetStatus{All: utils.ConstRefBool(true)}})
+
+		select {
+		case <-time.After(*duration):
+		case <-interrupt:
+		}
+
+		return nil
+	})
+}
+
+// replayCommand - does not need Nanit account, so it runs without cli context
+func replayCommand(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "Missing capture file\n\n%v", usage)
+		return 2
+	}
+
+	babyUID := "replay"
+	if len(args) > 1 {
+		babyUID = args[1]
+	}
+
+	messages, err := client.ReadCaptureFile(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	instance := app.NewApp(app.Opts{})
+	replayed := instance.ReplayCapture(babyUID, messages)
+	fmt.Fprintf(os.Stderr, "Replayed %v of %v messages\n", replayed, len(messages))
+
+	out, err := json.MarshalIndent(instance.BabyStateManager.GetBabyState(babyUID).AsMap(false), "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	fmt.Println(string(out))
+	return 0
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/app"
+	"github.com/indiefan/home_assistant_nanit/pkg/config"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// registerSecretProviders - enables reading secrets from a directory (ie. Docker secrets in /run/secrets)
+// Note: {VAR}_FILE variables are always supported
+func registerSecretProviders() {
+	if dir := utils.EnvVarStr("NANIT_SECRETS_DIR", ""); dir != "" {
+		log.Info().Str("dir", dir).Msg("Reading secrets from the directory")
+		utils.RegisterSecretProvider(utils.DirectorySecretProvider{Dir: dir})
+	}
+}
+
+// configFile - returns path to the optional configuration file
+func configFile() string {
+	return utils.EnvVarStr("NANIT_CONFIG_FILE", "")
+}
+
+// readConfig - loads configuration from the file and environment and validates it
+func readConfig(filename string) (*config.Config, []error) {
+	cfg, errs := config.Load(filename, os.LookupEnv)
+	return cfg, append(errs, cfg.Validate()...)
+}
+
+// loadConfig - same as readConfig, but fails if there are any problems
+func loadConfig(filename string) *config.Config {
+	cfg, errs := readConfig(filename)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			log.Error().Msg(err.Error())
+		}
+
+		log.Fatal().Int("errors", len(errs)).Msg("Invalid configuration, see 'nanit config validate'")
+	}
+
+	if filename != "" {
+		log.Info().Str("path", filename).Msg("Configuration loaded from the file")
+	}
+
+	return cfg
+}
+
+// reloadConfig - reads the configuration again and applies it to the running app
+// Invalid configuration is reported and ignored, the app keeps running with the previous one
+func reloadConfig(instance *app.App, dataDirs app.DataDirectories) {
+	cfg, errs := readConfig(configFile())
+	if len(errs) > 0 {
+		for _, err := range errs {
+			log.Error().Msg(err.Error())
+		}
+
+		log.Error().Int("errors", len(errs)).Msg("Invalid configuration, keeping the current one")
+		return
+	}
+
+	if absDataDir, _ := filepath.Abs(cfg.DataDir); absDataDir != dataDirs.BaseDir {
+		log.Warn().Str("data_dir", cfg.DataDir).Msg("Change of data directory requires restart, ignoring it")
+	}
+
+	setLogLevel(cfg.LogLevel)
+	instance.Reload(cfg.AppOpts(dataDirs))
+}
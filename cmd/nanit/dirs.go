@@ -6,12 +6,9 @@ import (
 
 	"github.com/rs/zerolog/log"
 	"github.com/indiefan/home_assistant_nanit/pkg/app"
-	"github.com/indiefan/home_assistant_nanit/pkg/utils"
 )
 
-func ensureDataDirectories() app.DataDirectories {
-	relDataDir := utils.EnvVarStr("NANIT_DATA_DIR", "/data")
-
+func ensureDataDirectories(relDataDir string) app.DataDirectories {
 	absDataDir, filePathErr := filepath.Abs(relDataDir)
 	if filePathErr != nil {
 		log.Fatal().Str("path", relDataDir).Err(filePathErr).Msg("Unable to retrieve absolute file path")
@@ -6,13 +6,10 @@ import (
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"github.com/indiefan/home_assistant_nanit/pkg/utils"
 )
 
-// Set log level after env. initialization
-func setLogLevel() {
-	// Try to read log level from env. variable
-	logLevelStr := utils.EnvVarStr("NANIT_LOG_LEVEL", "info")
+// Set log level after configuration is loaded
+func setLogLevel(logLevelStr string) {
 	logLevel, _ := zerolog.ParseLevel(logLevelStr)
 	if logLevel == zerolog.NoLevel {
 		log.Fatal().Str("value", logLevelStr).Msg("Unknown log level specified")
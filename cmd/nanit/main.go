@@ -3,76 +3,53 @@ package main
 import (
 	"os"
 	"os/signal"
-	"regexp"
-	"time"
+	"syscall"
 
 	"github.com/rs/zerolog/log"
 	"github.com/indiefan/home_assistant_nanit/pkg/app"
-	"github.com/indiefan/home_assistant_nanit/pkg/mqtt"
 	"github.com/indiefan/home_assistant_nanit/pkg/utils"
 )
 
 func main() {
 	initLogger()
-	logAppVersion()
 	utils.LoadDotEnvFile()
-	setLogLevel()
-
-	opts := app.Opts{
-		NanitCredentials: app.NanitCredentials{
-			Email:        utils.EnvVarStr("NANIT_EMAIL", ""),
-			Password:     utils.EnvVarStr("NANIT_PASSWORD", ""),
-			RefreshToken: utils.EnvVarStr("NANIT_REFRESH_TOKEN", ""),
-		},
-		SessionFile:     utils.EnvVarStr("NANIT_SESSION_FILE", "/data/session.json"),
-		DataDirectories: ensureDataDirectories(),
-		HTTPEnabled:     false,
-		EventPolling: app.EventPollingOpts{
-			// Event message polling disabled by default
-			Enabled: utils.EnvVarBool("NANIT_EVENTS_POLLING", false),
-			// 30 second default polling interval
-			PollingInterval: utils.EnvVarSeconds("NANIT_EVENTS_POLLING_INTERVAL", 30*time.Second),
-			// 300 second (5 min) default message timeout (unseen messages are ignored once they are this old)
-			MessageTimeout: utils.EnvVarSeconds("NANIT_EVENTS_MESSAGE_TIMEOUT", 300*time.Second),
-		},
+	registerSecretProviders()
+
+	// CLI subcommands (ie. nanit config validate)
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
 	}
 
-	if utils.EnvVarBool("NANIT_RTMP_ENABLED", true) {
-		publicAddr := utils.EnvVarReqStr("NANIT_RTMP_ADDR")
-		m := regexp.MustCompile("(:[0-9]+)$").FindStringSubmatch(publicAddr)
-		if len(m) != 2 {
-			log.Fatal().Msg("Invalid NANIT_RTMP_ADDR. Unable to parse port.")
-		}
+	logAppVersion()
 
-		opts.RTMP = &app.RTMPOpts{
-			ListenAddr: m[1],
-			PublicAddr: publicAddr,
-		}
-	}
+	cfg := loadConfig(configFile())
+	setLogLevel(cfg.LogLevel)
+	log.Debug().Interface("config", cfg.Redacted()).Msg("Effective configuration")
 
-	if utils.EnvVarBool("NANIT_MQTT_ENABLED", false) {
-		opts.MQTT = &mqtt.Opts{
-			BrokerURL:   utils.EnvVarReqStr("NANIT_MQTT_BROKER_URL"),
-			ClientID:    utils.EnvVarStr("NANIT_MQTT_CLIENT_ID", "nanit"),
-			Username:    utils.EnvVarStr("NANIT_MQTT_USERNAME", ""),
-			Password:    utils.EnvVarStr("NANIT_MQTT_PASSWORD", ""),
-			TopicPrefix: utils.EnvVarStr("NANIT_MQTT_PREFIX", "nanit"),
-		}
-	}
+	dataDirs := ensureDataDirectories(cfg.DataDir)
+	opts := cfg.AppOpts(dataDirs)
 
 	if opts.EventPolling.Enabled {
 		log.Info().Msgf("Event polling enabled with an interval of %v", opts.EventPolling.PollingInterval)
 	}
 
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
 	instance := app.NewApp(opts)
 
 	runner := utils.RunWithGracefulCancel(instance.Run)
 
-	<-interrupt
-	log.Warn().Msg("Received interrupt signal, terminating")
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			log.Info().Msg("Received hangup signal, reloading configuration")
+			reloadConfig(instance, dataDirs)
+			continue
+		}
+
+		log.Warn().Str("signal", sig.String()).Msg("Received termination signal, terminating")
+		break
+	}
 
 	waitForCleanup := make(chan struct{}, 1)
 
@@ -81,11 +58,17 @@ func main() {
 		close(waitForCleanup)
 	}()
 
-	select {
-	case <-interrupt:
-		log.Fatal().Msg("Received another interrupt signal, forcing termination without clean up")
-	case <-waitForCleanup:
-		log.Info().Msg("Clean exit")
-		return
+	for {
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				continue
+			}
+
+			log.Fatal().Msg("Received another termination signal, forcing termination without clean up")
+		case <-waitForCleanup:
+			log.Info().Msg("Clean exit")
+			return
+		}
 	}
 }
@@ -4,19 +4,17 @@ go 1.24
 
 require (
 	github.com/eclipse/paho.mqtt.golang v1.3.0
+	github.com/gorilla/websocket v1.4.2
 	github.com/joho/godotenv v1.3.0
 	github.com/notedit/rtmp v0.0.2
 	github.com/rs/zerolog v1.20.0
-	github.com/sacOO7/gowebsocket v0.0.0-20201031204121-1620b8bfa516
 	github.com/stretchr/testify v1.6.1
 	google.golang.org/protobuf v1.36.5
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.0 // indirect
-	github.com/gorilla/websocket v1.4.2 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/sacOO7/go-logger v0.0.0-20180719173527-9ac9add5a50d // indirect
 	golang.org/x/net v0.0.0-20200425230154-ff2c4b7c35a0 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
@@ -1,17 +1,34 @@
 package app
 
 import (
-	"fmt"
+	"context"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/indiefan/home_assistant_nanit/pkg/baby"
 	"github.com/indiefan/home_assistant_nanit/pkg/client"
+	"github.com/indiefan/home_assistant_nanit/pkg/history"
+	"github.com/indiefan/home_assistant_nanit/pkg/influx"
 	"github.com/indiefan/home_assistant_nanit/pkg/message"
 	"github.com/indiefan/home_assistant_nanit/pkg/mqtt"
-	"github.com/indiefan/home_assistant_nanit/pkg/rtmpserver"
+	"github.com/indiefan/home_assistant_nanit/pkg/report"
 	"github.com/indiefan/home_assistant_nanit/pkg/session"
+	"github.com/indiefan/home_assistant_nanit/pkg/sleep"
 	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/indiefan/home_assistant_nanit/pkg/webhook"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// stateSnapshotDebounce - how long are the state changes collected before the snapshot is written
+	stateSnapshotDebounce = 10 * time.Second
+
+	// streamRequestGracePeriod - how long to wait for the stream requested before the restart / reconnect
+	// before it is requested again
+	streamRequestGracePeriod = 30 * time.Second
 )
 
 // App - application container
@@ -21,11 +38,39 @@ type App struct {
 	BabyStateManager *baby.StateManager
 	RestClient       *client.NanitClient
 	MQTTConnection   *mqtt.Connection
+	Webhooks         *webhook.Dispatcher
+	Influx           *influx.Sink
+	History          *history.Store
+	Reports          *report.Generator
+	Sleep            *sleep.Tracker
+
+	// mu - guards Opts and optional components which can be replaced by Reload
+	mu sync.RWMutex
+
+	// reloadMu - serializes starting and restarting of components
+	reloadMu   sync.Mutex
+	ctx        utils.GracefulContext
+	components map[string]utils.GracefulRunner
+
+	babiesMu       sync.Mutex
+	babyRunners    map[string]*babyRunner
+	refreshBabiesC chan struct{}
+
+	eventPollersMu sync.RWMutex
+	eventPollers   map[string]*eventPoller
+
+	websocketConnsMu sync.RWMutex
+	websocketConns   map[string]*client.WebsocketConnection
+
+	health *healthTracker
+
+	// persister - keeps the baby state across restarts, nil without data directory
+	persister *baby.Persister
 }
 
 // NewApp - constructor
 func NewApp(opts Opts) *App {
-	sessionStore := session.InitSessionStore(opts.SessionFile)
+	sessionStore := session.InitSessionStore(opts.SessionFile, opts.SessionKey)
 
 	instance := &App{
 		Opts:             opts,
@@ -37,10 +82,40 @@ func NewApp(opts Opts) *App {
 			RefreshToken: opts.NanitCredentials.RefreshToken,
 			SessionStore: sessionStore,
 		},
+		components:     make(map[string]utils.GracefulRunner),
+		babyRunners:    make(map[string]*babyRunner),
+		refreshBabiesC: make(chan struct{}, 1),
+		eventPollers:   make(map[string]*eventPoller),
+		websocketConns: make(map[string]*client.WebsocketConnection),
+		health:         newHealthTracker(),
 	}
 
+	// Note: MQTT connection is always created, so that command handlers registered by the cameras
+	// survive enabling / disabling of MQTT on reload. It is only run if MQTT is enabled.
+	var mqttOpts mqtt.Opts
 	if opts.MQTT != nil {
-		instance.MQTTConnection = mqtt.NewConnection(*opts.MQTT)
+		mqttOpts = *opts.MQTT
+	}
+
+	instance.MQTTConnection = mqtt.NewConnection(mqttOpts)
+	instance.MQTTConnection.RegisterRefreshBabiesHandler(instance.RefreshBabies)
+	instance.MQTTConnection.RegisterMessageActionHandler(func(babyUID string, action message.Action, messageIDs []int) {
+		if err := instance.UpdateMessages(babyUID, action, messageIDs); err != nil {
+			log.Error().Str("baby_uid", babyUID).Str("action", string(action)).Err(err).Msg("Unable to update messages")
+		}
+	})
+
+	instance.MQTTConnection.RegisterRawRequestHandler(instance.handleMQTTRawRequest)
+
+	instance.setComponents(opts, componentNames)
+	instance.BabyStateManager.SetStaleness(opts.Staleness)
+
+	// Last known state is restored before anything subscribes, so that all components receive it right away
+	if opts.DataDirectories.BaseDir != "" {
+		instance.persister = baby.NewPersister(filepath.Join(opts.DataDirectories.BaseDir, "state.json"), stateSnapshotDebounce)
+		if err := instance.persister.Load(instance.BabyStateManager); err != nil {
+			log.Warn().Err(err).Str("filename", instance.persister.Filename).Msg("Unable to restore baby state")
+		}
 	}
 
 	return instance
@@ -49,52 +124,56 @@ func NewApp(opts Opts) *App {
 // Run - application main loop
 func (app *App) Run(ctx utils.GracefulContext) {
 	// Reauthorize if we don't have a token or we assume it is invalid
-	app.RestClient.MaybeAuthorize(false)
+	if err := app.RestClient.MaybeAuthorize(false); err != nil {
+		log.Fatal().Err(err).Msg("Unable to authorize")
+	}
 
 	// Fetches babies info if they are not present in session
-	app.RestClient.EnsureBabies()
+	babies := app.RestClient.EnsureBabies()
 
-	// RTMP
-	if app.Opts.RTMP != nil {
-		go rtmpserver.StartRTMPServer(app.Opts.RTMP.ListenAddr, app.BabyStateManager)
-	}
+	// Health tracking
+	unsubscribeHealth := app.BabyStateManager.SubscribeWithOpts(app.health.handleStateUpdate, baby.SubscribeOpts{Name: "health"})
+	defer unsubscribeHealth()
 
-	// MQTT
-	if app.MQTTConnection != nil {
-		ctx.RunAsChild(func(childCtx utils.GracefulContext) {
-			app.MQTTConnection.Run(app.BabyStateManager, childCtx)
+	// State snapshots
+	if app.persister != nil {
+		persisterRunner := ctx.RunAsChild(func(childCtx utils.GracefulContext) {
+			app.persister.Run(app.BabyStateManager, childCtx)
 		})
-	}
 
-	// Start reading the data from the stream
-	for _, babyInfo := range app.SessionStore.Session.Babies {
-		_babyInfo := babyInfo
-		ctx.RunAsChild(func(childCtx utils.GracefulContext) {
-			app.handleBaby(_babyInfo, childCtx)
-		})
+		defer persisterRunner.Cancel()
 	}
 
-	// Start serving content over HTTP
-	if app.Opts.HTTPEnabled {
-		go serve(app.SessionStore.Session.Babies, app.Opts.DataDirectories)
+	app.reloadMu.Lock()
+	app.ctx = ctx
+
+	// RTMP, MQTT, webhooks, InfluxDB, state history, night reports, sleep tracking and HTTP server
+	for _, name := range componentNames {
+		app.startComponent(name)
 	}
 
+	// Start reading the data from the stream and keep the list of babies up to date
+	app.syncBabies(babies)
+	app.startComponent("babies")
+	app.reloadMu.Unlock()
+
 	<-ctx.Done()
 }
 
 func (app *App) handleBaby(baby baby.Baby, ctx utils.GracefulContext) {
-	if app.Opts.RTMP != nil || app.MQTTConnection != nil {
+	if app.currentOpts().needsWebsocket() {
 		// Websocket connection
-		ws := client.NewWebsocketConnectionManager(baby.UID, baby.CameraUID, app.SessionStore.Session, app.RestClient, app.BabyStateManager)
+		ws := client.NewWebsocketConnectionManager(baby.UID, baby.CameraUID, app.SessionStore, app.RestClient, app.BabyStateManager)
+
+		if captureDir := app.currentOpts().WebsocketCaptureDir; captureDir != "" {
+			ws.Capture = app.startCapture(captureDir, baby.CameraUID)
+			defer ws.Capture.Close()
+		}
 
 		ws.WithReadyConnection(func(conn *client.WebsocketConnection, childCtx utils.GracefulContext) {
 			app.runWebsocket(baby.UID, conn, childCtx)
 		})
 
-		if app.Opts.EventPolling.Enabled {
-			go app.pollMessages(baby.UID, app.BabyStateManager)
-		}
-
 		ctx.RunAsChild(func(childCtx utils.GracefulContext) {
 			ws.RunWithinContext(childCtx)
 		})
@@ -103,79 +182,41 @@ func (app *App) handleBaby(baby baby.Baby, ctx utils.GracefulContext) {
 	<-ctx.Done()
 }
 
-func (app *App) pollMessages(babyUID string, babyStateManager *baby.StateManager) {
-	newMessages := app.RestClient.FetchNewMessages(babyUID, app.Opts.EventPolling.MessageTimeout)
-
-	for _, msg := range newMessages {
-		switch msg.Type {
-		case message.SoundEventMessageType:
-			go babyStateManager.NotifySoundSubscribers(babyUID, time.Time(msg.Time))
-			break
-		case message.MotionEventMessageType:
-			go babyStateManager.NotifyMotionSubscribers(babyUID, time.Time(msg.Time))
-			break
-		}
-	}
-
-	// wait for the specified interval
-	time.Sleep(app.Opts.EventPolling.PollingInterval)
-	app.pollMessages(babyUID, babyStateManager)
-}
-
 func (app *App) runWebsocket(babyUID string, conn *client.WebsocketConnection, childCtx utils.GracefulContext) {
 	// Reading sensor data
-	conn.RegisterMessageHandler(func(m *client.Message, conn *client.WebsocketConnection) {
-		// Sensor request initiated by us on start (or some other client, we don't care)
-		if *m.Type == client.Message_RESPONSE && m.Response != nil {
-			if *m.Response.RequestType == client.RequestType_GET_SENSOR_DATA && len(m.Response.SensorData) > 0 {
-				processSensorData(babyUID, m.Response.SensorData, app.BabyStateManager)
-			} else if *m.Response.RequestType == client.RequestType_GET_CONTROL && m.Response.Control != nil {
-				processLight(babyUID, m.Response.Control, app.BabyStateManager)
-			} else if *m.Response.RequestType == client.RequestType_GET_SETTINGS && m.Response.Settings != nil {
-				processStandby(babyUID, m.Response.Settings, app.BabyStateManager)
-			}
-		} else
-
-		// Communication initiated from a cam
-		// Note: it sends the updates periodically on its own + whenever some significant change occurs
-		if *m.Type == client.Message_REQUEST && m.Request != nil {
-			if *m.Request.Type == client.RequestType_PUT_SENSOR_DATA && len(m.Request.SensorData_) > 0 {
-				processSensorData(babyUID, m.Request.SensorData_, app.BabyStateManager)
-			} else if *m.Request.Type == client.RequestType_PUT_CONTROL && m.Request.Control != nil {
-				processLight(babyUID, m.Request.Control, app.BabyStateManager)
-			} else if *m.Request.Type == client.RequestType_PUT_SETTINGS && m.Request.Settings != nil {
-				processStandby(babyUID, m.Request.Settings, app.BabyStateManager)
-			}
+	conn.RegisterMessageHandler(app.websocketMessageHandler(babyUID))
+
+	// Raw requests
+	untrackConnection := app.trackWebsocketConnection(babyUID, conn)
+	defer untrackConnection()
+
+	camera := client.NewCamera(conn)
+	cameraCtx, cancelCameraRequests := utils.ContextOf(childCtx)
+	defer cancelCameraRequests()
+
+	var unregisterCommandHandlers []func()
+
+	unregisterCommandHandlers = append(unregisterCommandHandlers,
+		app.MQTTConnection.RegisterLightHandler(babyUID, func(enabled bool) {
+			go sendLightCommand(cameraCtx, enabled, camera)
+		}),
+		app.MQTTConnection.RegisterStandyHandler(babyUID, func(enabled bool) {
+			go sendStandbyCommand(cameraCtx, enabled, camera)
+		}),
+	)
+
+	// Get the initial state of the light and sensor data, responses are processed by the message handler
+	go func() {
+		if _, err := camera.GetControl(cameraCtx, &client.GetControl{NightLight: utils.ConstRefBool(true)}); err != nil {
+			log.Warn().Err(err).Msg("Unable to get the initial state of the light")
 		}
-	})
+	}()
 
-	if app.Opts.MQTT != nil && app.MQTTConnection != nil {
-		app.MQTTConnection.RegisterLightHandler(func(enabled bool) {
-			sendLightCommand(enabled, conn)
-		})
-		app.MQTTConnection.RegisterStandyHandler(func(enabled bool) {
-			sendStandbyCommand(enabled, conn)
-		})
-	}
-
-	// Get the initial state of the light
-	conn.SendRequest(client.RequestType_GET_CONTROL, &client.Request{GetControl_: &client.GetControl{
-		NightLight: utils.ConstRefBool(true),
-	}})
-
-	// Ask for sensor data (initial request)
-	conn.SendRequest(client.RequestType_GET_SENSOR_DATA, &client.Request{
-		GetSensorData: &client.GetSensorData{
-			All: utils.ConstRefBool(true),
-		},
-	})
-
-	// Ask for status
-	// conn.SendRequest(client.RequestType_GET_STATUS, &client.Request{
-	// 	GetStatus_: &client.GetStatus{
-	// 		All: utils.ConstRefBool(true),
-	// 	},
-	// })
+	go func() {
+		if _, err := camera.GetSensorData(cameraCtx); err != nil {
+			log.Warn().Err(err).Msg("Unable to get the initial sensor data")
+		}
+	}()
 
 	// Ask for logs
 	// conn.SendRequest(client.RequestType_GET_LOGS, &client.Request{
@@ -187,13 +228,13 @@ func (app *App) runWebsocket(babyUID string, conn *client.WebsocketConnection, c
 	var cleanup func()
 
 	// Local streaming
-	if app.Opts.RTMP != nil {
+	if app.currentOpts().StreamingEnabled(babyUID) {
 		initializeLocalStreaming := func() {
-			requestLocalStreaming(babyUID, app.getLocalStreamURL(babyUID), client.Streaming_STARTED, conn, app.BabyStateManager)
+			requestLocalStreaming(cameraCtx, babyUID, app.getLocalStreamURL(babyUID), client.Streaming_STARTED, camera, app.BabyStateManager)
 		}
 
 		// Watch for stream liveness change
-		unsubscribe := app.BabyStateManager.Subscribe(func(updatedBabyUID string, stateUpdate baby.State) {
+		unsubscribe := app.BabyStateManager.SubscribeWithOpts(func(updatedBabyUID string, stateUpdate baby.State) {
 			// Do another streaming request if stream just turned unhealthy
 			if updatedBabyUID == babyUID && stateUpdate.StreamState != nil && *stateUpdate.StreamState == baby.StreamState_Unhealthy {
 				// Prevent duplicate request if we already received failure
@@ -201,7 +242,7 @@ func (app *App) runWebsocket(babyUID string, conn *client.WebsocketConnection, c
 					go initializeLocalStreaming()
 				}
 			}
-		})
+		}, baby.SubscribeOpts{Name: "streaming_" + babyUID})
 
 		cleanup = func() {
 			// Stop listening for stream liveness change
@@ -209,8 +250,9 @@ func (app *App) runWebsocket(babyUID string, conn *client.WebsocketConnection, c
 
 			// Stop local streaming
 			state := app.BabyStateManager.GetBabyState(babyUID)
+			// Note: connection context is done already, the request is bounded by its own timeout
 			if state.GetIsWebsocketAlive() && state.GetStreamState() == baby.StreamState_Alive {
-				requestLocalStreaming(babyUID, app.getLocalStreamURL(babyUID), client.Streaming_STOPPED, conn, app.BabyStateManager)
+				requestLocalStreaming(context.Background(), babyUID, app.getLocalStreamURL(babyUID), client.Streaming_STOPPED, camera, app.BabyStateManager)
 			}
 		}
 
@@ -219,24 +261,96 @@ func (app *App) runWebsocket(babyUID string, conn *client.WebsocketConnection, c
 		if babyState.GetStreamState() != baby.StreamState_Alive {
 			if babyState.GetStreamRequestState() != baby.StreamRequestState_Requested || babyState.GetStreamState() == baby.StreamState_Unhealthy {
 				go initializeLocalStreaming()
+			} else {
+				// Stream was requested already (ie. before restart), give the camera a chance to resume it
+				go func() {
+					select {
+					case <-childCtx.Done():
+					case <-time.After(streamRequestGracePeriod):
+						if app.BabyStateManager.GetBabyState(babyUID).GetStreamState() == baby.StreamState_Unknown {
+							log.Info().Str("baby_uid", babyUID).Msg("Requested stream did not arrive, requesting it again")
+							initializeLocalStreaming()
+						}
+					}
+				}()
 			}
 		}
 	}
 
 	<-childCtx.Done()
+	for _, unregister := range unregisterCommandHandlers {
+		unregister()
+	}
+
 	if cleanup != nil {
 		cleanup()
 	}
 }
 
+// websocketMessageHandler - returns handler which processes messages from the camera into the baby state
+func (app *App) websocketMessageHandler(babyUID string) client.WebsocketMessageHandler {
+	return func(m *client.Message, conn *client.WebsocketConnection) {
+		// Sensor request initiated by us on start (or some other client, we don't care)
+		if *m.Type == client.Message_RESPONSE && m.Response != nil {
+			if *m.Response.RequestType == client.RequestType_GET_SENSOR_DATA && len(m.Response.SensorData) > 0 {
+				processSensorData(babyUID, m.Response.SensorData, app.BabyStateManager)
+			} else if *m.Response.RequestType == client.RequestType_GET_CONTROL && m.Response.Control != nil {
+				processLight(babyUID, m.Response.Control, app.BabyStateManager)
+			} else if *m.Response.RequestType == client.RequestType_GET_SETTINGS && m.Response.Settings != nil {
+				processStandby(babyUID, m.Response.Settings, app.BabyStateManager)
+			}
+		} else
+
+		// Communication initiated from a cam
+		// Note: it sends the updates periodically on its own + whenever some significant change occurs
+		if *m.Type == client.Message_REQUEST && m.Request != nil {
+			if *m.Request.Type == client.RequestType_PUT_SENSOR_DATA && len(m.Request.SensorData_) > 0 {
+				processSensorData(babyUID, m.Request.SensorData_, app.BabyStateManager)
+			} else if *m.Request.Type == client.RequestType_PUT_CONTROL && m.Request.Control != nil {
+				processLight(babyUID, m.Request.Control, app.BabyStateManager)
+			} else if *m.Request.Type == client.RequestType_PUT_SETTINGS && m.Request.Settings != nil {
+				processStandby(babyUID, m.Request.Settings, app.BabyStateManager)
+			}
+		}
+	}
+}
+
+// startCapture - starts recording of the camera websocket messages, returns nil if the capture cannot be created
+func (app *App) startCapture(captureDir string, cameraUID string) *client.Capture {
+	if err := os.MkdirAll(captureDir, 0755); err != nil {
+		log.Error().Str("dir", captureDir).Err(err).Msg("Unable to create websocket capture directory")
+		return nil
+	}
+
+	filename := client.CaptureFilename(captureDir, cameraUID, time.Now())
+	capture, err := client.NewCapture(filename)
+	if err != nil {
+		log.Error().Str("filename", filename).Err(err).Msg("Unable to create websocket capture")
+		return nil
+	}
+
+	log.Info().Str("filename", filename).Msg("Capturing websocket messages")
+	return capture
+}
+
+// ReplayCapture - feeds captured messages into the same handlers as the live websocket, returns number of replayed messages
+// Useful for deterministic reproduction of camera behavior (see client.Capture)
+func (app *App) ReplayCapture(babyUID string, messages []client.CapturedMessage) int {
+	conn := client.NewReplayConnection()
+	conn.RegisterMessageHandler(app.websocketMessageHandler(babyUID))
+
+	return client.Replay(messages, conn)
+}
+
 func (app *App) getRemoteStreamURL(babyUID string) string {
-	return fmt.Sprintf("rtmps://media-secured.nanit.com/nanit/%v.%v", babyUID, app.SessionStore.Session.AuthToken)
+	authToken, _ := app.SessionStore.GetAuthToken()
+	return client.RemoteStreamURL(babyUID, authToken)
 }
 
 func (app *App) getLocalStreamURL(babyUID string) string {
-	if app.Opts.RTMP != nil {
+	if opts := app.currentOpts(); opts.RTMP != nil {
 		tpl := "rtmp://{publicAddr}/local/{babyUid}"
-		return strings.NewReplacer("{publicAddr}", app.Opts.RTMP.PublicAddr, "{babyUid}", babyUID).Replace(tpl)
+		return strings.NewReplacer("{publicAddr}", opts.RTMP.PublicAddr, "{babyUid}", babyUID).Replace(tpl)
 	}
 
 	return ""
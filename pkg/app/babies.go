@@ -0,0 +1,230 @@
+package app
+
+import (
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/rs/zerolog/log"
+)
+
+type babyRunner struct {
+	baby   baby.Baby
+	runner utils.GracefulRunner
+
+	// poller - event polling of the baby, nil if disabled
+	poller utils.GracefulRunner
+}
+
+func (r *babyRunner) stop() {
+	r.runner.Cancel()
+	if r.poller != nil {
+		r.poller.Cancel()
+	}
+}
+
+// RefreshBabies - requests refresh of the babies list, does not wait for it to finish
+func (app *App) RefreshBabies() {
+	select {
+	case app.refreshBabiesC <- struct{}{}:
+	default:
+		// Refresh already pending
+	}
+}
+
+// GetBabies - returns babies which are currently being handled
+func (app *App) GetBabies() []baby.Baby {
+	app.babiesMu.Lock()
+	defer app.babiesMu.Unlock()
+
+	babies := make([]baby.Baby, 0, len(app.babyRunners))
+	for _, r := range app.babyRunners {
+		babies = append(babies, r.baby)
+	}
+
+	return babies
+}
+
+// watchBabies - refreshes the babies list periodically and on demand until the context is cancelled
+func (app *App) watchBabies(ctx utils.GracefulContext) {
+	var tickerC <-chan time.Time
+	if interval := app.currentOpts().BabiesRefreshInterval; interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tickerC = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tickerC:
+			app.refreshBabies()
+		case <-app.refreshBabiesC:
+			app.refreshBabies()
+		}
+	}
+}
+
+func (app *App) refreshBabies() {
+	babies, err := app.RestClient.FetchBabies()
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to refresh babies list")
+		return
+	}
+
+	app.syncBabies(babies)
+}
+
+// syncBabies - starts handling of new babies and cancels handling of babies which are gone
+// Babies whose info changed (ie. re-paired camera) are restarted, state of the babies which are gone is removed
+func (app *App) syncBabies(babies []baby.Baby) {
+	wanted := make(map[string]baby.Baby, len(babies))
+	for _, babyInfo := range babies {
+		if !baby.IsValidBabyUID(babyInfo.UID) {
+			log.Error().Str("baby_uid", babyInfo.UID).Msg("Baby UID contains unsafe characters, skipping the baby")
+			continue
+		}
+
+		wanted[babyInfo.UID] = babyInfo
+	}
+
+	app.babiesMu.Lock()
+
+	var stopped []*babyRunner
+	for babyUID, r := range app.babyRunners {
+		if babyInfo, ok := wanted[babyUID]; ok && babyInfo == r.baby {
+			continue
+		}
+
+		log.Info().Str("baby_uid", babyUID).Str("camera_uid", r.baby.CameraUID).Msg("Baby removed or changed, stopping its handling")
+		stopped = append(stopped, r)
+		delete(app.babyRunners, babyUID)
+	}
+
+	app.babiesMu.Unlock()
+
+	// Cancel waits for the handlers to finish, they might need babiesMu in the meantime
+	for _, r := range stopped {
+		r.stop()
+	}
+
+	// Includes the state restored from the snapshot, so that retained MQTT topics of babies removed
+	// while the app was not running get cleared too
+	for babyUID := range app.BabyStateManager.GetBabyStates() {
+		if _, ok := wanted[babyUID]; !ok {
+			log.Info().Str("baby_uid", babyUID).Msg("Removing state of the baby which is gone")
+			app.BabyStateManager.RemoveBaby(babyUID)
+		}
+	}
+
+	app.babiesMu.Lock()
+	defer app.babiesMu.Unlock()
+
+	for babyUID, babyInfo := range wanted {
+		if _, ok := app.babyRunners[babyUID]; ok {
+			continue
+		}
+
+		log.Info().Str("baby_uid", babyUID).Str("camera_uid", babyInfo.CameraUID).Str("name", babyInfo.Name).Msg("Starting handling of a baby")
+		app.startBaby(babyInfo)
+	}
+}
+
+// restartBabies - restarts handling of babies matching the filter, returns number of restarted babies
+func (app *App) restartBabies(filter func(babyUID string) bool) int {
+	app.babiesMu.Lock()
+
+	var stopped []*babyRunner
+	for babyUID, r := range app.babyRunners {
+		if filter(babyUID) {
+			stopped = append(stopped, r)
+		}
+	}
+
+	app.babiesMu.Unlock()
+
+	// Stopped outside of the lock, see syncBabies
+	for _, r := range stopped {
+		log.Info().Str("baby_uid", r.baby.UID).Str("camera_uid", r.baby.CameraUID).Msg("Restarting handling of a baby to apply new configuration")
+		r.stop()
+	}
+
+	app.babiesMu.Lock()
+	defer app.babiesMu.Unlock()
+
+	restarted := 0
+	for _, r := range stopped {
+		// Baby might have been removed or changed in the meantime
+		if app.babyRunners[r.baby.UID] != r {
+			continue
+		}
+
+		app.startBaby(r.baby)
+		restarted++
+	}
+
+	return restarted
+}
+
+// restartEventPollers - restarts event polling of all babies with the current options
+func (app *App) restartEventPollers() {
+	app.babiesMu.Lock()
+
+	pollers := make(map[*babyRunner]utils.GracefulRunner, len(app.babyRunners))
+	for _, r := range app.babyRunners {
+		pollers[r] = r.poller
+	}
+
+	app.babiesMu.Unlock()
+
+	// Stopped outside of the lock, see syncBabies
+	for _, poller := range pollers {
+		if poller != nil {
+			poller.Cancel()
+		}
+	}
+
+	app.babiesMu.Lock()
+	defer app.babiesMu.Unlock()
+
+	for babyUID, r := range app.babyRunners {
+		// Runner might have been replaced or its poller restarted in the meantime
+		if poller, ok := pollers[r]; !ok || poller != r.poller {
+			continue
+		}
+
+		r.poller = app.startEventPoller(babyUID)
+	}
+}
+
+// startBaby - starts handling of the baby within the app context
+// Note: babiesMu must be held
+func (app *App) startBaby(babyInfo baby.Baby) {
+	app.babyRunners[babyInfo.UID] = &babyRunner{
+		baby: babyInfo,
+		runner: app.ctx.RunAsChild(func(childCtx utils.GracefulContext) {
+			app.handleBaby(babyInfo, childCtx)
+		}),
+		poller: app.startEventPoller(babyInfo.UID),
+	}
+}
+
+func (app *App) startEventPoller(babyUID string) utils.GracefulRunner {
+	if !app.currentOpts().EventPolling.Enabled {
+		return nil
+	}
+
+	return app.ctx.RunAsChild(func(childCtx utils.GracefulContext) {
+		app.runEventPoller(babyUID, childCtx)
+	})
+}
+
+// hasBaby - returns true if the baby is currently being handled
+func (app *App) hasBaby(babyUID string) bool {
+	app.babiesMu.Lock()
+	defer app.babiesMu.Unlock()
+
+	_, ok := app.babyRunners[babyUID]
+	return ok
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/message"
+	"github.com/rs/zerolog/log"
+)
+
+// detailedEventTypes - message types whose event details are fetched, each costs an extra request
+// Sound and motion messages are by far the most frequent ones and their time is all that is used
+var detailedEventTypes = map[string]bool{
+	message.TemperatureEventMessageType:   true,
+	message.HumidityEventMessageType:      true,
+	message.CameraOfflineEventMessageType: true,
+	message.BreathingEventMessageType:     true,
+	message.StandingEventMessageType:      true,
+}
+
+// fetchEventDetails - attaches event details to the message if it references one and its type needs them
+func (app *App) fetchEventDetails(babyUID string, msg *message.Message) {
+	if !detailedEventTypes[msg.Type] {
+		return
+	}
+
+	payload, err := msg.Payload()
+	if err != nil {
+		// Generic payload is still returned, it might reference the event
+		log.Warn().Str("baby_uid", babyUID).Int("message_id", msg.Id).Str("type", msg.Type).Err(err).Msg("Unable to parse event message data")
+	}
+
+	eventUID := payload.GetEventUID()
+	if eventUID == "" {
+		return
+	}
+
+	event, err := app.RestClient.FetchEvent(babyUID, eventUID)
+	if err != nil {
+		log.Warn().Str("baby_uid", babyUID).Str("event_uid", eventUID).Err(err).Msg("Unable to fetch event details")
+		return
+	}
+
+	msg.Event = event
+}
+
+func processEventMessage(babyUID string, msg message.Message, stateManager *baby.StateManager) {
+	timestamp := int32(msg.Time.Unix())
+
+	stateUpdate := baby.State{}
+	switch msg.Type {
+	case message.SoundEventMessageType:
+		stateUpdate.SetSoundTimestamp(timestamp)
+	case message.MotionEventMessageType:
+		stateUpdate.SetMotionTimestamp(timestamp)
+	case message.TemperatureEventMessageType:
+		stateUpdate.SetTemperatureAlertTimestamp(timestamp)
+	case message.HumidityEventMessageType:
+		stateUpdate.SetHumidityAlertTimestamp(timestamp)
+	case message.CameraOfflineEventMessageType:
+		stateUpdate.SetCameraOfflineTimestamp(timestamp)
+	case message.BreathingEventMessageType:
+		stateUpdate.SetBreathingAlertTimestamp(timestamp)
+	case message.StandingEventMessageType:
+		stateUpdate.SetStandingTimestamp(timestamp)
+	default:
+		log.Debug().Str("baby_uid", babyUID).Int("message_id", msg.Id).Str("type", msg.Type).Msg("Event message of unknown type, not updating the state")
+		stateManager.NotifyEvent(babyUID, newEvent(msg))
+		return
+	}
+
+	stateManager.Update(babyUID, stateUpdate)
+	stateManager.NotifyEvent(babyUID, newEvent(msg))
+}
+
+func newEvent(msg message.Message) baby.Event {
+	event := baby.Event{
+		Type:      msg.Type,
+		Time:      msg.Time.Time(),
+		MessageID: msg.Id,
+	}
+
+	if len(msg.Data) > 0 && string(msg.Data) != "null" {
+		event.Data = msg.Data
+	}
+
+	if msg.Event != nil {
+		if details, err := json.Marshal(msg.Event); err == nil {
+			event.Details = details
+		}
+	}
+
+	return event
+}
+
+// UpdateMessages - marks messages of a baby as seen, read or dismissed
+func (app *App) UpdateMessages(babyUID string, action message.Action, messageIDs []int) error {
+	if !app.hasBaby(babyUID) {
+		return fmt.Errorf("Unknown baby %v", babyUID)
+	}
+
+	return app.RestClient.UpdateMessages(babyUID, messageIDs, action)
+}
+
+// markMessagesSeen - marks processed messages which were not seen yet as seen
+func (app *App) markMessagesSeen(babyUID string, messages []message.Message) {
+	messageIDs := make([]int, 0, len(messages))
+	for _, msg := range messages {
+		if msg.SeenAt == nil || msg.SeenAt.IsZero() {
+			messageIDs = append(messageIDs, msg.Id)
+		}
+	}
+
+	if len(messageIDs) > 0 {
+		app.RestClient.UpdateMessages(babyUID, messageIDs, message.SeenAction)
+	}
+}
@@ -0,0 +1,197 @@
+package app
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/message"
+	"github.com/indiefan/home_assistant_nanit/pkg/metrics"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// eventPoller - keeps track of event message polling of a single baby
+type eventPoller struct {
+	babyUID string
+
+	mu           sync.RWMutex
+	lastSuccess  time.Time
+	lastDuration time.Duration
+	gapSince     time.Time
+
+	// handledIDs - messages handled while the cursor is held back by a gap, so that they are not handled again
+	handledIDs map[int]bool
+}
+
+// EventPollStatus - snapshot of event poller health
+type EventPollStatus struct {
+	LastSuccess  time.Time
+	LastDuration time.Duration
+
+	// GapSince - since when the messages since the cursor cannot be fetched completely (zero if they can)
+	GapSince time.Time
+}
+
+func (poller *eventPoller) markSuccess(at time.Time, duration time.Duration) {
+	poller.mu.Lock()
+	poller.lastSuccess = at
+	poller.lastDuration = duration
+	poller.mu.Unlock()
+
+	metrics.EventPollLastSuccess.Set(float64(at.Unix()), poller.babyUID)
+	metrics.EventPollDuration.Set(duration.Seconds(), poller.babyUID)
+}
+
+// markGap - records whether the last poll fetched all the messages since the cursor
+func (poller *eventPoller) markGap(gap bool, at time.Time) {
+	poller.mu.Lock()
+	defer poller.mu.Unlock()
+
+	if !gap {
+		poller.gapSince = time.Time{}
+	} else if poller.gapSince.IsZero() {
+		poller.gapSince = at
+	}
+}
+
+func (poller *eventPoller) status() EventPollStatus {
+	poller.mu.RLock()
+	defer poller.mu.RUnlock()
+
+	return EventPollStatus{
+		LastSuccess:  poller.lastSuccess,
+		LastDuration: poller.lastDuration,
+		GapSince:     poller.gapSince,
+	}
+}
+
+// unhandledMessages - filters out the messages handled by the previous polls while the cursor was held back
+// Note: handledIDs are used by the polling goroutine only
+func (poller *eventPoller) unhandledMessages(messages []message.Message) []message.Message {
+	unhandled := make([]message.Message, 0, len(messages))
+	for _, msg := range messages {
+		if !poller.handledIDs[msg.Id] {
+			unhandled = append(unhandled, msg)
+		}
+	}
+
+	return unhandled
+}
+
+// holdMessages - remembers the handled messages instead of moving the cursor past them
+// Only IDs of the current batch are kept, the older ones cannot be fetched again
+func (poller *eventPoller) holdMessages(messages []message.Message) {
+	poller.handledIDs = make(map[int]bool, len(messages))
+	for _, msg := range messages {
+		poller.handledIDs[msg.Id] = true
+	}
+}
+
+// GetEventPollStatus - returns status of event polling for given baby, false if the baby is not being polled
+func (app *App) GetEventPollStatus(babyUID string) (EventPollStatus, bool) {
+	app.eventPollersMu.RLock()
+	poller, ok := app.eventPollers[babyUID]
+	app.eventPollersMu.RUnlock()
+
+	if !ok {
+		return EventPollStatus{}, false
+	}
+
+	return poller.status(), true
+}
+
+// runEventPoller - polls event messages of a baby until the context is cancelled, backing off on errors
+func (app *App) runEventPoller(babyUID string, ctx utils.GracefulContext) {
+	poller := &eventPoller{babyUID: babyUID}
+
+	app.eventPollersMu.Lock()
+	app.eventPollers[babyUID] = poller
+	app.eventPollersMu.Unlock()
+
+	defer func() {
+		app.eventPollersMu.Lock()
+		if app.eventPollers[babyUID] == poller {
+			delete(app.eventPollers, babyUID)
+		}
+		app.eventPollersMu.Unlock()
+	}()
+
+	utils.RunWithPerseverance(func(attempt utils.AttemptContext) {
+		app.pollEvents(poller, attempt)
+	}, ctx, utils.PerseverenceOpts{
+		RunnerID:       fmt.Sprintf("events-%v", babyUID),
+		ResetThreshold: 5 * time.Minute,
+		Cooldown: []time.Duration{
+			30 * time.Second,
+			1 * time.Minute,
+			5 * time.Minute,
+			15 * time.Minute,
+		},
+	})
+}
+
+func (app *App) pollEvents(poller *eventPoller, attempt utils.AttemptContext) {
+	babyUID := poller.babyUID
+
+	for {
+		opts := app.currentOpts().EventPolling
+		start := time.Now()
+		fetchedMessages, complete, err := app.RestClient.FetchNewMessages(babyUID, opts.MessageTimeout)
+		if err != nil {
+			log.Error().Str("baby_uid", babyUID).Err(err).Msg("Unable to fetch new messages")
+			metrics.EventPollFailures.Inc(babyUID)
+			attempt.Fail(err)
+			return
+		}
+
+		poller.markSuccess(start, time.Since(start))
+		poller.markGap(!complete, start)
+
+		newMessages := poller.unhandledMessages(fetchedMessages)
+		for i := range newMessages {
+			app.fetchEventDetails(babyUID, &newMessages[i])
+			processEventMessage(babyUID, newMessages[i], app.BabyStateManager)
+		}
+
+		// Cursor is moved only once the messages are handled, otherwise they would be lost on restart
+		if !app.BabyStateManager.WaitForEvents(attempt) {
+			return
+		}
+
+		if complete {
+			app.RestClient.CommitMessages(babyUID, fetchedMessages)
+			poller.holdMessages(nil)
+		} else {
+			// Moving the cursor past the gap would lose the missing messages for good
+			metrics.EventMessageGaps.Inc(babyUID)
+			poller.holdMessages(fetchedMessages)
+		}
+
+		if opts.AutoMarkSeen {
+			app.markMessagesSeen(babyUID, newMessages)
+		}
+
+		// wait for the specified interval
+		select {
+		case <-attempt.Done():
+			return
+		case <-time.After(withJitter(opts.PollingInterval, opts.PollingJitter)):
+		}
+	}
+}
+
+// withJitter - returns duration randomly shifted by up to +/- jitter
+func withJitter(d time.Duration, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return d
+	}
+
+	shifted := d + time.Duration(rand.Int63n(int64(2*jitter))) - jitter
+	if shifted < 0 {
+		return 0
+	}
+
+	return shifted
+}
@@ -0,0 +1,195 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/client"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded"
+	healthFailing  = "failing"
+)
+
+// ComponentHealth - health of a single component
+type ComponentHealth struct {
+	Status  string     `json:"status"`
+	Message string     `json:"message,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+// HealthReport - health breakdown of the whole application
+type HealthReport struct {
+	Status     string                                `json:"status"`
+	Components map[string]ComponentHealth            `json:"components"`
+	Babies     map[string]map[string]ComponentHealth `json:"babies"`
+}
+
+// healthTracker - remembers since when is the websocket / stream of a baby in its current state
+type healthTracker struct {
+	mu             sync.RWMutex
+	startedAt      time.Time
+	websocketSince map[string]time.Time
+	streamSince    map[string]time.Time
+}
+
+func newHealthTracker() *healthTracker {
+	return &healthTracker{
+		startedAt:      time.Now(),
+		websocketSince: make(map[string]time.Time),
+		streamSince:    make(map[string]time.Time),
+	}
+}
+
+func (tracker *healthTracker) handleStateUpdate(babyUID string, state baby.State) {
+	now := time.Now()
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if state.IsWebsocketAlive != nil {
+		tracker.websocketSince[babyUID] = now
+	}
+
+	if state.StreamState != nil {
+		tracker.streamSince[babyUID] = now
+	}
+}
+
+func (tracker *healthTracker) since(babyUID string, m map[string]time.Time) time.Time {
+	tracker.mu.RLock()
+	defer tracker.mu.RUnlock()
+
+	if since, ok := m[babyUID]; ok {
+		return since
+	}
+
+	return tracker.startedAt
+}
+
+// Health - evaluates health of all components against configured thresholds
+func (app *App) Health() HealthReport {
+	now := time.Now()
+	appOpts := app.currentOpts()
+	opts := appOpts.Health
+	report := HealthReport{
+		Status:     healthOK,
+		Components: make(map[string]ComponentHealth),
+		Babies:     make(map[string]map[string]ComponentHealth),
+	}
+
+	degrade := func(status string) {
+		if status == healthFailing || (status == healthDegraded && report.Status == healthOK) {
+			report.Status = status
+		}
+	}
+
+	// Authorization
+	if authToken, authTime := app.SessionStore.GetAuthToken(); authToken == "" {
+		report.Components["auth"] = ComponentHealth{Status: healthFailing, Message: "Not authorized"}
+	} else if now.Sub(authTime) > client.AuthTokenTimelife {
+		report.Components["auth"] = ComponentHealth{Status: healthDegraded, Message: "Auth token expired, it will be renewed on the next request", Since: &authTime}
+	} else {
+		report.Components["auth"] = ComponentHealth{Status: healthOK, Since: &authTime}
+	}
+	degrade(report.Components["auth"].Status)
+
+	// MQTT
+	if appOpts.MQTT != nil {
+		if app.MQTTConnection.IsConnected() {
+			report.Components["mqtt"] = ComponentHealth{Status: healthOK}
+		} else {
+			report.Components["mqtt"] = ComponentHealth{Status: healthFailing, Message: "Not connected to the broker"}
+		}
+		degrade(report.Components["mqtt"].Status)
+	}
+
+	// Babies
+	for _, babyInfo := range app.GetBabies() {
+		babyUID := babyInfo.UID
+		state := app.BabyStateManager.GetBabyState(babyUID)
+		components := make(map[string]ComponentHealth)
+
+		if appOpts.needsWebsocket() {
+			since := app.health.since(babyUID, app.health.websocketSince)
+			components["websocket"] = thresholdHealth(state.GetIsWebsocketAlive(), since, now, opts.WebsocketGracePeriod, "Websocket is not connected")
+		}
+
+		if appOpts.StreamingEnabled(babyUID) {
+			since := app.health.since(babyUID, app.health.streamSince)
+			components["stream"] = thresholdHealth(state.GetStreamState() == baby.StreamState_Alive, since, now, opts.StreamGracePeriod, "Stream is not alive")
+		}
+
+		if appOpts.EventPolling.Enabled {
+			status, ok := app.GetEventPollStatus(babyUID)
+			lastSuccess := status.LastSuccess
+			if !ok || lastSuccess.IsZero() {
+				lastSuccess = app.health.startedAt
+			}
+
+			if now.Sub(lastSuccess) > opts.EventPollMaxAge {
+				components["event_poll"] = ComponentHealth{Status: healthFailing, Message: fmt.Sprintf("No successful poll for more than %v", opts.EventPollMaxAge), Since: &lastSuccess}
+			} else if status.LastSuccess.IsZero() {
+				components["event_poll"] = ComponentHealth{Status: healthDegraded, Message: "No successful poll yet"}
+			} else if gapSince := status.GapSince; !gapSince.IsZero() {
+				components["event_poll"] = ComponentHealth{Status: healthDegraded, Message: "Not all messages since the cursor can be fetched, the cursor is held back", Since: &gapSince}
+			} else {
+				components["event_poll"] = ComponentHealth{Status: healthOK, Since: &lastSuccess}
+			}
+		}
+
+		for _, component := range components {
+			degrade(component.Status)
+		}
+
+		report.Babies[babyUID] = components
+	}
+
+	return report
+}
+
+// thresholdHealth - component is degraded if it is not ok within the grace period and failing afterwards
+func thresholdHealth(isOK bool, since time.Time, now time.Time, gracePeriod time.Duration, message string) ComponentHealth {
+	if isOK {
+		return ComponentHealth{Status: healthOK, Since: &since}
+	}
+
+	if now.Sub(since) > gracePeriod {
+		return ComponentHealth{Status: healthFailing, Message: message, Since: &since}
+	}
+
+	return ComponentHealth{Status: healthDegraded, Message: message, Since: &since}
+}
+
+// Liveness - process is alive and able to respond, the breakdown is informational
+func (app *App) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, app.Health())
+}
+
+// Readiness - every component is within the configured thresholds
+func (app *App) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := app.Health()
+
+	code := http.StatusOK
+	if report.Status == healthFailing {
+		code = http.StatusServiceUnavailable
+	}
+
+	writeHealth(w, code, report)
+}
+
+func writeHealth(w http.ResponseWriter, code int, report HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Error().Err(err).Msg("Unable to write health report")
+	}
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/history"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultHistoryFields - fields returned if the query does not specify any
+var defaultHistoryFields = []string{"temperature", "humidity"}
+
+type historyResponse struct {
+	BabyUID string                     `json:"baby_uid"`
+	From    time.Time                  `json:"from"`
+	To      time.Time                  `json:"to"`
+	Step    string                     `json:"step,omitempty"`
+	Series  map[string][]history.Point `json:"series"`
+}
+
+// handleHistory - GET /babies/{babyUID}/history?fields=temperature,humidity&from=RFC3339&to=RFC3339&step=5m
+// Range defaults to the last 24 hours, without step the raw samples are returned
+func (app *App) handleHistory(w http.ResponseWriter, r *http.Request) {
+	store := app.historyStore()
+	if store == nil {
+		http.Error(w, "History is not enabled", http.StatusNotFound)
+		return
+	}
+
+	babyUID := r.PathValue("babyUID")
+	if !app.hasBaby(babyUID) {
+		http.Error(w, "Unknown baby", http.StatusNotFound)
+		return
+	}
+
+	params := r.URL.Query()
+	q := history.Query{
+		BabyUID: babyUID,
+		Fields:  defaultHistoryFields,
+		To:      time.Now().UTC(),
+	}
+
+	if fields := params.Get("fields"); fields != "" {
+		q.Fields = strings.Split(fields, ",")
+	}
+
+	var err error
+	if to := params.Get("to"); to != "" {
+		if q.To, err = time.Parse(time.RFC3339, to); err != nil {
+			http.Error(w, "Invalid 'to', expected RFC3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+
+	q.From = q.To.Add(-24 * time.Hour)
+	if from := params.Get("from"); from != "" {
+		if q.From, err = time.Parse(time.RFC3339, from); err != nil {
+			http.Error(w, "Invalid 'from', expected RFC3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if step := params.Get("step"); step != "" {
+		if q.Step, err = time.ParseDuration(step); err != nil || q.Step < 0 {
+			http.Error(w, "Invalid 'step', expected duration (ie. 5m)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	series, err := store.Query(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := historyResponse{
+		BabyUID: babyUID,
+		From:    q.From,
+		To:      q.To,
+		Series:  series,
+	}
+
+	if q.Step > 0 {
+		response.Step = q.Step.String()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error().Err(err).Msg("Unable to write history response")
+	}
+}
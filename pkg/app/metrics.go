@@ -0,0 +1,60 @@
+package app
+
+import (
+	"sort"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/metrics"
+)
+
+type babyGauge struct {
+	name  string
+	help  string
+	value func(state *baby.State) (float64, bool)
+}
+
+var babyGauges = []babyGauge{
+	{"nanit_temperature_celsius", "Temperature measured by the camera", func(s *baby.State) (float64, bool) {
+		return s.GetTemperature(), s.TemperatureMilli != nil
+	}},
+	{"nanit_humidity_percent", "Humidity measured by the camera", func(s *baby.State) (float64, bool) {
+		return s.GetHumidity(), s.HumidityMilli != nil
+	}},
+	{"nanit_night_mode", "Flag if the camera is in the night mode", func(s *baby.State) (float64, bool) {
+		return metrics.BoolValue(s.IsNight != nil && *s.IsNight), s.IsNight != nil
+	}},
+	{"nanit_night_light", "Flag if the night light is on", func(s *baby.State) (float64, bool) {
+		return metrics.BoolValue(s.GetNightLight()), s.NightLight != nil
+	}},
+	{"nanit_standby", "Flag if the camera is in standby", func(s *baby.State) (float64, bool) {
+		return metrics.BoolValue(s.GetStandby()), s.Standby != nil
+	}},
+	{"nanit_stream_state", "Local stream state (0 = unknown, 1 = unhealthy, 2 = alive)", func(s *baby.State) (float64, bool) {
+		return float64(s.GetStreamState()), true
+	}},
+	{"nanit_websocket_alive", "Flag if the websocket connection to the camera is alive", func(s *baby.State) (float64, bool) {
+		return metrics.BoolValue(s.GetIsWebsocketAlive()), s.IsWebsocketAlive != nil
+	}},
+}
+
+// collectMetrics - writes per baby gauges derived from the current state
+func (app *App) collectMetrics(w *metrics.Writer) {
+	states := app.BabyStateManager.GetBabyStates()
+
+	babyUIDs := make([]string, 0, len(states))
+	for babyUID := range states {
+		babyUIDs = append(babyUIDs, babyUID)
+	}
+	sort.Strings(babyUIDs)
+
+	for _, gauge := range babyGauges {
+		w.Header(gauge.name, gauge.help, "gauge")
+
+		for _, babyUID := range babyUIDs {
+			state := states[babyUID]
+			if value, ok := gauge.value(&state); ok {
+				w.Sample(gauge.name, value, "baby_uid", babyUID)
+			}
+		}
+	}
+}
@@ -1,7 +1,13 @@
 package app
 
 import (
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/history"
+	"github.com/indiefan/home_assistant_nanit/pkg/influx"
 	"github.com/indiefan/home_assistant_nanit/pkg/mqtt"
+	"github.com/indiefan/home_assistant_nanit/pkg/report"
+	"github.com/indiefan/home_assistant_nanit/pkg/sleep"
+	"github.com/indiefan/home_assistant_nanit/pkg/webhook"
 	"time"
 )
 
@@ -9,11 +15,57 @@ import (
 type Opts struct {
 	NanitCredentials NanitCredentials
 	SessionFile      string
+	SessionKey       []byte
 	DataDirectories  DataDirectories
 	HTTPEnabled      bool
 	MQTT             *mqtt.Opts
 	RTMP             *RTMPOpts
 	EventPolling     EventPollingOpts
+	Webhooks         *webhook.Opts
+	Influx           *influx.Opts
+	History          *history.Opts
+	Report           *report.Opts
+	Sleep            *sleep.Opts
+	Health           HealthOpts
+
+	// BabiesRefreshInterval - how often the babies list is refetched (0 = only on demand)
+	BabiesRefreshInterval time.Duration
+
+	// AdminToken - token required by raw camera requests over HTTP and MQTT (empty = disabled)
+	AdminToken string
+
+	// WebsocketCaptureDir - directory where all camera websocket messages are recorded (empty = disabled)
+	WebsocketCaptureDir string
+
+	// Staleness - TTLs of the baby state fields, stale fields are reported as unavailable
+	Staleness baby.StalenessOpts
+
+	// Babies - per baby overrides (by baby UID)
+	Babies map[string]BabyOpts
+}
+
+// BabyOpts - per baby overrides
+type BabyOpts struct {
+	// Streaming - enables / disables local streaming of the baby (nil = enabled if RTMP is enabled)
+	Streaming *bool
+}
+
+// StreamingEnabled - checks whether the baby should be streamed to the local RTMP server
+func (opts Opts) StreamingEnabled(babyUID string) bool {
+	if opts.RTMP == nil {
+		return false
+	}
+
+	if babyOpts, ok := opts.Babies[babyUID]; ok && babyOpts.Streaming != nil {
+		return *babyOpts.Streaming
+	}
+
+	return true
+}
+
+// needsWebsocket - checks whether there is any consumer of the camera websocket
+func (opts Opts) needsWebsocket() bool {
+	return opts.RTMP != nil || opts.MQTT != nil
 }
 
 // NanitCredentials - user credentials for Nanit account
@@ -39,8 +91,23 @@ type RTMPOpts struct {
 	PublicAddr string
 }
 
+// HealthOpts - thresholds after which components are considered failing (bridge not ready)
+type HealthOpts struct {
+	// WebsocketGracePeriod - how long can be websocket disconnected
+	WebsocketGracePeriod time.Duration
+	// StreamGracePeriod - how long can be the local stream not alive
+	StreamGracePeriod time.Duration
+	// EventPollMaxAge - maximal age of the last successful event poll
+	EventPollMaxAge time.Duration
+}
+
+// EventPollingOpts - options for polling of event messages
 type EventPollingOpts struct {
 	Enabled         bool
 	PollingInterval time.Duration
-	MessageTimeout  time.Duration
+	// PollingJitter - maximal random shift of the polling interval (both directions)
+	PollingJitter  time.Duration
+	MessageTimeout time.Duration
+	// AutoMarkSeen - marks processed messages as seen in the Nanit app
+	AutoMarkSeen bool
 }
@@ -0,0 +1,241 @@
+package app
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/client"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	// rawRequestDefaultTimeout - how long to wait for the camera response if not specified
+	rawRequestDefaultTimeout = 10 * time.Second
+	// rawRequestMaxTimeout - upper limit of the requested timeout
+	rawRequestMaxTimeout = 2 * time.Minute
+)
+
+var (
+	// ErrUnknownRequestType - request type is not defined in the protocol
+	ErrUnknownRequestType = errors.New("unknown request type")
+	// ErrInvalidRequest - request body is not a valid protojson Request
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrCameraNotConnected - there is no ready websocket connection to the camera
+	ErrCameraNotConnected = errors.New("camera is not connected")
+	// ErrRequestTimeout - camera did not respond in time
+	ErrRequestTimeout = errors.New("request timeout")
+	// ErrRequestFailed - camera responded with non-200 status
+	ErrRequestFailed = errors.New("request failed")
+)
+
+// RawResponse - result of the raw request
+type RawResponse struct {
+	// Response - camera response in protojson, present also for failed requests if the camera responded
+	Response json.RawMessage `json:"response,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// rawMQTTRequest - payload of {prefix}/babies/{baby_uid}/requests/send
+type rawMQTTRequest struct {
+	// ID - optional identifier copied to the reply
+	ID      string          `json:"id,omitempty"`
+	Token   string          `json:"token"`
+	Type    string          `json:"type"`
+	Request json.RawMessage `json:"request,omitempty"`
+	Timeout string          `json:"timeout,omitempty"`
+}
+
+// rawMQTTReply - payload of {prefix}/babies/{baby_uid}/requests/reply
+type rawMQTTReply struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type"`
+	RawResponse
+}
+
+// ParseRequestType - accepts request type name (ie. GET_BANDWIDTH) or its number
+func ParseRequestType(value string) (client.RequestType, error) {
+	if number, err := strconv.Atoi(value); err == nil {
+		if _, ok := client.RequestType_name[int32(number)]; ok {
+			return client.RequestType(number), nil
+		}
+	} else if number, ok := client.RequestType_value[strings.ToUpper(value)]; ok {
+		return client.RequestType(number), nil
+	}
+
+	return 0, fmt.Errorf("%w: %v", ErrUnknownRequestType, value)
+}
+
+// SendRawRequest - sends request of any type to the camera of the baby
+// Request body is protojson of client.Request (id and type are filled in), empty body means an empty request
+func (app *App) SendRawRequest(babyUID string, requestType client.RequestType, requestJSON []byte, timeout time.Duration) (RawResponse, error) {
+	request := &client.Request{}
+	if len(strings.TrimSpace(string(requestJSON))) > 0 {
+		if err := (protojson.UnmarshalOptions{AllowPartial: true}).Unmarshal(requestJSON, request); err != nil {
+			return RawResponse{Error: err.Error()}, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+		}
+	}
+
+	conn := app.websocketConnection(babyUID)
+	if conn == nil {
+		return RawResponse{Error: ErrCameraNotConnected.Error()}, ErrCameraNotConnected
+	}
+
+	if timeout <= 0 {
+		timeout = rawRequestDefaultTimeout
+	} else if timeout > rawRequestMaxTimeout {
+		timeout = rawRequestMaxTimeout
+	}
+
+	log.Info().Str("baby_uid", babyUID).Stringer("type", requestType).Msg("Sending raw request to the camera")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	res, err := conn.Do(ctx, requestType, request)
+
+	result := RawResponse{}
+	if res != nil {
+		result.Response, _ = protojson.Marshal(res)
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+
+		switch {
+		case errors.Is(err, client.ErrConnectionClosed):
+			return result, fmt.Errorf("%w: %v", ErrCameraNotConnected, err)
+		case errors.Is(err, client.ErrRequestTimeout):
+			return result, fmt.Errorf("%w: %v", ErrRequestTimeout, err)
+		default:
+			return result, fmt.Errorf("%w: %v", ErrRequestFailed, err)
+		}
+	}
+
+	return result, nil
+}
+
+// isAdmin - checks the admin token, passthrough is disabled without configured token
+func (app *App) isAdmin(token string) bool {
+	adminToken := app.currentOpts().AdminToken
+	return adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// handleRawRequest - POST /babies/{babyUID}/requests/{requestType}?timeout=10s
+// Body: protojson client.Request, requires Authorization: Bearer {admin token}
+func (app *App) handleRawRequest(w http.ResponseWriter, r *http.Request) {
+	if app.currentOpts().AdminToken == "" {
+		http.Error(w, "Raw requests are disabled, admin token is not configured", http.StatusNotFound)
+		return
+	}
+
+	if !app.isAdmin(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	babyUID := r.PathValue("babyUID")
+	if !app.hasBaby(babyUID) {
+		http.Error(w, "Unknown baby", http.StatusNotFound)
+		return
+	}
+
+	requestType, err := ParseRequestType(r.PathValue("requestType"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	timeout, err := parseRawRequestTimeout(r.URL.Query().Get("timeout"))
+	if err != nil {
+		http.Error(w, "Invalid timeout", http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Unable to read request body", http.StatusBadRequest)
+		return
+	}
+
+	result, err := app.SendRawRequest(babyUID, requestType, body, timeout)
+
+	status := http.StatusOK
+	switch {
+	case errors.Is(err, ErrInvalidRequest):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrCameraNotConnected):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, ErrRequestTimeout):
+		status = http.StatusGatewayTimeout
+	case errors.Is(err, ErrRequestFailed):
+		status = http.StatusBadGateway
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleMQTTRawRequest - handles raw request received over MQTT, returns JSON reply
+func (app *App) handleMQTTRawRequest(babyUID string, payload []byte) []byte {
+	var req rawMQTTRequest
+	reply := rawMQTTReply{}
+
+	if err := json.Unmarshal(payload, &req); err != nil {
+		reply.Error = "Invalid payload: " + err.Error()
+	} else if reply.ID, reply.Type = req.ID, req.Type; !app.isAdmin(req.Token) {
+		reply.Error = "Unauthorized"
+	} else if !app.hasBaby(babyUID) {
+		reply.Error = "Unknown baby"
+	} else if requestType, err := ParseRequestType(req.Type); err != nil {
+		reply.Error = err.Error()
+	} else if timeout, err := parseRawRequestTimeout(req.Timeout); err != nil {
+		reply.Error = "Invalid timeout: " + err.Error()
+	} else {
+		reply.RawResponse, _ = app.SendRawRequest(babyUID, requestType, req.Request, timeout)
+	}
+
+	out, _ := json.Marshal(reply)
+	return out
+}
+
+// parseRawRequestTimeout - empty timeout means the default one
+func parseRawRequestTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// websocketConnection - returns ready websocket connection to the camera of the baby, nil if not connected
+func (app *App) websocketConnection(babyUID string) *client.WebsocketConnection {
+	app.websocketConnsMu.RLock()
+	defer app.websocketConnsMu.RUnlock()
+
+	return app.websocketConns[babyUID]
+}
+
+// trackWebsocketConnection - makes the connection available for raw requests until the returned function is called
+func (app *App) trackWebsocketConnection(babyUID string, conn *client.WebsocketConnection) func() {
+	app.websocketConnsMu.Lock()
+	app.websocketConns[babyUID] = conn
+	app.websocketConnsMu.Unlock()
+
+	return func() {
+		app.websocketConnsMu.Lock()
+		if app.websocketConns[babyUID] == conn {
+			delete(app.websocketConns, babyUID)
+		}
+		app.websocketConnsMu.Unlock()
+	}
+}
@@ -0,0 +1,41 @@
+package app_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/app"
+	"github.com/indiefan/home_assistant_nanit/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRequestType(t *testing.T) {
+	requestType, err := app.ParseRequestType("GET_BANDWIDTH")
+	assert.NoError(t, err)
+	assert.Equal(t, client.RequestType_GET_BANDWIDTH, requestType)
+
+	requestType, err = app.ParseRequestType("get_status")
+	assert.NoError(t, err)
+	assert.Equal(t, client.RequestType_GET_STATUS, requestType)
+
+	requestType, err = app.ParseRequestType("12")
+	assert.NoError(t, err)
+	assert.Equal(t, client.RequestType_GET_SENSOR_DATA, requestType)
+
+	_, err = app.ParseRequestType("GET_NOTHING")
+	assert.True(t, errors.Is(err, app.ErrUnknownRequestType))
+
+	_, err = app.ParseRequestType("9999")
+	assert.True(t, errors.Is(err, app.ErrUnknownRequestType))
+}
+
+func TestSendRawRequestErrors(t *testing.T) {
+	instance := app.NewApp(app.Opts{})
+
+	_, err := instance.SendRawRequest("b1", client.RequestType_GET_STATUS, []byte(`{"getStatus": {"all": "nope"}}`), 0)
+	assert.True(t, errors.Is(err, app.ErrInvalidRequest))
+
+	result, err := instance.SendRawRequest("b1", client.RequestType_GET_STATUS, []byte(`{"getStatus": {"all": true}}`), 0)
+	assert.True(t, errors.Is(err, app.ErrCameraNotConnected))
+	assert.NotEmpty(t, result.Error)
+}
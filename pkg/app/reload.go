@@ -0,0 +1,301 @@
+package app
+
+import (
+	"bytes"
+	"reflect"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/history"
+	"github.com/indiefan/home_assistant_nanit/pkg/influx"
+	"github.com/indiefan/home_assistant_nanit/pkg/report"
+	"github.com/indiefan/home_assistant_nanit/pkg/rtmpserver"
+	"github.com/indiefan/home_assistant_nanit/pkg/sleep"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/indiefan/home_assistant_nanit/pkg/webhook"
+	"github.com/rs/zerolog/log"
+)
+
+// componentNames - long running components started with the app, in the order of start
+// Note: babies watcher ("babies") is started separately once the babies are being handled
+var componentNames = []string{"rtmp", "mqtt", "webhooks", "influx", "history", "report", "sleep", "http"}
+
+// Reload - applies new options to the running app, only the components affected by the change are restarted
+// Nanit credentials, session and data directories cannot be changed without restart of the whole app
+func (app *App) Reload(next Opts) {
+	app.reloadMu.Lock()
+	defer app.reloadMu.Unlock()
+
+	prev := app.currentOpts()
+	next = keepStaticOpts(prev, next)
+	names := changedComponents(prev, next)
+
+	app.BabyStateManager.SetStaleness(next.Staleness)
+
+	// Not running yet, new options will be used on start
+	if app.ctx == nil {
+		app.setOpts(next)
+		app.setComponents(next, names)
+		return
+	}
+
+	for _, name := range names {
+		log.Info().Str("component", name).Msg("Restarting component to apply new configuration")
+		app.stopComponent(name)
+	}
+
+	app.setOpts(next)
+	app.setComponents(next, names)
+
+	for _, name := range names {
+		app.startComponent(name)
+	}
+
+	if !reflect.DeepEqual(prev.EventPolling, next.EventPolling) {
+		log.Info().Msg("Restarting event polling to apply new configuration")
+		app.restartEventPollers()
+	}
+
+	restartedBabies := app.restartBabies(func(babyUID string) bool {
+		return babyNeedsRestart(prev, next, babyUID)
+	})
+
+	log.Info().Strs("components", names).Int("restarted_babies", restartedBabies).Msg("Configuration reloaded")
+}
+
+// currentOpts - returns options the app is currently running with
+func (app *App) currentOpts() Opts {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	return app.Opts
+}
+
+func (app *App) setOpts(opts Opts) {
+	app.mu.Lock()
+	app.Opts = opts
+	app.mu.Unlock()
+}
+
+func (app *App) webhookDispatcher() *webhook.Dispatcher {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	return app.Webhooks
+}
+
+func (app *App) influxSink() *influx.Sink {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	return app.Influx
+}
+
+func (app *App) historyStore() *history.Store {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	return app.History
+}
+
+func (app *App) reportGenerator() *report.Generator {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	return app.Reports
+}
+
+func (app *App) sleepTracker() *sleep.Tracker {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	return app.Sleep
+}
+
+// setComponents - creates new instances of the listed components according to the options
+// Note: components must not be running
+func (app *App) setComponents(opts Opts, names []string) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	for _, name := range names {
+		switch name {
+		case "mqtt":
+			// Connection is kept, so that registered command handlers are preserved
+			if opts.MQTT != nil {
+				app.MQTTConnection.SetOpts(*opts.MQTT)
+			}
+
+		case "webhooks":
+			app.Webhooks = nil
+			if opts.Webhooks != nil {
+				app.Webhooks = webhook.NewDispatcher(*opts.Webhooks)
+			}
+
+		case "influx":
+			app.Influx = nil
+			if opts.Influx != nil {
+				app.Influx = influx.NewSink(*opts.Influx)
+			}
+
+		case "history":
+			app.History = nil
+			if opts.History != nil {
+				app.History = history.NewStore(*opts.History)
+			}
+
+		case "report":
+			app.Reports = nil
+			if opts.Report != nil {
+				app.Reports = report.NewGenerator(*opts.Report)
+				app.Reports.OnReport(app.publishReport)
+			}
+
+		case "sleep":
+			app.Sleep = nil
+			if opts.Sleep != nil {
+				app.Sleep = sleep.NewTracker(*opts.Sleep)
+			}
+		}
+	}
+}
+
+// startComponent - runs the component within the app context if it is enabled
+func (app *App) startComponent(name string) {
+	opts := app.currentOpts()
+	var run func(utils.GracefulContext)
+
+	switch name {
+	case "rtmp":
+		if opts.RTMP != nil {
+			run = func(ctx utils.GracefulContext) {
+				rtmpserver.StartRTMPServer(opts.RTMP.ListenAddr, app.BabyStateManager, ctx)
+			}
+		}
+
+	case "mqtt":
+		if opts.MQTT != nil {
+			run = func(ctx utils.GracefulContext) {
+				app.MQTTConnection.Run(app.BabyStateManager, ctx)
+			}
+		}
+
+	case "webhooks":
+		if dispatcher := app.webhookDispatcher(); dispatcher != nil {
+			run = func(ctx utils.GracefulContext) {
+				dispatcher.Run(app.BabyStateManager, ctx)
+			}
+		}
+
+	case "influx":
+		if sink := app.influxSink(); sink != nil {
+			run = func(ctx utils.GracefulContext) {
+				sink.Run(app.BabyStateManager, ctx)
+			}
+		}
+
+	case "history":
+		if store := app.historyStore(); store != nil {
+			run = func(ctx utils.GracefulContext) {
+				store.Run(app.BabyStateManager, ctx)
+			}
+		}
+
+	case "report":
+		if generator := app.reportGenerator(); generator != nil {
+			run = func(ctx utils.GracefulContext) {
+				generator.Run(app.BabyStateManager, ctx)
+			}
+		}
+
+	case "sleep":
+		if tracker := app.sleepTracker(); tracker != nil {
+			run = func(ctx utils.GracefulContext) {
+				tracker.Run(app.BabyStateManager, ctx)
+			}
+		}
+
+	case "http":
+		if opts.HTTPEnabled {
+			run = app.serve
+		}
+
+	case "babies":
+		run = app.watchBabies
+	}
+
+	if run != nil {
+		app.components[name] = app.ctx.RunAsChild(run)
+	}
+}
+
+// stopComponent - cancels the component and waits for its clean up
+func (app *App) stopComponent(name string) {
+	if runner, ok := app.components[name]; ok {
+		runner.Cancel()
+		delete(app.components, name)
+	}
+}
+
+// publishReport - publishes night report over MQTT if it is enabled
+func (app *App) publishReport(r report.Report) {
+	if app.currentOpts().MQTT != nil {
+		app.MQTTConnection.PublishReport(r)
+	}
+}
+
+// keepStaticOpts - reverts changes of options which cannot be applied without restart
+func keepStaticOpts(prev Opts, next Opts) Opts {
+	if next.NanitCredentials != prev.NanitCredentials || next.SessionFile != prev.SessionFile ||
+		!bytes.Equal(next.SessionKey, prev.SessionKey) || next.DataDirectories != prev.DataDirectories {
+		log.Warn().Msg("Changes of Nanit credentials, session and data directory require restart, ignoring them")
+	}
+
+	next.NanitCredentials = prev.NanitCredentials
+	next.SessionFile = prev.SessionFile
+	next.SessionKey = prev.SessionKey
+	next.DataDirectories = prev.DataDirectories
+
+	return next
+}
+
+// changedComponents - lists components which need to be restarted to apply the new options
+func changedComponents(prev Opts, next Opts) []string {
+	listenAddr := func(opts Opts) string {
+		if opts.RTMP == nil {
+			return ""
+		}
+
+		return opts.RTMP.ListenAddr
+	}
+
+	changed := map[string]bool{
+		"rtmp":     listenAddr(prev) != listenAddr(next),
+		"mqtt":     !reflect.DeepEqual(prev.MQTT, next.MQTT),
+		"webhooks": !reflect.DeepEqual(prev.Webhooks, next.Webhooks),
+		"influx":   !reflect.DeepEqual(prev.Influx, next.Influx),
+		"history":  !reflect.DeepEqual(prev.History, next.History),
+		"report":   !reflect.DeepEqual(prev.Report, next.Report),
+		"sleep":    !reflect.DeepEqual(prev.Sleep, next.Sleep),
+		"http":     prev.HTTPEnabled != next.HTTPEnabled,
+		"babies":   prev.BabiesRefreshInterval != next.BabiesRefreshInterval,
+	}
+
+	var names []string
+	for _, name := range append(componentNames, "babies") {
+		if changed[name] {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// babyNeedsRestart - checks whether the camera websocket has to be reconnected to apply the new options
+func babyNeedsRestart(prev Opts, next Opts, babyUID string) bool {
+	if prev.needsWebsocket() != next.needsWebsocket() || prev.StreamingEnabled(babyUID) != next.StreamingEnabled(babyUID) ||
+		prev.WebsocketCaptureDir != next.WebsocketCaptureDir {
+		return true
+	}
+
+	// Camera has to be asked to stream to the new address
+	return next.StreamingEnabled(babyUID) && prev.RTMP.PublicAddr != next.RTMP.PublicAddr
+}
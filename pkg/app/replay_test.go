@@ -0,0 +1,98 @@
+package app_test
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/app"
+	"github.com/indiefan/home_assistant_nanit/pkg/client"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func sensorMessage(sensorData ...*client.SensorData) *client.Message {
+	return &client.Message{
+		Type: client.Message_REQUEST.Enum(),
+		Request: &client.Request{
+			Id:          utils.ConstRefInt32(1),
+			Type:        client.RequestType_PUT_SENSOR_DATA.Enum(),
+			SensorData_: sensorData,
+		},
+	}
+}
+
+func TestReplayCapture(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "capture.pb")
+
+	capture, err := client.NewCapture(filename)
+	assert.NoError(t, err)
+
+	capture.Record(client.CaptureOutbound, &client.Message{
+		Type: client.Message_REQUEST.Enum(),
+		Request: &client.Request{
+			Id:            utils.ConstRefInt32(1),
+			Type:          client.RequestType_GET_SENSOR_DATA.Enum(),
+			GetSensorData: &client.GetSensorData{All: utils.ConstRefBool(true)},
+		},
+	})
+	capture.Record(client.CaptureInbound, sensorMessage(
+		&client.SensorData{SensorType: client.SensorType_TEMPERATURE.Enum(), ValueMilli: utils.ConstRefInt32(21500)},
+		&client.SensorData{SensorType: client.SensorType_HUMIDITY.Enum(), ValueMilli: utils.ConstRefInt32(48000)},
+		&client.SensorData{SensorType: client.SensorType_NIGHT.Enum(), Value: utils.ConstRefInt32(1)},
+	))
+	capture.Record(client.CaptureInbound, sensorMessage(
+		&client.SensorData{SensorType: client.SensorType_TEMPERATURE.Enum(), ValueMilli: utils.ConstRefInt32(22000)},
+	))
+	capture.Record(client.CaptureInbound, &client.Message{
+		Type: client.Message_RESPONSE.Enum(),
+		Response: &client.Response{
+			RequestId:   utils.ConstRefInt32(2),
+			RequestType: client.RequestType_GET_CONTROL.Enum(),
+			StatusCode:  utils.ConstRefInt32(200),
+			Control:     &client.Control{NightLight: client.Control_LIGHT_ON.Enum()},
+		},
+	})
+	capture.Record(client.CaptureInbound, &client.Message{
+		Type: client.Message_REQUEST.Enum(),
+		Request: &client.Request{
+			Id:       utils.ConstRefInt32(3),
+			Type:     client.RequestType_PUT_SETTINGS.Enum(),
+			Settings: &client.Settings{SleepMode: utils.ConstRefBool(true)},
+		},
+	})
+	assert.NoError(t, capture.Close())
+
+	messages, err := client.ReadCaptureFile(filename)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 5)
+	assert.Equal(t, client.CaptureOutbound, messages[0].Direction)
+	assert.False(t, messages[0].Time.IsZero())
+
+	instance := app.NewApp(app.Opts{})
+	assert.Equal(t, 4, instance.ReplayCapture("b1", messages))
+
+	state := instance.BabyStateManager.GetBabyState("b1")
+	assert.Equal(t, 22.0, state.GetTemperature())
+	assert.Equal(t, 48.0, state.GetHumidity())
+	assert.True(t, state.GetNightLight())
+	assert.True(t, state.GetStandby())
+	assert.Equal(t, true, state.AsMap(false)["is_night"])
+
+	// Companion file has one protojson record per message
+	f, err := os.Open(filename + ".jsonl")
+	assert.NoError(t, err)
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	assert.Len(t, lines, 5)
+	assert.True(t, strings.Contains(lines[1], `"direction":"in"`))
+	assert.True(t, strings.Contains(lines[1], `"TEMPERATURE"`))
+}
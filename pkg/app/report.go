@@ -0,0 +1,38 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+// handleReport - GET /babies/{babyUID}/report?date=YYYY-MM-DD
+// Without date the latest report is returned
+func (app *App) handleReport(w http.ResponseWriter, r *http.Request) {
+	generator := app.reportGenerator()
+	if generator == nil {
+		http.Error(w, "Night reports are not enabled", http.StatusNotFound)
+		return
+	}
+
+	babyUID := r.PathValue("babyUID")
+	if !app.hasBaby(babyUID) {
+		http.Error(w, "Unknown baby", http.StatusNotFound)
+		return
+	}
+
+	nightReport, err := generator.Load(babyUID, r.URL.Query().Get("date"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if nightReport == nil {
+		http.Error(w, "No report available", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(nightReport); err != nil {
+		log.Error().Err(err).Msg("Unable to write night report response")
+	}
+}
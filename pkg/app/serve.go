@@ -1,6 +1,8 @@
 package app
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -8,29 +10,34 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/indiefan/home_assistant_nanit/pkg/message"
+	"github.com/indiefan/home_assistant_nanit/pkg/metrics"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
 	"github.com/rs/zerolog/log"
-	"github.com/indiefan/home_assistant_nanit/pkg/baby"
 )
 
-func serve(babies []baby.Baby, dataDir DataDirectories) {
+func (app *App) serve(ctx utils.GracefulContext) {
 	const port = 8080
 
+	dataDir := app.currentOpts().DataDirectories
+	mux := http.NewServeMux()
+
 	// Index handler
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html")
 
-		for _, baby := range babies {
+		for _, baby := range app.GetBabies() {
 			fmt.Fprintf(w, "<video src=\"/video/%v.m3u8\" controls autoplay width=\"1280\" height=\"960\"></video>", baby.UID)
 		}
 	})
 
 	// Video files
-	http.Handle("/video/", http.StripPrefix("/video/", http.FileServer(http.Dir(dataDir.VideoDir))))
+	mux.Handle("/video/", http.StripPrefix("/video/", http.FileServer(http.Dir(dataDir.VideoDir))))
 
 	// Dummy log handler - useful for receiving logs from cam
 	// Note: Cam is sending tared archive through curl as binary file
 	// TODO: proper handling of Expect: 100-continue
-	http.HandleFunc("/log", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/log", func(w http.ResponseWriter, r *http.Request) {
 		filename := filepath.Join(dataDir.LogDir, fmt.Sprintf("camlogs-%v.tar.gz", time.Now().Format(time.RFC3339)))
 
 		log.Info().Str("file", filename).Msg("Saving log to file")
@@ -54,6 +61,80 @@ func serve(babies []baby.Baby, dataDir DataDirectories) {
 		w.WriteHeader(http.StatusNoContent)
 	})
 
+	// On demand refresh of the babies list
+	mux.HandleFunc("/babies/refresh", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		app.RefreshBabies()
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	// Mark messages as seen, read or dismissed
+	// Body: JSON array of message IDs
+	mux.HandleFunc("POST /babies/{babyUID}/messages/{action}", func(w http.ResponseWriter, r *http.Request) {
+		babyUID := r.PathValue("babyUID")
+		action := message.Action(r.PathValue("action"))
+		if !action.IsValid() {
+			http.Error(w, "Unknown action", http.StatusNotFound)
+			return
+		}
+
+		var messageIDs []int
+		if err := json.NewDecoder(r.Body).Decode(&messageIDs); err != nil {
+			http.Error(w, "Expected JSON array of message IDs", http.StatusBadRequest)
+			return
+		}
+
+		if !app.hasBaby(babyUID) {
+			http.Error(w, "Unknown baby", http.StatusNotFound)
+			return
+		}
+
+		if err := app.UpdateMessages(babyUID, action, messageIDs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// Current state with timestamps of the fields
+	mux.HandleFunc("GET /babies/{babyUID}/state", app.handleState)
+
+	// State history
+	mux.HandleFunc("GET /babies/{babyUID}/history", app.handleHistory)
+
+	// Night reports
+	mux.HandleFunc("GET /babies/{babyUID}/report", app.handleReport)
+
+	// Sleep sessions
+	mux.HandleFunc("GET /babies/{babyUID}/sleep", app.handleSleep)
+
+	// Raw camera requests (admin only)
+	mux.HandleFunc("POST /babies/{babyUID}/requests/{requestType}", app.handleRawRequest)
+
+	// Health checks
+	mux.HandleFunc("/healthz", app.handleHealthz)
+	mux.HandleFunc("/readyz", app.handleReadyz)
+
+	// Prometheus metrics
+	mux.Handle("/metrics", metrics.Handler(app.collectMetrics))
+
+	server := &http.Server{Addr: fmt.Sprintf(":%v", port), Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
 	log.Info().Int("port", port).Msg("Starting HTTP server")
-	http.ListenAndServe(fmt.Sprintf(":%v", port), nil)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Error().Int("port", port).Err(err).Msg("HTTP server failed")
+	}
 }
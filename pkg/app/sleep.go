@@ -0,0 +1,79 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/sleep"
+	"github.com/rs/zerolog/log"
+)
+
+type sleepResponse struct {
+	BabyUID  string          `json:"baby_uid"`
+	IsAsleep bool            `json:"is_asleep"`
+	Current  *sleep.Session  `json:"current,omitempty"`
+	Sessions []sleep.Session `json:"sessions"`
+}
+
+// handleSleep - GET /babies/{babyUID}/sleep?from=RFC3339&to=RFC3339
+// Returns current sleep status and finished sessions (default: last 7 days)
+func (app *App) handleSleep(w http.ResponseWriter, r *http.Request) {
+	tracker := app.sleepTracker()
+	if tracker == nil {
+		http.Error(w, "Sleep tracking is not enabled", http.StatusNotFound)
+		return
+	}
+
+	babyUID := r.PathValue("babyUID")
+	if !app.hasBaby(babyUID) {
+		http.Error(w, "Unknown baby", http.StatusNotFound)
+		return
+	}
+
+	now := time.Now().UTC()
+	to := now
+	from := now.Add(-7 * 24 * time.Hour)
+
+	var err error
+	if value := r.URL.Query().Get("to"); value != "" {
+		if to, err = time.Parse(time.RFC3339, value); err != nil {
+			http.Error(w, "Invalid 'to', expected RFC3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if value := r.URL.Query().Get("from"); value != "" {
+		if from, err = time.Parse(time.RFC3339, value); err != nil {
+			http.Error(w, "Invalid 'from', expected RFC3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+
+	sessions, err := tracker.Sessions(babyUID, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := sleepResponse{
+		BabyUID:  babyUID,
+		Sessions: sessions,
+	}
+
+	state := app.BabyStateManager.GetBabyState(babyUID)
+	if state.GetIsAsleep() && state.SleepSessionStartTimestamp != nil {
+		start := time.Unix(int64(*state.SleepSessionStartTimestamp), 0).UTC()
+		response.IsAsleep = true
+		response.Current = &sleep.Session{
+			Start:           start,
+			End:             now,
+			DurationSeconds: now.Sub(start).Seconds(),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error().Err(err).Msg("Unable to write sleep response")
+	}
+}
@@ -0,0 +1,34 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/rs/zerolog/log"
+)
+
+type stateResponse struct {
+	BabyUID string                     `json:"baby_uid"`
+	Fields  map[string]baby.FieldValue `json:"fields"`
+}
+
+// handleState - GET /babies/{babyUID}/state
+// Returns current state of the baby, each field with the time it was last reported / changed and whether it is stale
+func (app *App) handleState(w http.ResponseWriter, r *http.Request) {
+	babyUID := r.PathValue("babyUID")
+	if !app.hasBaby(babyUID) {
+		http.Error(w, "Unknown baby", http.StatusNotFound)
+		return
+	}
+
+	response := stateResponse{
+		BabyUID: babyUID,
+		Fields:  app.BabyStateManager.GetBabyFields(babyUID),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error().Err(err).Msg("Unable to write state response")
+	}
+}
@@ -1,6 +1,8 @@
 package app
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/indiefan/home_assistant_nanit/pkg/baby"
@@ -9,6 +11,9 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// cameraCommandTimeout - how long to wait for the camera to confirm the command
+const cameraCommandTimeout = 30 * time.Second
+
 func processSensorData(babyUID string, sensorData []*client.SensorData, stateManager *baby.StateManager) {
 	// Parse sensor update
 	stateUpdate := baby.State{}
@@ -27,7 +32,7 @@ func processSensorData(babyUID string, sensorData []*client.SensorData, stateMan
 	stateManager.Update(babyUID, stateUpdate)
 }
 
-func requestLocalStreaming(babyUID string, targetURL string, streamingStatus client.Streaming_Status, conn *client.WebsocketConnection, stateManager *baby.StateManager) {
+func requestLocalStreaming(ctx context.Context, babyUID string, targetURL string, streamingStatus client.Streaming_Status, camera *client.Camera, stateManager *baby.StateManager) {
 	for {
 		switch streamingStatus {
 		case client.Streaming_STARTED:
@@ -38,24 +43,30 @@ func requestLocalStreaming(babyUID string, targetURL string, streamingStatus cli
 			log.Info().Str("target", targetURL).Msg("Stopping local streaming")
 		}
 
-		awaitResponse := conn.SendRequest(client.RequestType_PUT_STREAMING, &client.Request{
-			Streaming: &client.Streaming{
-				Id:       client.StreamIdentifier(client.StreamIdentifier_MOBILE).Enum(),
-				RtmpUrl:  utils.ConstRefStr(targetURL),
-				Status:   client.Streaming_Status(streamingStatus).Enum(),
-				Attempts: utils.ConstRefInt32(1),
-			},
+		reqCtx, cancel := context.WithTimeout(ctx, cameraCommandTimeout)
+		err := camera.PutStreaming(reqCtx, &client.Streaming{
+			Id:       client.StreamIdentifier(client.StreamIdentifier_MOBILE).Enum(),
+			RtmpUrl:  utils.ConstRefStr(targetURL),
+			Status:   client.Streaming_Status(streamingStatus).Enum(),
+			Attempts: utils.ConstRefInt32(1),
 		})
-
-		_, err := awaitResponse(30 * time.Second)
+		cancel()
 
 		if err != nil {
-			if err.Error() == "Forbidden: Number of Mobile App connections above limit, declining connection" {
+			if errors.Is(err, client.ErrTooManyConnections) {
 				log.Warn().Err(err).Msg("Too many app connections, waiting for local connection to become available...")
 				stateManager.Update(babyUID, *baby.NewState().SetStreamRequestState(baby.StreamRequestState_RequestFailed))
-				time.Sleep(300 * time.Second)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(300 * time.Second):
+				}
+
 				continue
-			} else if err.Error() != "Request timeout" {
+			} else if errors.Is(err, client.ErrConnectionClosed) || ctx.Err() != nil {
+				return
+			} else if !errors.Is(err, client.ErrRequestTimeout) {
 				if stateManager.GetBabyState(babyUID).GetStreamState() == baby.StreamState_Alive {
 					log.Info().Err(err).Msg("Failed to request local streaming, but stream seems to be alive from previous run")
 				} else if stateManager.GetBabyState(babyUID).GetStreamState() == baby.StreamState_Unhealthy {
@@ -91,16 +102,22 @@ func processLight(babyUID string, control *client.Control, stateManager *baby.St
 	}
 }
 
-func sendLightCommand(nightLightState bool, conn *client.WebsocketConnection) {
+func sendLightCommand(ctx context.Context, nightLightState bool, camera *client.Camera) {
 	nightLight := client.Control_LIGHT_OFF
 	if nightLightState {
 		nightLight = client.Control_LIGHT_ON
 	}
-	conn.SendRequest(client.RequestType_PUT_CONTROL, &client.Request{
-		Control: &client.Control{
-			NightLight: &nightLight,
-		},
+
+	ctx, cancel := context.WithTimeout(ctx, cameraCommandTimeout)
+	defer cancel()
+
+	err := camera.PutControl(ctx, &client.Control{
+		NightLight: &nightLight,
 	})
+
+	if err != nil {
+		log.Error().Err(err).Bool("enabled", nightLightState).Msg("Unable to switch the night light")
+	}
 }
 
 func processStandby(babyUID string, settings *client.Settings, stateManager *baby.StateManager) {
@@ -111,10 +128,15 @@ func processStandby(babyUID string, settings *client.Settings, stateManager *bab
 	}
 }
 
-func sendStandbyCommand(standbyState bool, conn *client.WebsocketConnection) {
-	conn.SendRequest(client.RequestType_PUT_SETTINGS, &client.Request{
-		Settings: &client.Settings{
-			SleepMode: &standbyState,
-		},
+func sendStandbyCommand(ctx context.Context, standbyState bool, camera *client.Camera) {
+	ctx, cancel := context.WithTimeout(ctx, cameraCommandTimeout)
+	defer cancel()
+
+	err := camera.PutSettings(ctx, &client.Settings{
+		SleepMode: &standbyState,
 	})
+
+	if err != nil {
+		log.Error().Err(err).Bool("enabled", standbyState).Msg("Unable to switch the standby mode")
+	}
 }
@@ -0,0 +1,15 @@
+package baby
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Event - discrete event which happened to a baby (ie. motion or sound detected)
+type Event struct {
+	Type      string          `json:"type"`
+	Time      time.Time       `json:"time"`
+	MessageID int             `json:"message_id,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Details   json.RawMessage `json:"details,omitempty"`
+}
@@ -0,0 +1,131 @@
+package baby
+
+import (
+	"reflect"
+	"sort"
+	"time"
+)
+
+// FieldTimes - when was the state field last reported and when did its value last change
+type FieldTimes struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	ChangedAt time.Time `json:"changed_at"`
+
+	// Restored - value comes from the snapshot taken before restart and was not reported since
+	Restored bool `json:"-"`
+}
+
+// StalenessOpts - TTLs of the state fields by their AsMap name (ie. temperature)
+// Fields not reported for longer than their TTL are stale (unavailable), fields without TTL never go stale
+type StalenessOpts map[string]time.Duration
+
+// IsStale - checks whether the field reported at the given times is stale
+// Values of unknown age and values restored from the snapshot (not reported since start) are always stale
+func (opts StalenessOpts) IsStale(field string, times FieldTimes, now time.Time) bool {
+	if times.UpdatedAt.IsZero() || times.Restored {
+		return true
+	}
+
+	ttl := opts[field]
+	return ttl > 0 && now.Sub(times.UpdatedAt) > ttl
+}
+
+// FieldValue - value of the state field annotated with its timestamps
+type FieldValue struct {
+	Value     interface{} `json:"value"`
+	UpdatedAt *time.Time  `json:"updated_at,omitempty"`
+	ChangedAt *time.Time  `json:"changed_at,omitempty"`
+	Stale     bool        `json:"stale"`
+}
+
+// AsFieldMap - same as AsMap, the values are annotated with the timestamps and staleness
+func (state *State) AsFieldMap(includeInternal bool, times map[string]FieldTimes, staleness StalenessOpts, now time.Time) map[string]FieldValue {
+	values := state.AsMap(includeInternal)
+	fields := make(map[string]FieldValue, len(values))
+
+	for name, value := range values {
+		field := FieldValue{Value: value}
+
+		fieldTimes := times[name]
+		if !fieldTimes.UpdatedAt.IsZero() {
+			updatedAt, changedAt := fieldTimes.UpdatedAt.UTC(), fieldTimes.ChangedAt.UTC()
+			field.UpdatedAt, field.ChangedAt = &updatedAt, &changedAt
+		}
+
+		field.Stale = staleness.IsStale(name, fieldTimes, now)
+		fields[name] = field
+	}
+
+	return fields
+}
+
+// FieldNames - returns names of all (not internal) state fields as used by AsMap
+func FieldNames() []string {
+	state := &State{}
+
+	r := reflect.ValueOf(state).Elem()
+	for i := 0; i < r.NumField(); i++ {
+		if f := r.Field(i); f.Kind() == reflect.Ptr {
+			f.Set(reflect.New(f.Type().Elem()))
+		}
+	}
+
+	var names []string
+	for name := range state.AsMap(false) {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// touchFields - records the time the fields of the update were reported, must be called before the update is merged
+func (manager *StateManager) touchFields(babyUID string, prev *State, stateUpdate *State, now time.Time) {
+	times, ok := manager.fieldTimes[babyUID]
+	if !ok {
+		times = make(map[string]FieldTimes)
+		manager.fieldTimes[babyUID] = times
+	}
+
+	prevValues := prev.AsMap(true)
+	for name, value := range stateUpdate.AsMap(true) {
+		fieldTimes := times[name]
+		fieldTimes.UpdatedAt = now
+		fieldTimes.Restored = false
+
+		if prevValue, ok := prevValues[name]; !ok || prevValue != value || fieldTimes.ChangedAt.IsZero() {
+			fieldTimes.ChangedAt = now
+		}
+
+		times[name] = fieldTimes
+	}
+}
+
+// SetStaleness - sets TTLs of the state fields
+func (manager *StateManager) SetStaleness(staleness StalenessOpts) {
+	manager.stateMutex.Lock()
+	manager.staleness = staleness
+	manager.stateMutex.Unlock()
+}
+
+// GetFieldTimes - returns copy of the field timestamps of the baby
+func (manager *StateManager) GetFieldTimes(babyUID string) map[string]FieldTimes {
+	manager.stateMutex.RLock()
+	defer manager.stateMutex.RUnlock()
+
+	times := make(map[string]FieldTimes, len(manager.fieldTimes[babyUID]))
+	for name, fieldTimes := range manager.fieldTimes[babyUID] {
+		times[name] = fieldTimes
+	}
+
+	return times
+}
+
+// GetBabyFields - returns current state of the baby with timestamps and staleness of the fields
+func (manager *StateManager) GetBabyFields(babyUID string) map[string]FieldValue {
+	manager.stateMutex.RLock()
+	defer manager.stateMutex.RUnlock()
+
+	babyState := manager.babiesByUID[babyUID]
+	return babyState.AsFieldMap(false, manager.fieldTimes[babyUID], manager.staleness, time.Now())
+}
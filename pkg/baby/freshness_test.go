@@ -0,0 +1,60 @@
+package baby_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateManagerFieldTimes(t *testing.T) {
+	manager := baby.NewStateManager()
+
+	manager.Update("b1", *baby.NewState().SetTemperatureMilli(21_000))
+	first := manager.GetFieldTimes("b1")["temperature"]
+	assert.False(t, first.UpdatedAt.IsZero())
+	assert.Equal(t, first.UpdatedAt, first.ChangedAt)
+
+	time.Sleep(5 * time.Millisecond)
+
+	// Repeated value is fresh, but not changed
+	manager.Update("b1", *baby.NewState().SetTemperatureMilli(21_000))
+	repeated := manager.GetFieldTimes("b1")["temperature"]
+	assert.True(t, repeated.UpdatedAt.After(first.UpdatedAt))
+	assert.Equal(t, first.ChangedAt, repeated.ChangedAt)
+
+	manager.Update("b1", *baby.NewState().SetTemperatureMilli(22_000))
+	changed := manager.GetFieldTimes("b1")["temperature"]
+	assert.True(t, changed.ChangedAt.After(first.ChangedAt))
+
+	_, ok := manager.GetFieldTimes("b1")["humidity"]
+	assert.False(t, ok, "Fields never reported have no times")
+}
+
+func TestStateManagerStaleness(t *testing.T) {
+	manager := baby.NewStateManager()
+	manager.SetStaleness(baby.StalenessOpts{"temperature": 20 * time.Millisecond})
+
+	manager.Update("b1", *baby.NewState().SetTemperatureMilli(21_000).SetHumidityMilli(50_000))
+
+	fields := manager.GetBabyFields("b1")
+	assert.Equal(t, 21.0, fields["temperature"].Value)
+	assert.False(t, fields["temperature"].Stale)
+	assert.NotNil(t, fields["temperature"].UpdatedAt)
+
+	time.Sleep(30 * time.Millisecond)
+
+	fields = manager.GetBabyFields("b1")
+	assert.True(t, fields["temperature"].Stale)
+	assert.False(t, fields["humidity"].Stale, "Fields without TTL never go stale")
+
+	manager.Update("b1", *baby.NewState().SetTemperatureMilli(21_000))
+	assert.False(t, manager.GetBabyFields("b1")["temperature"].Stale, "Repeated value makes the field fresh again")
+}
+
+func TestStalenessOfUnknownAge(t *testing.T) {
+	opts := baby.StalenessOpts{}
+	assert.True(t, opts.IsStale("temperature", baby.FieldTimes{}, time.Now()))
+	assert.False(t, opts.IsStale("temperature", baby.FieldTimes{UpdatedAt: time.Now().Add(-time.Hour)}, time.Now()))
+}
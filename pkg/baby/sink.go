@@ -0,0 +1,31 @@
+package baby
+
+// Sink - output of the baby data (ie. MQTT broker, time series database)
+type Sink interface {
+	// HandleState - receives changed fields of the baby state, in the order they were made
+	// With SubscribeOpts.CurrentState the first call for each baby carries the whole current state
+	HandleState(babyUID string, state State)
+
+	// HandleEvent - receives events of the baby (ie. motion or sound detected), in order with the state changes
+	HandleEvent(babyUID string, event Event)
+}
+
+// RemovalSink - sink which also cleans up after removed babies (see StateManager.RemoveBaby)
+type RemovalSink interface {
+	Sink
+
+	// HandleRemoval - the baby is gone, no more data of it follows unless it gets added again
+	HandleRemoval(babyUID string)
+}
+
+// SubscribeSink - feeds the sink with state changes and events (and removals if it is a RemovalSink)
+// All share a single queue, so the sink receives them in the order they were made and never concurrently
+// Returns unsubscribe function
+func (manager *StateManager) SubscribeSink(sink Sink, opts SubscribeOpts) func() {
+	var onRemoval func(babyUID string)
+	if removalSink, ok := sink.(RemovalSink); ok {
+		onRemoval = removalSink.HandleRemoval
+	}
+
+	return manager.subscribe(newSubscriber(subscriberCallbacks{onState: sink.HandleState, onEvent: sink.HandleEvent, onRemoval: onRemoval}, opts))
+}
@@ -0,0 +1,198 @@
+package baby
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// SnapshotVersion - version of the structure of the snapshot file, snapshots of other versions are ignored
+const SnapshotVersion = 1
+
+// Snapshot - persisted state of all babies
+type Snapshot struct {
+	Version int                     `json:"version"`
+	SavedAt time.Time               `json:"saved_at"`
+	Babies  map[string]BabySnapshot `json:"babies"`
+}
+
+// BabySnapshot - persisted state of a single baby
+type BabySnapshot struct {
+	State  State                 `json:"state"`
+	Fields map[string]FieldTimes `json:"fields,omitempty"`
+}
+
+// Snapshot - returns copy of the state of all babies which is worth keeping across restarts
+// Note: stream and websocket liveness are left out, they are not valid after restart
+func (manager *StateManager) Snapshot() Snapshot {
+	manager.stateMutex.RLock()
+	defer manager.stateMutex.RUnlock()
+
+	snapshot := Snapshot{
+		Version: SnapshotVersion,
+		SavedAt: time.Now().UTC(),
+		Babies:  make(map[string]BabySnapshot, len(manager.babiesByUID)),
+	}
+
+	for babyUID, babyState := range manager.babiesByUID {
+		babyState.StreamState = nil
+		babyState.IsWebsocketAlive = nil
+
+		fields := make(map[string]FieldTimes, len(manager.fieldTimes[babyUID]))
+		for name, fieldTimes := range manager.fieldTimes[babyUID] {
+			fields[name] = fieldTimes
+		}
+
+		snapshot.Babies[babyUID] = BabySnapshot{State: babyState, Fields: fields}
+	}
+
+	return snapshot
+}
+
+// Restore - restores the state from the snapshot, restored fields are stale until they are reported again
+// Babies which already have some state are skipped
+func (manager *StateManager) Restore(snapshot Snapshot) {
+	manager.stateMutex.Lock()
+	defer manager.stateMutex.Unlock()
+
+	for babyUID, babySnapshot := range snapshot.Babies {
+		if _, ok := manager.babiesByUID[babyUID]; ok || !IsValidBabyUID(babyUID) {
+			continue
+		}
+
+		babyState := babySnapshot.State
+		babyState.StreamState = nil
+		babyState.IsWebsocketAlive = nil
+
+		times := make(map[string]FieldTimes)
+		for name := range babyState.AsMap(true) {
+			fieldTimes := babySnapshot.Fields[name]
+			fieldTimes.Restored = true
+			times[name] = fieldTimes
+		}
+
+		manager.babiesByUID[babyUID] = babyState
+		manager.fieldTimes[babyUID] = times
+
+		babyState.EnhanceLogEvent(log.Debug().Str("baby_uid", babyUID)).Msg("Baby state restored")
+		manager.notifySubscribers(babyUID, babyState)
+	}
+}
+
+// Persister - keeps the snapshot of the state in a file
+type Persister struct {
+	// Filename - path of the snapshot file
+	Filename string
+
+	// Debounce - how long are the changes collected before the snapshot is written
+	Debounce time.Duration
+
+	mu sync.Mutex
+}
+
+// NewPersister - constructor
+func NewPersister(filename string, debounce time.Duration) *Persister {
+	return &Persister{
+		Filename: filename,
+		Debounce: debounce,
+	}
+}
+
+// Load - restores the state from the snapshot file (if there is any)
+func (persister *Persister) Load(manager *StateManager) error {
+	data, err := os.ReadFile(persister.Filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+
+	if snapshot.Version != SnapshotVersion {
+		log.Warn().Str("filename", persister.Filename).Int("version", snapshot.Version).Msg("Unsupported version of state snapshot, ignoring it")
+		return nil
+	}
+
+	manager.Restore(snapshot)
+	log.Info().Str("filename", persister.Filename).Int("babies", len(snapshot.Babies)).Time("saved_at", snapshot.SavedAt).Msg("Baby state restored from snapshot")
+
+	return nil
+}
+
+// Save - writes the snapshot of the current state
+func (persister *Persister) Save(manager *StateManager) error {
+	data, err := json.Marshal(manager.Snapshot())
+	if err != nil {
+		return err
+	}
+
+	persister.mu.Lock()
+	defer persister.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(persister.Filename), 0755); err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(persister.Filename, data, 0644)
+}
+
+// Run - saves the snapshot after the state changes (debounced) until the context is cancelled, then saves the final one
+func (persister *Persister) Run(manager *StateManager, ctx utils.GracefulContext) {
+	changedC := make(chan struct{}, 1)
+
+	changed := func(babyUID string) {
+		select {
+		case changedC <- struct{}{}:
+		default:
+		}
+	}
+
+	// Removed babies are dropped from the snapshot too
+	unsubscribe := manager.subscribe(newSubscriber(subscriberCallbacks{
+		onState:   func(babyUID string, state State) { changed(babyUID) },
+		onRemoval: changed,
+	}, SubscribeOpts{Name: "persister", Coalesce: true, CurrentState: true}))
+
+	defer unsubscribe()
+
+	timer := time.NewTimer(persister.Debounce)
+	timer.Stop()
+
+	pending := false
+	save := func() {
+		pending = false
+		if err := persister.Save(manager); err != nil {
+			log.Error().Err(err).Str("filename", persister.Filename).Msg("Unable to save state snapshot")
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if pending {
+				save()
+			}
+
+			return
+		case <-changedC:
+			if !pending {
+				pending = true
+				timer.Reset(persister.Debounce)
+			}
+		case <-timer.C:
+			save()
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package baby_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPersisterRoundTrip(t *testing.T) {
+	persister := baby.NewPersister(filepath.Join(t.TempDir(), "state.json"), time.Second)
+
+	manager := baby.NewStateManager()
+	manager.Update("b1", *baby.NewState().
+		SetTemperatureMilli(21_000).
+		SetStreamState(baby.StreamState_Alive).
+		SetStreamRequestState(baby.StreamRequestState_Requested))
+
+	changedAt := manager.GetFieldTimes("b1")["temperature"].ChangedAt
+	assert.NoError(t, persister.Save(manager))
+
+	restored := baby.NewStateManager()
+	assert.NoError(t, persister.Load(restored))
+
+	state := restored.GetBabyState("b1")
+	assert.Equal(t, 21.0, state.GetTemperature())
+	assert.Equal(t, baby.StreamRequestState_Requested, state.GetStreamRequestState())
+	assert.Equal(t, baby.StreamState_Unknown, state.GetStreamState(), "Stream liveness is not restored")
+
+	field := restored.GetBabyFields("b1")["temperature"]
+	assert.True(t, field.Stale, "Restored fields are stale")
+	assert.True(t, changedAt.Equal(*field.ChangedAt))
+
+	// Reported again, same value
+	restored.Update("b1", *baby.NewState().SetTemperatureMilli(21_000))
+
+	field = restored.GetBabyFields("b1")["temperature"]
+	assert.False(t, field.Stale)
+	assert.True(t, changedAt.Equal(*field.ChangedAt), "Value has not changed since the snapshot")
+}
+
+func TestPersisterMissingOrInvalidFile(t *testing.T) {
+	dir := t.TempDir()
+	manager := baby.NewStateManager()
+
+	assert.NoError(t, baby.NewPersister(filepath.Join(dir, "missing.json"), time.Second).Load(manager))
+
+	os.WriteFile(filepath.Join(dir, "invalid.json"), []byte("{"), 0644)
+	assert.Error(t, baby.NewPersister(filepath.Join(dir, "invalid.json"), time.Second).Load(manager))
+
+	assert.Empty(t, manager.GetBabyStates())
+}
+
+func TestPersisterRun(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "state.json")
+	manager := baby.NewStateManager()
+
+	run := func(debounce time.Duration) (*baby.Persister, utils.GracefulRunner) {
+		persister := baby.NewPersister(filename, debounce)
+		return persister, utils.RunWithGracefulCancel(func(ctx utils.GracefulContext) {
+			persister.Run(manager, ctx)
+		})
+	}
+
+	_, runner := run(20 * time.Millisecond)
+	manager.Update("b1", *baby.NewState().SetTemperatureMilli(21_000))
+
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(filename)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	runner.Cancel()
+
+	// Pending changes are saved on shutdown
+	persister, runner := run(time.Hour)
+	manager.Update("b1", *baby.NewState().SetTemperatureMilli(22_000))
+	time.Sleep(20 * time.Millisecond)
+	runner.Cancel()
+
+	restored := baby.NewStateManager()
+	assert.NoError(t, persister.Load(restored))
+	assert.Equal(t, 22.0, restored.GetBabyState("b1").GetTemperature())
+}
@@ -30,14 +30,24 @@ type State struct {
 	StreamRequestState *StreamRequestState `internal:"true"`
 	IsWebsocketAlive   *bool               `internal:"true"`
 
-	MotionTimestamp  *int32 // int32 is used to represent UTC timestamp
-	SoundTimestamp   *int32 // int32 is used to represent UTC timestamp
-	Temperature      *bool
-	IsNight          *bool
-	TemperatureMilli *int32
-	HumidityMilli    *int32
-	NightLight       *bool
-	Standby          *bool
+	MotionTimestamp           *int32 // int32 is used to represent UTC timestamp
+	SoundTimestamp            *int32 // int32 is used to represent UTC timestamp
+	TemperatureAlertTimestamp *int32 // int32 is used to represent UTC timestamp
+	HumidityAlertTimestamp    *int32 // int32 is used to represent UTC timestamp
+	CameraOfflineTimestamp    *int32 // int32 is used to represent UTC timestamp
+	BreathingAlertTimestamp   *int32 // int32 is used to represent UTC timestamp
+	StandingTimestamp         *int32 // int32 is used to represent UTC timestamp
+	Temperature               *bool
+	IsNight                   *bool
+	TemperatureMilli          *int32
+	HumidityMilli             *int32
+	NightLight                *bool
+	Standby                   *bool
+
+	// Derived by the sleep tracker
+	IsAsleep                   *bool
+	SleepSessionStartTimestamp *int32 // int32 is used to represent UTC timestamp
+	SleepSessionDuration       *int32 // seconds, current or the last finished session
 }
 
 // NewState - constructor
@@ -207,6 +217,31 @@ func (state *State) SetSoundTimestamp(value int32) *State {
 	return state
 }
 
+func (state *State) SetTemperatureAlertTimestamp(value int32) *State {
+	state.TemperatureAlertTimestamp = &value
+	return state
+}
+
+func (state *State) SetHumidityAlertTimestamp(value int32) *State {
+	state.HumidityAlertTimestamp = &value
+	return state
+}
+
+func (state *State) SetCameraOfflineTimestamp(value int32) *State {
+	state.CameraOfflineTimestamp = &value
+	return state
+}
+
+func (state *State) SetBreathingAlertTimestamp(value int32) *State {
+	state.BreathingAlertTimestamp = &value
+	return state
+}
+
+func (state *State) SetStandingTimestamp(value int32) *State {
+	state.StandingTimestamp = &value
+	return state
+}
+
 func (state *State) SetTemperature(value bool) *State {
 	state.Temperature = &value
 	return state
@@ -214,7 +249,7 @@ func (state *State) SetTemperature(value bool) *State {
 
 // GetIsWebsocketAlive - safely returns value
 func (state *State) GetIsWebsocketAlive() bool {
-	if state.StreamState != nil {
+	if state.IsWebsocketAlive != nil {
 		return *state.IsWebsocketAlive
 	}
 
@@ -244,3 +279,22 @@ func (s *State) SetStandby(enabled bool) *State {
 func (s *State) GetStandby() bool {
 	return s.Standby != nil && *s.Standby
 }
+
+func (s *State) SetIsAsleep(value bool) *State {
+	s.IsAsleep = &value
+	return s
+}
+
+func (s *State) GetIsAsleep() bool {
+	return s.IsAsleep != nil && *s.IsAsleep
+}
+
+func (s *State) SetSleepSessionStartTimestamp(value int32) *State {
+	s.SleepSessionStartTimestamp = &value
+	return s
+}
+
+func (s *State) SetSleepSessionDuration(value int32) *State {
+	s.SleepSessionDuration = &value
+	return s
+}
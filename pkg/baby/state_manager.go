@@ -4,13 +4,16 @@ import (
 	"sync"
 	"time"
 
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
 	"github.com/rs/zerolog/log"
 )
 
 // StateManager - state manager context
 type StateManager struct {
 	babiesByUID      map[string]State
-	subscribers      map[*chan bool]func(babyUID string, state State)
+	fieldTimes       map[string]map[string]FieldTimes
+	staleness        StalenessOpts
+	subscribers      map[*subscriber]struct{}
 	stateMutex       sync.RWMutex
 	subscribersMutex sync.RWMutex
 }
@@ -19,7 +22,8 @@ type StateManager struct {
 func NewStateManager() *StateManager {
 	return &StateManager{
 		babiesByUID: make(map[string]State),
-		subscribers: make(map[*chan bool]func(babyUID string, state State)),
+		fieldTimes:  make(map[string]map[string]FieldTimes),
+		subscribers: make(map[*subscriber]struct{}),
 	}
 }
 
@@ -30,6 +34,10 @@ func (manager *StateManager) Update(babyUID string, stateUpdate State) {
 	manager.stateMutex.Lock()
 	defer manager.stateMutex.Unlock()
 
+	// Repeated values are not distributed, yet they prove the field is fresh
+	prev := manager.babiesByUID[babyUID]
+	manager.touchFields(babyUID, &prev, &stateUpdate, time.Now())
+
 	if babyState, ok := manager.babiesByUID[babyUID]; ok {
 		updatedState = babyState.Merge(&stateUpdate)
 		if updatedState == &babyState {
@@ -42,32 +50,101 @@ func (manager *StateManager) Update(babyUID string, stateUpdate State) {
 	manager.babiesByUID[babyUID] = *updatedState
 	stateUpdate.EnhanceLogEvent(log.Debug().Str("baby_uid", babyUID)).Msg("Baby state updated")
 
-	go manager.notifySubscribers(babyUID, stateUpdate)
+	// Queued while holding the state lock, so that subscribers receive the updates in the order they were made
+	manager.notifySubscribers(babyUID, stateUpdate)
 }
 
-// Subscribe - registers function to be called on every update
+// RemoveBaby - forgets the state of the baby (ie. removed from the account), see RemovalSink
+func (manager *StateManager) RemoveBaby(babyUID string) {
+	manager.stateMutex.Lock()
+	defer manager.stateMutex.Unlock()
+
+	if _, ok := manager.babiesByUID[babyUID]; !ok {
+		return
+	}
+
+	delete(manager.babiesByUID, babyUID)
+	delete(manager.fieldTimes, babyUID)
+	log.Debug().Str("baby_uid", babyUID).Msg("Baby state removed")
+
+	// Queued while holding the state lock, so that it keeps its place among the state updates
+	manager.subscribersMutex.RLock()
+
+	for sub := range manager.subscribers {
+		sub.pushRemoval(babyUID)
+	}
+
+	manager.subscribersMutex.RUnlock()
+}
+
+// Subscribe - registers function to be called on every update made from now on, name identifies it in metrics
+// Updates are delivered one by one in the order they were made (see SubscribeOpts)
 // Returns unsubscribe function
-func (manager *StateManager) Subscribe(callback func(babyUID string, state State)) func() {
-	unsubscribeC := make(chan bool, 1)
+func (manager *StateManager) Subscribe(name string, callback func(babyUID string, state State)) func() {
+	return manager.SubscribeWithOpts(callback, SubscribeOpts{Name: name})
+}
+
+// SubscribeWithOpts - same as Subscribe, the options allow naming the subscriber for metrics, coalescing of updates
+// and receiving the current state first
+func (manager *StateManager) SubscribeWithOpts(callback func(babyUID string, state State), opts SubscribeOpts) func() {
+	return manager.subscribe(newSubscriber(subscriberCallbacks{onState: callback}, opts))
+}
+
+// SubscribeFields - same as SubscribeWithOpts, the callback also receives timestamps and staleness of the changed fields
+// as they were when the update was made (GetBabyFields might already be ahead by the time the update is delivered)
+// Note: fields are shared by the subscribers, they must not be modified
+func (manager *StateManager) SubscribeFields(callback func(babyUID string, state State, fields map[string]FieldValue), opts SubscribeOpts) func() {
+	return manager.subscribe(newSubscriber(subscriberCallbacks{onStateFields: callback}, opts))
+}
+
+// subscribe - registers the subscriber, pushing the current state first if asked to
+func (manager *StateManager) subscribe(sub *subscriber) func() {
+
+	// Holding the state lock, so that no update slips in between the current state and the subscription
+	manager.stateMutex.RLock()
 
 	manager.subscribersMutex.Lock()
-	manager.subscribers[&unsubscribeC] = callback
+	manager.subscribers[sub] = struct{}{}
 	manager.subscribersMutex.Unlock()
 
-	manager.stateMutex.RLock()
-	for babyUID, babyState := range manager.babiesByUID {
-		go callback(babyUID, babyState)
+	if sub.opts.CurrentState {
+		for babyUID, babyState := range manager.babiesByUID {
+			var fields map[string]FieldValue
+			if sub.wantsFields() {
+				fields = manager.updatedFields(babyUID, babyState)
+			}
+
+			sub.push(babyUID, babyState, fields)
+		}
 	}
 
 	manager.stateMutex.RUnlock()
 
 	return func() {
 		manager.subscribersMutex.Lock()
-		delete(manager.subscribers, &unsubscribeC)
+		_, ok := manager.subscribers[sub]
+		delete(manager.subscribers, sub)
 		manager.subscribersMutex.Unlock()
+
+		if ok {
+			sub.stop()
+		}
 	}
 }
 
+// GetBabyStates - returns copy of current states of all babies by their UID
+func (manager *StateManager) GetBabyStates() map[string]State {
+	manager.stateMutex.RLock()
+	defer manager.stateMutex.RUnlock()
+
+	states := make(map[string]State, len(manager.babiesByUID))
+	for babyUID, babyState := range manager.babiesByUID {
+		states[babyUID] = babyState
+	}
+
+	return states
+}
+
 // GetBabyState - returns current state of a baby
 func (manager *StateManager) GetBabyState(babyUID string) *State {
 	manager.stateMutex.RLock()
@@ -77,28 +154,77 @@ func (manager *StateManager) GetBabyState(babyUID string) *State {
 	return &babyState
 }
 
-func (manager *StateManager) NotifyMotionSubscribers(babyUID string, time time.Time) {
-	timestamp := new(int32)
-	*timestamp = int32(time.Unix())
-	var state = State{MotionTimestamp: timestamp}
+// SubscribeEvents - registers function to be called on every event (ie. motion or sound detected)
+// Events are delivered one by one in the order they were received (see SubscribeOpts, only Name applies)
+// Returns unsubscribe function
+func (manager *StateManager) SubscribeEvents(callback func(babyUID string, event Event), opts SubscribeOpts) func() {
+	return manager.subscribe(newSubscriber(subscriberCallbacks{onEvent: callback}, SubscribeOpts{Name: opts.Name}))
+}
+
+// NotifyEvent - distributes the event to event subscribers
+func (manager *StateManager) NotifyEvent(babyUID string, event Event) {
+	log.Debug().Str("baby_uid", babyUID).Str("type", event.Type).Time("time", event.Time).Msg("Baby event received")
+
+	// Queued while holding the state lock, so that it keeps its place among the state updates
+	manager.stateMutex.RLock()
+	manager.subscribersMutex.RLock()
+
+	for sub := range manager.subscribers {
+		sub.pushEvent(babyUID, event)
+	}
 
-	manager.notifySubscribers(babyUID, state)
+	manager.subscribersMutex.RUnlock()
+	manager.stateMutex.RUnlock()
 }
 
-func (manager *StateManager) NotifySoundSubscribers(babyUID string, time time.Time) {
-	timestamp := new(int32)
-	*timestamp = int32(time.Unix())
-	var state = State{SoundTimestamp: timestamp}
+// WaitForEvents - waits until the events notified so far are handled by all event subscribers
+// Returns false if the context got cancelled first
+func (manager *StateManager) WaitForEvents(ctx utils.GracefulContext) bool {
+	var wg sync.WaitGroup
+
+	manager.subscribersMutex.RLock()
+
+	for sub := range manager.subscribers {
+		if sub.onEvent != nil {
+			wg.Add(1)
+			sub.pushBarrier(wg.Done)
+		}
+	}
+
+	manager.subscribersMutex.RUnlock()
 
-	manager.notifySubscribers(babyUID, state)
+	doneC := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(doneC)
+	}()
+
+	select {
+	case <-doneC:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
+// notifySubscribers - queues the update, must be called with the state lock held
 func (manager *StateManager) notifySubscribers(babyUID string, state State) {
 	manager.subscribersMutex.RLock()
 
-	for _, callback := range manager.subscribers {
-		go callback(babyUID, state)
+	// Captured once for all the subscribers which want them
+	var fields map[string]FieldValue
+	for sub := range manager.subscribers {
+		if fields == nil && sub.wantsFields() {
+			fields = manager.updatedFields(babyUID, state)
+		}
+
+		sub.push(babyUID, state, fields)
 	}
 
 	manager.subscribersMutex.RUnlock()
 }
+
+// updatedFields - returns the fields of the update with their current timestamps, must be called with the state lock held
+func (manager *StateManager) updatedFields(babyUID string, state State) map[string]FieldValue {
+	return state.AsFieldMap(false, manager.fieldTimes[babyUID], manager.staleness, time.Now())
+}
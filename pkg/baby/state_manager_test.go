@@ -0,0 +1,394 @@
+package baby_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder - collects delivered updates
+type recorder struct {
+	mu      sync.Mutex
+	updates map[string][]baby.State
+}
+
+func newRecorder() *recorder {
+	return &recorder{updates: make(map[string][]baby.State)}
+}
+
+func (r *recorder) record(babyUID string, state baby.State) {
+	r.mu.Lock()
+	r.updates[babyUID] = append(r.updates[babyUID], state)
+	r.mu.Unlock()
+}
+
+func (r *recorder) get(babyUID string) []baby.State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]baby.State(nil), r.updates[babyUID]...)
+}
+
+func (r *recorder) temperatures(babyUID string) []int32 {
+	var values []int32
+	for _, s := range r.get(babyUID) {
+		if s.TemperatureMilli != nil {
+			values = append(values, *s.TemperatureMilli)
+		}
+	}
+
+	return values
+}
+
+func TestStateManagerOrderedDelivery(t *testing.T) {
+	const numBabies = 8
+	const numUpdates = 500
+
+	manager := baby.NewStateManager()
+
+	r1, r2 := newRecorder(), newRecorder()
+	defer manager.Subscribe("r1", r1.record)()
+	defer manager.SubscribeWithOpts(r2.record, baby.SubscribeOpts{Name: "test"})()
+
+	var wg sync.WaitGroup
+	for b := 0; b < numBabies; b++ {
+		wg.Add(1)
+		go func(babyUID string) {
+			defer wg.Done()
+			for i := int32(1); i <= numUpdates; i++ {
+				manager.Update(babyUID, *baby.NewState().SetTemperatureMilli(i))
+			}
+		}(fmt.Sprintf("b%v", b))
+	}
+
+	wg.Wait()
+
+	for _, r := range []*recorder{r1, r2} {
+		for b := 0; b < numBabies; b++ {
+			babyUID := fmt.Sprintf("b%v", b)
+
+			assert.Eventually(t, func() bool { return len(r.temperatures(babyUID)) == numUpdates }, 5*time.Second, 10*time.Millisecond)
+
+			for i, value := range r.temperatures(babyUID) {
+				if !assert.Equal(t, int32(i+1), value, "baby %v", babyUID) {
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestStateManagerStreamStateTransitions(t *testing.T) {
+	manager := baby.NewStateManager()
+
+	r := newRecorder()
+	defer manager.Subscribe("test", r.record)()
+
+	expected := make([]baby.StreamState, 0, 200)
+	for i := 0; i < 100; i++ {
+		expected = append(expected, baby.StreamState_Unhealthy, baby.StreamState_Alive)
+	}
+
+	for _, s := range expected {
+		manager.Update("b1", *baby.NewState().SetStreamState(s))
+	}
+
+	assert.Eventually(t, func() bool { return len(r.get("b1")) == len(expected) }, 5*time.Second, 10*time.Millisecond)
+
+	for i, s := range r.get("b1") {
+		assert.Equal(t, expected[i], s.GetStreamState())
+	}
+}
+
+func TestStateManagerSubscribeReceivesCurrentStateFirst(t *testing.T) {
+	manager := baby.NewStateManager()
+	manager.Update("b1", *baby.NewState().SetTemperatureMilli(1000))
+
+	r, plain := newRecorder(), newRecorder()
+	defer manager.SubscribeWithOpts(r.record, baby.SubscribeOpts{Name: "current", CurrentState: true})()
+	defer manager.Subscribe("plain", plain.record)()
+
+	manager.Update("b1", *baby.NewState().SetTemperatureMilli(2000))
+
+	assert.Eventually(t, func() bool { return len(r.get("b1")) == 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []int32{1000, 2000}, r.temperatures("b1"))
+
+	assert.Eventually(t, func() bool { return len(plain.get("b1")) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []int32{2000}, plain.temperatures("b1"), "Current state is delivered only on request")
+}
+
+func TestStateManagerCoalescing(t *testing.T) {
+	manager := baby.NewStateManager()
+
+	blockC := make(chan struct{})
+	startedC := make(chan struct{})
+	var once sync.Once
+
+	r := newRecorder()
+	defer manager.SubscribeWithOpts(func(babyUID string, state baby.State) {
+		once.Do(func() {
+			close(startedC)
+			<-blockC
+		})
+
+		r.record(babyUID, state)
+	}, baby.SubscribeOpts{Name: "slow", Coalesce: true})()
+
+	manager.Update("b1", *baby.NewState().SetTemperatureMilli(1))
+	<-startedC
+
+	// Subscriber is blocked, following updates are merged
+	for i := int32(2); i <= 100; i++ {
+		manager.Update("b1", *baby.NewState().SetTemperatureMilli(i))
+	}
+	manager.Update("b1", *baby.NewState().SetHumidityMilli(50_000))
+	manager.Update("b2", *baby.NewState().SetTemperatureMilli(7))
+
+	close(blockC)
+
+	assert.Eventually(t, func() bool { return len(r.get("b1")) == 2 && len(r.get("b2")) == 1 }, time.Second, 10*time.Millisecond)
+
+	latest := r.get("b1")[1]
+	assert.Equal(t, int32(100), *latest.TemperatureMilli)
+	assert.Equal(t, int32(50_000), *latest.HumidityMilli)
+	assert.Equal(t, []int32{1, 100}, r.temperatures("b1"))
+
+	// Nothing else arrives
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, r.get("b1"), 2)
+}
+
+func TestStateManagerUnsubscribe(t *testing.T) {
+	manager := baby.NewStateManager()
+
+	r := newRecorder()
+	unsubscribe := manager.Subscribe("test", r.record)
+
+	manager.Update("b1", *baby.NewState().SetTemperatureMilli(1000))
+	assert.Eventually(t, func() bool { return len(r.get("b1")) == 1 }, time.Second, 10*time.Millisecond)
+
+	unsubscribe()
+	unsubscribe()
+
+	manager.Update("b1", *baby.NewState().SetTemperatureMilli(2000))
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, r.get("b1"), 1)
+}
+
+// sequenceSink - records state changes and events as a single sequence
+type sequenceSink struct {
+	mu       sync.Mutex
+	sequence []string
+}
+
+func (s *sequenceSink) HandleState(babyUID string, state baby.State) {
+	s.mu.Lock()
+	s.sequence = append(s.sequence, fmt.Sprintf("%v:%v", babyUID, *state.TemperatureMilli))
+	s.mu.Unlock()
+}
+
+func (s *sequenceSink) HandleEvent(babyUID string, event baby.Event) {
+	s.mu.Lock()
+	s.sequence = append(s.sequence, fmt.Sprintf("%v:%v", babyUID, event.Type))
+	s.mu.Unlock()
+}
+
+func (s *sequenceSink) HandleRemoval(babyUID string) {
+	s.mu.Lock()
+	s.sequence = append(s.sequence, fmt.Sprintf("%v:removed", babyUID))
+	s.mu.Unlock()
+}
+
+func (s *sequenceSink) get() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.sequence...)
+}
+
+func TestStateManagerSinkReceivesEventsInOrder(t *testing.T) {
+	manager := baby.NewStateManager()
+
+	sink := &sequenceSink{}
+	defer manager.SubscribeSink(sink, baby.SubscribeOpts{Name: "sink"})()
+
+	blockC := make(chan struct{})
+	startedC := make(chan struct{})
+	coalescing := &sequenceSink{}
+	defer manager.SubscribeSink(&blockingSink{sequenceSink: coalescing, startedC: startedC, blockC: blockC}, baby.SubscribeOpts{Name: "coalescing", Coalesce: true})()
+
+	manager.Update("b1", *baby.NewState().SetTemperatureMilli(0))
+	expected := []string{"b1:0"}
+	<-startedC
+
+	// Coalescing sink is blocked, following updates are merged, but never across the event
+	for i := int32(1); i <= 100; i++ {
+		manager.Update("b1", *baby.NewState().SetTemperatureMilli(i))
+		expected = append(expected, fmt.Sprintf("b1:%v", i))
+
+		if i%10 == 0 {
+			manager.NotifyEvent("b1", baby.Event{Type: "MOTION", Time: time.Now()})
+			expected = append(expected, "b1:MOTION")
+		}
+	}
+
+	close(blockC)
+
+	assert.Eventually(t, func() bool { return len(sink.get()) == len(expected) }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, expected, sink.get())
+
+	assert.Eventually(t, func() bool { return len(coalescing.get()) == 21 }, time.Second, 10*time.Millisecond)
+
+	var coalesced []string
+	for i := 10; i <= 100; i += 10 {
+		coalesced = append(coalesced, fmt.Sprintf("b1:%v", i), "b1:MOTION")
+	}
+
+	assert.Equal(t, append([]string{"b1:0"}, coalesced...), coalescing.get())
+}
+
+// blockingSink - blocks the first delivery until blockC is closed
+type blockingSink struct {
+	*sequenceSink
+	startedC chan struct{}
+	blockC   chan struct{}
+	once     sync.Once
+}
+
+func (s *blockingSink) HandleState(babyUID string, state baby.State) {
+	s.once.Do(func() {
+		close(s.startedC)
+		<-s.blockC
+	})
+
+	s.sequenceSink.HandleState(babyUID, state)
+}
+
+func TestStateManagerWaitForEvents(t *testing.T) {
+	manager := baby.NewStateManager()
+
+	blockC := make(chan struct{})
+	var handled int32
+	var mu sync.Mutex
+
+	defer manager.SubscribeEvents(func(babyUID string, event baby.Event) {
+		<-blockC
+
+		mu.Lock()
+		handled++
+		mu.Unlock()
+	}, baby.SubscribeOpts{Name: "blocking"})()
+
+	// Unsubscribed subscriber does not hold the wait
+	manager.SubscribeEvents(func(babyUID string, event baby.Event) {}, baby.SubscribeOpts{Name: "unsubscribed"})()
+
+	manager.NotifyEvent("b1", baby.Event{Type: "MOTION", Time: time.Now()})
+	manager.NotifyEvent("b1", baby.Event{Type: "SOUND", Time: time.Now()})
+
+	// Cancelled wait gives up while the events are still being handled
+	runner := utils.RunWithGracefulCancel(func(ctx utils.GracefulContext) {
+		assert.False(t, manager.WaitForEvents(ctx))
+	})
+	runner.Cancel()
+
+	close(blockC)
+
+	runner = utils.RunWithGracefulCancel(func(ctx utils.GracefulContext) {
+		assert.True(t, manager.WaitForEvents(ctx))
+
+		mu.Lock()
+		assert.Equal(t, int32(2), handled)
+		mu.Unlock()
+	})
+	runner.Wait()
+}
+
+func TestStateManagerRequiresSubscriberName(t *testing.T) {
+	manager := baby.NewStateManager()
+
+	assert.Panics(t, func() {
+		manager.SubscribeWithOpts(func(babyUID string, state baby.State) {}, baby.SubscribeOpts{})
+	})
+
+	assert.Panics(t, func() {
+		manager.SubscribeEvents(func(babyUID string, event baby.Event) {}, baby.SubscribeOpts{})
+	})
+}
+
+func TestStateManagerRemoveBaby(t *testing.T) {
+	manager := baby.NewStateManager()
+
+	blockC := make(chan struct{})
+	startedC := make(chan struct{})
+	sink := &sequenceSink{}
+	defer manager.SubscribeSink(&blockingSink{sequenceSink: sink, startedC: startedC, blockC: blockC}, baby.SubscribeOpts{Name: "sink", Coalesce: true})()
+
+	manager.Update("b1", *baby.NewState().SetTemperatureMilli(0))
+	manager.Update("b2", *baby.NewState().SetTemperatureMilli(0))
+	<-startedC
+
+	// Removal is delivered in order and updates are not coalesced across it
+	manager.Update("b1", *baby.NewState().SetTemperatureMilli(1))
+	manager.RemoveBaby("b1")
+	manager.Update("b1", *baby.NewState().SetTemperatureMilli(2))
+
+	// Unknown baby is not removed
+	manager.RemoveBaby("b3")
+
+	close(blockC)
+
+	expected := []string{"b1:0", "b2:0", "b1:1", "b1:removed", "b1:2"}
+	assert.Eventually(t, func() bool { return len(sink.get()) == len(expected) }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, expected, sink.get())
+
+	manager.RemoveBaby("b1")
+	assert.NotContains(t, manager.GetBabyStates(), "b1")
+	assert.Contains(t, manager.GetBabyStates(), "b2")
+	assert.Empty(t, manager.GetBabyFields("b1"))
+}
+
+func TestStateManagerSubscribeFieldsCapturedWithUpdate(t *testing.T) {
+	manager := baby.NewStateManager()
+
+	blockC := make(chan struct{})
+	startedC := make(chan struct{})
+	var once sync.Once
+
+	var mu sync.Mutex
+	var updatedAt []time.Time
+
+	defer manager.SubscribeFields(func(babyUID string, state baby.State, fields map[string]baby.FieldValue) {
+		once.Do(func() {
+			close(startedC)
+			<-blockC
+		})
+
+		mu.Lock()
+		updatedAt = append(updatedAt, *fields["temperature"].UpdatedAt)
+		mu.Unlock()
+	}, baby.SubscribeOpts{Name: "fields"})()
+
+	manager.Update("b1", *baby.NewState().SetTemperatureMilli(21000))
+	<-startedC
+
+	first := *manager.GetBabyFields("b1")["temperature"].UpdatedAt
+	time.Sleep(10 * time.Millisecond)
+
+	// The following update moves the timestamp, the pending one keeps its own
+	manager.Update("b1", *baby.NewState().SetTemperatureMilli(22000))
+	close(blockC)
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(updatedAt) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, first, updatedAt[0])
+	assert.True(t, updatedAt[1].After(first))
+}
@@ -0,0 +1,270 @@
+package baby
+
+import (
+	"sync"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// subscriberQueueWarnLength - number of pending updates after which the subscriber is reported as lagging
+const subscriberQueueWarnLength = 1000
+
+// SubscribeOpts - options of the state subscription
+// Subscriber receives the updates (changed fields only) and events one by one in the order they were made, never concurrently.
+// Only updates made after the subscription are delivered, unless CurrentState is set.
+type SubscribeOpts struct {
+	// Name - identifies the subscriber in metrics and logs, required and should be unique
+	Name string
+
+	// Coalesce - pending updates of the same baby are merged into one, so that a slow subscriber receives
+	// only the latest values instead of every intermediate change
+	// Note: updates are never merged across an event of the baby, events are always delivered
+	Coalesce bool
+
+	// CurrentState - the whole current state of every known baby is delivered first, before any following update
+	// Note: the state might be restored from the snapshot (see Persister), so it is not proof the camera is connected
+	CurrentState bool
+}
+
+// pendingUpdate - state update, event (if set), removal of the baby (if set) or barrier (if set) waiting for delivery
+type pendingUpdate struct {
+	babyUID string
+	state   State
+	fields  map[string]FieldValue
+	event   *Event
+	removed bool
+	barrier func()
+}
+
+// subscriberCallbacks - any of the callbacks might be nil, the subscriber then does not receive that kind of data
+type subscriberCallbacks struct {
+	onState func(babyUID string, state State)
+	// onStateFields - same as onState, with the changed fields as of the update (see SubscribeFields)
+	onStateFields func(babyUID string, state State, fields map[string]FieldValue)
+	onEvent       func(babyUID string, event Event)
+	onRemoval     func(babyUID string)
+}
+
+// subscriber - delivers updates and events to the callbacks one by one in the order they were made
+type subscriber struct {
+	subscriberCallbacks
+	opts SubscribeOpts
+
+	mu      sync.Mutex
+	pending []pendingUpdate
+	stopped bool
+
+	signalC chan struct{}
+	stopC   chan struct{}
+}
+
+func newSubscriber(callbacks subscriberCallbacks, opts SubscribeOpts) *subscriber {
+	if opts.Name == "" {
+		// Metrics of unnamed subscribers would be mixed together
+		panic("baby: subscriber name is required")
+	}
+
+	s := &subscriber{
+		subscriberCallbacks: callbacks,
+		opts:                opts,
+		signalC:             make(chan struct{}, 1),
+		stopC:               make(chan struct{}),
+	}
+
+	go s.run()
+
+	return s
+}
+
+// wantsFields - returns true if the updates should carry the changed fields (see subscriberCallbacks.onStateFields)
+func (s *subscriber) wantsFields() bool {
+	return s.onStateFields != nil
+}
+
+// push - queues the update, never blocks
+// Fields are shared by the subscribers, they are never modified
+func (s *subscriber) push(babyUID string, state State, fields map[string]FieldValue) {
+	if s.onState == nil && s.onStateFields == nil {
+		return
+	}
+
+	if !s.wantsFields() {
+		fields = nil
+	}
+
+	s.mu.Lock()
+
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+
+	if s.opts.Coalesce {
+		// Only the latest pending entry of the baby can take the update, unless it is an event or removal
+		for i := len(s.pending) - 1; i >= 0; i-- {
+			if s.pending[i].babyUID != babyUID {
+				continue
+			}
+
+			if s.pending[i].event == nil && !s.pending[i].removed {
+				s.pending[i].state = *s.pending[i].state.Merge(&state)
+				s.pending[i].fields = mergeFields(s.pending[i].fields, fields)
+				s.mu.Unlock()
+
+				metrics.StateUpdatesCoalesced.Inc(s.opts.Name)
+				return
+			}
+
+			break
+		}
+	}
+
+	s.enqueue(pendingUpdate{babyUID: babyUID, state: state, fields: fields})
+}
+
+// mergeFields - returns fields of both updates, the newer ones win, inputs are not modified
+func mergeFields(older map[string]FieldValue, newer map[string]FieldValue) map[string]FieldValue {
+	if len(older) == 0 {
+		return newer
+	}
+
+	merged := make(map[string]FieldValue, len(older)+len(newer))
+	for key, field := range older {
+		merged[key] = field
+	}
+
+	for key, field := range newer {
+		merged[key] = field
+	}
+
+	return merged
+}
+
+// pushEvent - queues the event behind the updates made before it, never blocks
+func (s *subscriber) pushEvent(babyUID string, event Event) {
+	if s.onEvent == nil {
+		return
+	}
+
+	s.mu.Lock()
+
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+
+	s.enqueue(pendingUpdate{babyUID: babyUID, event: &event})
+}
+
+// pushRemoval - queues removal of the baby behind the updates made before it, never blocks
+func (s *subscriber) pushRemoval(babyUID string) {
+	if s.onRemoval == nil {
+		return
+	}
+
+	s.mu.Lock()
+
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+
+	s.enqueue(pendingUpdate{babyUID: babyUID, removed: true})
+}
+
+// pushBarrier - calls done once everything queued so far is delivered (or dropped)
+func (s *subscriber) pushBarrier(done func()) {
+	s.mu.Lock()
+
+	if s.stopped {
+		s.mu.Unlock()
+		done()
+		return
+	}
+
+	s.enqueue(pendingUpdate{barrier: done})
+}
+
+// enqueue - appends the entry and wakes up the delivery, must be called with the lock held (releases it)
+func (s *subscriber) enqueue(update pendingUpdate) {
+	s.pending = append(s.pending, update)
+	if len(s.pending) == subscriberQueueWarnLength {
+		log.Warn().Str("subscriber", s.opts.Name).Int("pending", len(s.pending)).Msg("State subscriber is lagging behind")
+	}
+
+	s.mu.Unlock()
+
+	metrics.StateSubscriberQueueLength.Add(1, s.opts.Name)
+
+	select {
+	case s.signalC <- struct{}{}:
+	default:
+	}
+}
+
+// stop - stops the delivery, pending updates are dropped
+// Note: does not wait for the callback in progress (it might be the one unsubscribing)
+func (s *subscriber) stop() {
+	s.mu.Lock()
+	dropped := s.pending
+	s.pending = nil
+	s.stopped = true
+	s.mu.Unlock()
+
+	metrics.StateSubscriberQueueLength.Add(-float64(len(dropped)), s.opts.Name)
+	close(s.stopC)
+
+	for _, update := range dropped {
+		if update.barrier != nil {
+			update.barrier()
+		}
+	}
+}
+
+func (s *subscriber) run() {
+	for {
+		select {
+		case <-s.stopC:
+			return
+		case <-s.signalC:
+		}
+
+		for {
+			s.mu.Lock()
+			if len(s.pending) == 0 || s.stopped {
+				s.mu.Unlock()
+				break
+			}
+
+			update := s.pending[0]
+			s.pending[0] = pendingUpdate{}
+			s.pending = s.pending[1:]
+			s.mu.Unlock()
+
+			metrics.StateSubscriberQueueLength.Add(-1, s.opts.Name)
+
+			if update.barrier != nil {
+				update.barrier()
+				continue
+			}
+
+			if update.event != nil {
+				s.onEvent(update.babyUID, *update.event)
+				continue
+			}
+
+			if update.removed {
+				s.onRemoval(update.babyUID)
+				continue
+			}
+
+			metrics.StateUpdatesDelivered.Inc(s.opts.Name)
+			if s.onStateFields != nil {
+				s.onStateFields(update.babyUID, update.state, update.fields)
+			} else {
+				s.onState(update.babyUID, update.state)
+			}
+		}
+	}
+}
@@ -11,7 +11,12 @@ var validUID = regexp.MustCompile(`^[a-z0-9_-]+$`)
 // EnsureValidBabyUID - Checks that Baby UID does not contain any bad characters
 // This is necessary because we use it as part of file paths
 func EnsureValidBabyUID(babyUID string) {
-	if !validUID.MatchString(babyUID) {
+	if !IsValidBabyUID(babyUID) {
 		log.Fatal().Str("uid", babyUID).Msg("Baby UID contains unsafe characters")
 	}
 }
+
+// IsValidBabyUID - checks that UID is safe to be used ie. in file paths
+func IsValidBabyUID(babyUID string) bool {
+	return validUID.MatchString(babyUID)
+}
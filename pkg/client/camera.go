@@ -0,0 +1,179 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrRequestTimeout - camera did not respond in time
+	ErrRequestTimeout = errors.New("request timeout")
+	// ErrConnectionClosed - websocket connection was closed before the camera responded
+	ErrConnectionClosed = errors.New("connection closed")
+	// ErrNoStatusCode - camera responded without status code
+	ErrNoStatusCode = errors.New("no status code received")
+	// ErrBadRequest - camera responded with status 400
+	ErrBadRequest = errors.New("bad request")
+	// ErrForbidden - camera responded with status 403
+	ErrForbidden = errors.New("forbidden")
+	// ErrNotFound - camera responded with status 404
+	ErrNotFound = errors.New("not found")
+	// ErrCameraFailure - camera responded with status 5xx
+	ErrCameraFailure = errors.New("camera failure")
+	// ErrTooManyConnections - camera declined the request because of too many mobile app connections
+	ErrTooManyConnections = errors.New("too many app connections")
+)
+
+// statusMessageErrors - known status messages of the camera
+var statusMessageErrors = []struct {
+	prefix string
+	err    error
+}{
+	{"Forbidden: Number of Mobile App connections above limit", ErrTooManyConnections},
+}
+
+// StatusError - camera responded with non-200 status code
+// Matches the sentinel errors of the status code and the known status messages (use errors.Is)
+type StatusError struct {
+	RequestType RequestType
+	StatusCode  int32
+	Message     string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+
+	return fmt.Sprintf("unexpected status code %v", e.StatusCode)
+}
+
+// Is - allows errors.Is(err, ErrForbidden) and similar
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == 400
+	case ErrForbidden:
+		return e.StatusCode == 403
+	case ErrNotFound:
+		return e.StatusCode == 404
+	case ErrCameraFailure:
+		return e.StatusCode >= 500 && e.StatusCode < 600
+	}
+
+	for _, known := range statusMessageErrors {
+		if target == known.err && strings.HasPrefix(e.Message, known.prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkResponse - returns error describing unsuccessful response
+func checkResponse(res *Response) error {
+	if res.StatusCode == nil {
+		return ErrNoStatusCode
+	} else if res.GetStatusCode() != 200 {
+		return &StatusError{RequestType: res.GetRequestType(), StatusCode: res.GetStatusCode(), Message: res.GetStatusMessage()}
+	}
+
+	return nil
+}
+
+// Camera - typed API of the camera on top of the websocket connection
+type Camera struct {
+	conn *WebsocketConnection
+
+	// RequestTimeout - limit of a single attempt of the retried (GET) requests, context deadline applies as well
+	RequestTimeout time.Duration
+	// Retries - how many times are the timed out GET requests retried
+	Retries int
+	// RetryDelay - pause between the attempts
+	RetryDelay time.Duration
+}
+
+// NewCamera - constructor
+func NewCamera(conn *WebsocketConnection) *Camera {
+	return &Camera{
+		conn:           conn,
+		RequestTimeout: CameraRequestTimeout,
+		Retries:        CameraRequestRetries,
+		RetryDelay:     CameraRetryDelay,
+	}
+}
+
+// GetSensorData - returns current readings of all sensors
+func (c *Camera) GetSensorData(ctx context.Context) ([]*SensorData, error) {
+	res, err := c.get(ctx, RequestType_GET_SENSOR_DATA, &Request{
+		GetSensorData: &GetSensorData{All: utils.ConstRefBool(true)},
+	})
+
+	return res.GetSensorData(), err
+}
+
+// GetControl - returns the controls selected by the query (ie. NightLight: true)
+func (c *Camera) GetControl(ctx context.Context, query *GetControl) (*Control, error) {
+	res, err := c.get(ctx, RequestType_GET_CONTROL, &Request{GetControl_: query})
+	return res.GetControl(), err
+}
+
+// PutControl - changes the controls (ie. night light)
+func (c *Camera) PutControl(ctx context.Context, control *Control) error {
+	_, err := c.conn.Do(ctx, RequestType_PUT_CONTROL, &Request{Control: control})
+	return err
+}
+
+// GetSettings - returns camera settings
+func (c *Camera) GetSettings(ctx context.Context) (*Settings, error) {
+	res, err := c.get(ctx, RequestType_GET_SETTINGS, &Request{})
+	return res.GetSettings(), err
+}
+
+// PutSettings - changes camera settings (ie. sleep mode)
+func (c *Camera) PutSettings(ctx context.Context, settings *Settings) error {
+	_, err := c.conn.Do(ctx, RequestType_PUT_SETTINGS, &Request{Settings: settings})
+	return err
+}
+
+// GetStatus - returns camera status
+func (c *Camera) GetStatus(ctx context.Context) (*Status, error) {
+	res, err := c.get(ctx, RequestType_GET_STATUS, &Request{
+		GetStatus_: &GetStatus{All: utils.ConstRefBool(true)},
+	})
+
+	return res.GetStatus(), err
+}
+
+// PutStreaming - starts / pauses / stops streaming to the RTMP URL
+func (c *Camera) PutStreaming(ctx context.Context, streaming *Streaming) error {
+	_, err := c.conn.Do(ctx, RequestType_PUT_STREAMING, &Request{Streaming: streaming})
+	return err
+}
+
+// get - sends idempotent request, attempts which timed out are retried
+func (c *Camera) get(ctx context.Context, reqType RequestType, request *Request) (*Response, error) {
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, c.RequestTimeout)
+		res, err := c.conn.Do(attemptCtx, reqType, request)
+		cancel()
+
+		if !errors.Is(err, ErrRequestTimeout) || attempt >= c.Retries || ctx.Err() != nil {
+			return res, err
+		}
+
+		log.Debug().Stringer("type", reqType).Int("attempt", attempt+1).Msg("Camera request timed out, retrying")
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(c.RetryDelay):
+		}
+	}
+}
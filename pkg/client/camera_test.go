@@ -0,0 +1,116 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/client"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func respond(conn *client.WebsocketConnection, res *client.Response) {
+	client.Replay([]client.CapturedMessage{{
+		Direction: client.CaptureInbound,
+		Message:   &client.Message{Type: client.Message_RESPONSE.Enum(), Response: res},
+	}}, conn)
+}
+
+func TestCameraResponse(t *testing.T) {
+	conn := client.NewReplayConnection()
+	camera := client.NewCamera(conn)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+
+		// Response to a different request type is ignored
+		respond(conn, &client.Response{
+			RequestId:   utils.ConstRefInt32(1),
+			RequestType: client.RequestType_GET_CONTROL.Enum(),
+			StatusCode:  utils.ConstRefInt32(200),
+		})
+
+		respond(conn, &client.Response{
+			RequestId:   utils.ConstRefInt32(1),
+			RequestType: client.RequestType_GET_SETTINGS.Enum(),
+			StatusCode:  utils.ConstRefInt32(200),
+			Settings:    &client.Settings{SleepMode: utils.ConstRefBool(true)},
+		})
+	}()
+
+	settings, err := camera.GetSettings(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, settings.GetSleepMode())
+}
+
+func TestCameraStatusErrors(t *testing.T) {
+	conn := client.NewReplayConnection()
+	camera := client.NewCamera(conn)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		respond(conn, &client.Response{
+			RequestId:     utils.ConstRefInt32(1),
+			RequestType:   client.RequestType_PUT_STREAMING.Enum(),
+			StatusCode:    utils.ConstRefInt32(403),
+			StatusMessage: utils.ConstRefStr("Forbidden: Number of Mobile App connections above limit, declining connection"),
+		})
+	}()
+
+	err := camera.PutStreaming(context.Background(), &client.Streaming{
+		Id:       client.StreamIdentifier_MOBILE.Enum(),
+		RtmpUrl:  utils.ConstRefStr("rtmp://127.0.0.1:1935/local/b1"),
+		Status:   client.Streaming_STARTED.Enum(),
+		Attempts: utils.ConstRefInt32(1),
+	})
+	assert.True(t, errors.Is(err, client.ErrTooManyConnections))
+	assert.True(t, errors.Is(err, client.ErrForbidden))
+	assert.False(t, errors.Is(err, client.ErrNotFound))
+
+	var statusErr *client.StatusError
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, int32(403), statusErr.StatusCode)
+	assert.Equal(t, client.RequestType_PUT_STREAMING, statusErr.RequestType)
+}
+
+func TestCameraRetriesGet(t *testing.T) {
+	conn := client.NewReplayConnection()
+	camera := client.NewCamera(conn)
+	camera.RequestTimeout = 200 * time.Millisecond
+	camera.RetryDelay = 10 * time.Millisecond
+
+	go func() {
+		// First attempt times out, second attempt gets the response
+		time.Sleep(300 * time.Millisecond)
+		respond(conn, &client.Response{
+			RequestId:   utils.ConstRefInt32(2),
+			RequestType: client.RequestType_GET_STATUS.Enum(),
+			StatusCode:  utils.ConstRefInt32(200),
+			Status:      &client.Status{},
+		})
+	}()
+
+	status, err := camera.GetStatus(context.Background())
+	assert.NoError(t, err)
+	assert.NotNil(t, status)
+}
+
+func TestCameraPutTimeout(t *testing.T) {
+	conn := client.NewReplayConnection()
+	camera := client.NewCamera(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := camera.PutControl(ctx, &client.Control{})
+	assert.True(t, errors.Is(err, client.ErrRequestTimeout))
+
+	// Late response is dropped
+	respond(conn, &client.Response{
+		RequestId:   utils.ConstRefInt32(1),
+		RequestType: client.RequestType_PUT_CONTROL.Enum(),
+		StatusCode:  utils.ConstRefInt32(200),
+	})
+}
@@ -0,0 +1,279 @@
+package client
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// CaptureDirection - direction of the captured message
+type CaptureDirection int
+
+const (
+	// CaptureInbound - message received from the camera
+	CaptureInbound CaptureDirection = 1
+	// CaptureOutbound - message sent to the camera
+	CaptureOutbound CaptureDirection = 2
+)
+
+func (d CaptureDirection) String() string {
+	switch d {
+	case CaptureInbound:
+		return "in"
+	case CaptureOutbound:
+		return "out"
+	default:
+		return "unknown"
+	}
+}
+
+// CapturedMessage - websocket message with the time it was sent / received
+type CapturedMessage struct {
+	Time      time.Time
+	Direction CaptureDirection
+	Message   *Message
+}
+
+// Capture - records websocket messages into a file
+//
+// The file is a stream of length-delimited (varint size prefix) protobuf records:
+//
+//	message CapturedMessage {
+//	  int64 time_unix_nano = 1;
+//	  int32 direction = 2; // 1 = inbound, 2 = outbound
+//	  Message message = 3;
+//	}
+//
+// A companion {filename}.jsonl with the same records in protojson is written alongside for reading / diffing.
+type Capture struct {
+	mu       sync.Mutex
+	file     *os.File
+	jsonFile *os.File
+}
+
+// NewCapture - creates capture files, existing files are truncated
+func NewCapture(filename string) (*Capture, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonFile, err := os.Create(filename + ".jsonl")
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &Capture{file: file, jsonFile: jsonFile}, nil
+}
+
+// Record - appends the message to the capture, failures are only logged so that they don't affect the connection
+func (c *Capture) Record(direction CaptureDirection, m *Message) {
+	if c == nil {
+		return
+	}
+
+	if err := c.write(CapturedMessage{Time: time.Now(), Direction: direction, Message: m}); err != nil {
+		log.Error().Err(err).Msg("Unable to record websocket message into the capture")
+	}
+}
+
+func (c *Capture) write(captured CapturedMessage) error {
+	record, err := marshalCapturedMessage(captured)
+	if err != nil {
+		return err
+	}
+
+	jsonMessage, err := protojson.Marshal(captured.Message)
+	if err != nil {
+		return err
+	}
+
+	jsonRecord, err := json.Marshal(struct {
+		Time      time.Time       `json:"time"`
+		Direction string          `json:"direction"`
+		Message   json.RawMessage `json:"message"`
+	}{captured.Time, captured.Direction.String(), jsonMessage})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := c.file.Write(protowire.AppendVarint(nil, uint64(len(record)))); err != nil {
+		return err
+	}
+
+	if _, err := c.file.Write(record); err != nil {
+		return err
+	}
+
+	_, err = c.jsonFile.Write(append(jsonRecord, '\n'))
+	return err
+}
+
+// Close - closes the capture files
+func (c *Capture) Close() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return errors.Join(c.file.Close(), c.jsonFile.Close())
+}
+
+// CaptureReader - reads messages recorded by Capture
+type CaptureReader struct {
+	r *bufio.Reader
+}
+
+// NewCaptureReader - constructor
+func NewCaptureReader(r io.Reader) *CaptureReader {
+	return &CaptureReader{r: bufio.NewReader(r)}
+}
+
+// Next - returns next captured message, io.EOF at the end of the capture
+func (reader *CaptureReader) Next() (*CapturedMessage, error) {
+	size, err := binary.ReadUvarint(reader.r)
+	if err != nil {
+		return nil, err
+	}
+
+	record := make([]byte, size)
+	if _, err := io.ReadFull(reader.r, record); err != nil {
+		return nil, fmt.Errorf("truncated capture record: %w", err)
+	}
+
+	return unmarshalCapturedMessage(record)
+}
+
+// ReadCaptureFile - reads all messages of the capture file
+func ReadCaptureFile(filename string) ([]CapturedMessage, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var messages []CapturedMessage
+	reader := NewCaptureReader(f)
+	for {
+		captured, err := reader.Next()
+		if err == io.EOF {
+			return messages, nil
+		} else if err != nil {
+			return messages, err
+		}
+
+		messages = append(messages, *captured)
+	}
+}
+
+// CaptureFilename - returns name of a new capture file of the camera in the directory
+func CaptureFilename(dir string, cameraUID string, now time.Time) string {
+	return filepath.Join(dir, fmt.Sprintf("%v-%v.pb", cameraUID, now.UTC().Format("20060102T150405Z")))
+}
+
+// Replay - feeds inbound messages of the capture into the connection message handlers in the recorded order
+// Outbound messages are skipped. Returns number of replayed messages.
+func Replay(messages []CapturedMessage, conn *WebsocketConnection) int {
+	replayed := 0
+	for _, captured := range messages {
+		if captured.Direction != CaptureInbound {
+			continue
+		}
+
+		conn.handleMessage(captured.Message)
+		replayed++
+	}
+
+	return replayed
+}
+
+func marshalCapturedMessage(captured CapturedMessage) ([]byte, error) {
+	messageBytes, err := proto.Marshal(captured.Message)
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(captured.Time.UnixNano()))
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(captured.Direction))
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	b = protowire.AppendBytes(b, messageBytes)
+
+	return b, nil
+}
+
+func unmarshalCapturedMessage(b []byte) (*CapturedMessage, error) {
+	captured := &CapturedMessage{}
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			captured.Time = time.Unix(0, int64(v))
+			b = b[n:]
+
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			captured.Direction = CaptureDirection(v)
+			b = b[n:]
+
+		case num == 3 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+
+			captured.Message = &Message{}
+			if err := proto.Unmarshal(v, captured.Message); err != nil {
+				return nil, err
+			}
+			b = b[n:]
+
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+
+	if captured.Message == nil {
+		return nil, errors.New("capture record without message")
+	}
+
+	return captured, nil
+}
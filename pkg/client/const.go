@@ -5,4 +5,31 @@ import "time"
 const (
 	// AuthTokenTimelife - Time duration after which we assume auth token expired
 	AuthTokenTimelife = 60 * time.Minute
+
+	// MessagesPageSize - Number of event messages fetched in a single request
+	MessagesPageSize = 20
+
+	// MessagesMaxPages - Maximum number of pages fetched during a single poll
+	MessagesMaxPages = 10
+
+	// WebsocketHandshakeTimeout - Time limit for opening the websocket connection
+	WebsocketHandshakeTimeout = 30 * time.Second
+
+	// WebsocketWriteTimeout - Time limit for sending a single websocket message
+	WebsocketWriteTimeout = 10 * time.Second
+
+	// WebsocketPingInterval - How often is the websocket server pinged
+	WebsocketPingInterval = 20 * time.Second
+
+	// WebsocketPongTimeout - Websocket connection is considered dead if nothing is received for this long
+	WebsocketPongTimeout = 60 * time.Second
+
+	// CameraRequestTimeout - Time to wait for a single attempt of an idempotent camera request
+	CameraRequestTimeout = 10 * time.Second
+
+	// CameraRequestRetries - Number of retries of timed out idempotent camera requests
+	CameraRequestRetries = 2
+
+	// CameraRetryDelay - Pause between attempts of camera requests
+	CameraRetryDelay = 2 * time.Second
 )
@@ -6,7 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/indiefan/home_assistant_nanit/pkg/baby"
@@ -18,6 +20,7 @@ import (
 
 var myClient = &http.Client{Timeout: 10 * time.Second}
 var ErrExpiredRefreshToken = errors.New("Refresh token has expired. Relogin required.")
+var ErrInvalidCredentials = errors.New("Server responded with code 401. Provided credentials has not been accepted by the server. Please check if your e-mail address and password is entered correctly and that 2FA is disabled on your account.")
 
 // ------------------------------------------
 
@@ -34,6 +37,10 @@ type messagesResponsePayload struct {
 	Messages []message.Message `json:"messages"`
 }
 
+type eventResponsePayload struct {
+	Event message.Event `json:"event"`
+}
+
 // ------------------------------------------
 
 // NanitClient - client context
@@ -42,48 +49,63 @@ type NanitClient struct {
 	Password     string
 	RefreshToken string
 	SessionStore *session.Store
+
+	authMu sync.Mutex
 }
 
 // MaybeAuthorize - Performs authorization if we don't have token or we assume it is expired
-func (c *NanitClient) MaybeAuthorize(force bool) {
-	if force || c.SessionStore.Session.AuthToken == "" || time.Since(c.SessionStore.Session.AuthTime) > AuthTokenTimelife {
-		c.Authorize()
+func (c *NanitClient) MaybeAuthorize(force bool) error {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	authToken, authTime := c.SessionStore.GetAuthToken()
+	if force || authToken == "" || time.Since(authTime) > AuthTokenTimelife {
+		return c.authorize()
 	}
+
+	return nil
 }
 
-// Authorize - performs authorization attempt, panics if it fails
-func (c *NanitClient) Authorize() {
-	if len(c.SessionStore.Session.RefreshToken) == 0 {
-		c.SessionStore.Session.RefreshToken = c.RefreshToken
+// Authorize - performs authorization attempt, returns error if it fails
+func (c *NanitClient) Authorize() error {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	return c.authorize()
+}
+
+func (c *NanitClient) authorize() error {
+	if len(c.SessionStore.GetRefreshToken()) == 0 && len(c.RefreshToken) > 0 {
+		c.SessionStore.SetRefreshToken(c.RefreshToken)
 	}
 
-	if len(c.SessionStore.Session.RefreshToken) > 0 {
+	if len(c.SessionStore.GetRefreshToken()) > 0 {
 		err := c.RenewSession() // We have a refresh token, so we'll use that to extend our session
 		if err == nil {
-			return
+			return nil
 		}
 		if !errors.Is(err, ErrExpiredRefreshToken) {
-			log.Fatal().Err(err).Msg("Unknown error occurred while trying to refresh the session")
+			return fmt.Errorf("Unknown error occurred while trying to refresh the session: %w", err)
 		}
 	}
 
-	c.Login() // We don't have a refresh token, e.g. initial login so we need to supply username/password
+	return c.Login() // We don't have a refresh token, e.g. initial login so we need to supply username/password
 }
 
 // Renews an existing session using a valid refresh token
 // If the refresh token has also expired, we need to perform a full re-login
 func (c *NanitClient) RenewSession() error {
 	requestBody, requestBodyErr := json.Marshal(map[string]string{
-		"refresh_token": c.SessionStore.Session.RefreshToken,
+		"refresh_token": c.SessionStore.GetRefreshToken(),
 	})
 
 	if requestBodyErr != nil {
-		log.Fatal().Err(requestBodyErr).Msg("Unable to marshal auth body")
+		return fmt.Errorf("Unable to marshal auth body: %w", requestBodyErr)
 	}
 
 	r, clientErr := myClient.Post("https://api.nanit.com/tokens/refresh", "application/json", bytes.NewBuffer(requestBody))
 	if clientErr != nil {
-		log.Fatal().Err(clientErr).Msg("Unable to renew session")
+		return fmt.Errorf("Unable to renew session: %w", clientErr)
 	}
 
 	defer r.Body.Close()
@@ -91,27 +113,25 @@ func (c *NanitClient) RenewSession() error {
 		log.Warn().Msg("Server responded with code 404. This typically means your refresh token has expired. Will try to login with username/password")
 		return ErrExpiredRefreshToken
 	} else if r.StatusCode > 299 || r.StatusCode < 200 {
-		log.Fatal().Int("code", r.StatusCode).Msg("Server responded with an error")
+		return fmt.Errorf("Server responded with an error code %v", r.StatusCode)
 	}
 
 	authResponse := new(authResponsePayload)
 
 	jsonErr := json.NewDecoder(r.Body).Decode(authResponse)
 	if jsonErr != nil {
-		log.Fatal().Err(jsonErr).Msg("Unable to decode response")
+		return fmt.Errorf("Unable to decode response: %w", jsonErr)
 	}
 
-	log.Info().Str("token", utils.AnonymizeToken(authResponse.AccessToken, 4)).Msg("Authorized")
-	log.Info().Str("refresh_token", utils.AnonymizeToken(authResponse.RefreshToken, 4)).Msg("Retreived")
-	c.SessionStore.Session.AuthToken = authResponse.AccessToken
-	c.SessionStore.Session.RefreshToken = authResponse.RefreshToken
-	c.SessionStore.Session.AuthTime = time.Now()
-	c.SessionStore.Save()
+	log.Info().Str("token", utils.AnonymizeToken(authResponse.AccessToken, 0)).Msg("Authorized")
+	log.Info().Str("refresh_token", utils.AnonymizeToken(authResponse.RefreshToken, 0)).Msg("Retreived")
+	c.SessionStore.SetAuth(authResponse.AccessToken, authResponse.RefreshToken)
 
 	return nil
 }
 
-func (c *NanitClient) Login() {
+// Login - performs authorization using user credentials
+func (c *NanitClient) Login() error {
 	log.Info().Str("email", c.Email).Str("password", utils.AnonymizeToken(c.Password, 0)).Msg("Authorizing using user credentials")
 	requestBody, requestBodyErr := json.Marshal(map[string]string{
 		"email":    c.Email,
@@ -119,158 +139,383 @@ func (c *NanitClient) Login() {
 	})
 
 	if requestBodyErr != nil {
-		log.Fatal().Err(requestBodyErr).Msg("Unable to marshal auth body")
+		return fmt.Errorf("Unable to marshal auth body: %w", requestBodyErr)
 	}
 
 	//nanit-api-version: 1
 	req, reqErr := http.NewRequest("POST", "https://api.nanit.com/login", bytes.NewBuffer(requestBody))
 	if reqErr != nil {
-		log.Fatal().Err(reqErr).Msg("Unable to create request")
+		return fmt.Errorf("Unable to create request: %w", reqErr)
 	}
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("nanit-api-version", "1") // required if you have MFA enabled or it'll reject the request
 	r, clientErr := myClient.Do(req)
 	if clientErr != nil {
-		log.Fatal().Err(clientErr).Msg("Unable to fetch auth token")
+		return fmt.Errorf("Unable to fetch auth token: %w", clientErr)
 	}
 
 	defer r.Body.Close()
 
 	if r.StatusCode == 401 {
-		log.Fatal().Msg("Server responded with code 401. Provided credentials has not been accepted by the server. Please check if your e-mail address and password is entered correctly and that 2FA is disabled on your account.")
+		return ErrInvalidCredentials
 	} else if r.StatusCode != 201 {
-		log.Fatal().Int("code", r.StatusCode).Msg("Server responded with unexpected status code")
+		return fmt.Errorf("Server responded with unexpected status code %v", r.StatusCode)
 	}
 
 	authResponse := new(authResponsePayload)
 
 	jsonErr := json.NewDecoder(r.Body).Decode(authResponse)
 	if jsonErr != nil {
-		log.Fatal().Err(jsonErr).Msg("Unable to decode response")
+		return fmt.Errorf("Unable to decode response: %w", jsonErr)
 	}
 
-	log.Info().Str("token", utils.AnonymizeToken(authResponse.AccessToken, 4)).Msg("Authorized")
-	log.Info().Str("refresh_token", utils.AnonymizeToken(authResponse.RefreshToken, 4)).Msg("Retreived")
-	c.SessionStore.Session.AuthToken = authResponse.AccessToken
-	c.SessionStore.Session.RefreshToken = authResponse.RefreshToken
-	c.SessionStore.Session.AuthTime = time.Now()
-	c.SessionStore.Save()
+	log.Info().Str("token", utils.AnonymizeToken(authResponse.AccessToken, 0)).Msg("Authorized")
+	log.Info().Str("refresh_token", utils.AnonymizeToken(authResponse.RefreshToken, 0)).Msg("Retreived")
+	c.SessionStore.SetAuth(authResponse.AccessToken, authResponse.RefreshToken)
+
+	return nil
 }
 
 // FetchAuthorized - makes authorized http request
-func (c *NanitClient) FetchAuthorized(req *http.Request, data interface{}) {
+// Response is decoded into data, unless data is nil
+func (c *NanitClient) FetchAuthorized(req *http.Request, data interface{}) error {
 	for i := 0; i < 2; i++ {
-		if c.SessionStore.Session.AuthToken != "" {
-			req.Header.Set("Authorization", c.SessionStore.Session.AuthToken)
+		if authToken, _ := c.SessionStore.GetAuthToken(); authToken != "" {
+			req.Header.Set("Authorization", authToken)
+
+			// Body has been consumed by the previous attempt
+			if i > 0 && req.GetBody != nil {
+				body, bodyErr := req.GetBody()
+				if bodyErr != nil {
+					return bodyErr
+				}
+
+				req.Body = body
+			}
 
 			res, clientErr := myClient.Do(req)
 			if clientErr != nil {
-				log.Fatal().Err(clientErr).Msg("HTTP request failed")
+				return fmt.Errorf("HTTP request failed: %w", clientErr)
 			}
 
-			defer res.Body.Close()
-
 			if res.StatusCode != 401 {
-				if res.StatusCode != 200 {
-					log.Fatal().Int("code", res.StatusCode).Msg("Server responded with unexpected status code")
-				}
-
-				jsonErr := json.NewDecoder(res.Body).Decode(data)
-				if jsonErr != nil {
-					log.Fatal().Err(jsonErr).Msg("Unable to decode response")
-				}
-
-				return
+				return decodeResponse(res, data)
 			}
 
+			// Closed before the request is retried, not at the end of the whole function
+			res.Body.Close()
 			log.Info().Msg("Token might be expired. Will try to re-authenticate.")
 		}
 
-		c.Authorize()
+		if err := c.Authorize(); err != nil {
+			return err
+		}
 	}
 
-	log.Fatal().Msg("Unable to make request due failed authorization (2 attempts).")
+	return errors.New("Unable to make request due failed authorization (2 attempts)")
+}
+
+// decodeResponse - checks the status code and decodes the response into data (unless it is nil), closes the body
+func decodeResponse(res *http.Response, data interface{}) error {
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("Server responded with unexpected status code %v", res.StatusCode)
+	}
+
+	if data == nil || res.StatusCode == 204 {
+		return nil
+	}
+
+	jsonErr := json.NewDecoder(res.Body).Decode(data)
+	if jsonErr != nil {
+		return fmt.Errorf("Unable to decode response: %w", jsonErr)
+	}
+
+	return nil
 }
 
 // FetchBabies - fetches baby list
-func (c *NanitClient) FetchBabies() []baby.Baby {
+func (c *NanitClient) FetchBabies() ([]baby.Baby, error) {
 	log.Info().Msg("Fetching babies list")
 	req, reqErr := http.NewRequest("GET", "https://api.nanit.com/babies", nil)
 
 	if reqErr != nil {
-		log.Fatal().Err(reqErr).Msg("Unable to create request")
+		return nil, reqErr
 	}
 
 	data := new(babiesResponsePayload)
-	c.FetchAuthorized(req, data)
+	if err := c.FetchAuthorized(req, data); err != nil {
+		return nil, err
+	}
 
-	c.SessionStore.Session.Babies = data.Babies
-	c.SessionStore.Save()
-	return data.Babies
+	c.SessionStore.SetBabies(data.Babies)
+	return data.Babies, nil
 }
 
 // FetchMessages - fetches message list
-func (c *NanitClient) FetchMessages(babyUID string, limit int) []message.Message {
-	req, reqErr := http.NewRequest("GET", fmt.Sprintf("https://api.nanit.com/babies/%s/messages?limit=%d", babyUID, limit), nil)
+// Use beforeID > 0 to fetch older page of messages
+// Note: the before parameter is not documented by Nanit and it could not be confirmed against the live API. It is
+// guarded, messages which are not older than beforeID are dropped, so if the API ignores it the page comes back
+// empty (see paginateMessages for how such a gap is handled).
+func (c *NanitClient) FetchMessages(babyUID string, limit int, beforeID int) ([]message.Message, error) {
+	messages, _, err := c.fetchMessagesPage(babyUID, limit, beforeID)
+	return messages, err
+}
+
+// fetchMessagesPage - same as FetchMessages, also returns number of messages the API responded with (before the guard)
+func (c *NanitClient) fetchMessagesPage(babyUID string, limit int, beforeID int) ([]message.Message, int, error) {
+	reqURL := fmt.Sprintf("https://api.nanit.com/babies/%s/messages?limit=%d", babyUID, limit)
+	if beforeID > 0 {
+		reqURL = fmt.Sprintf("%s&before=%d", reqURL, beforeID)
+	}
+
+	req, reqErr := http.NewRequest("GET", reqURL, nil)
 
 	if reqErr != nil {
-		log.Fatal().Err(reqErr).Msg("Unable to create request")
+		return nil, 0, reqErr
 	}
 
 	data := new(messagesResponsePayload)
-	c.FetchAuthorized(req, data)
+	if err := c.FetchAuthorized(req, data); err != nil {
+		return nil, 0, err
+	}
+
+	if beforeID <= 0 {
+		return data.Messages, len(data.Messages), nil
+	}
+
+	older := make([]message.Message, 0, len(data.Messages))
+	for _, msg := range data.Messages {
+		if msg.Id < beforeID {
+			older = append(older, msg)
+		}
+	}
+
+	if len(older) == 0 && len(data.Messages) > 0 {
+		log.Warn().Str("baby_uid", babyUID).Int("before", beforeID).Msg("Messages API returned no older messages, pagination is likely not supported")
+	}
+
+	return older, len(data.Messages), nil
+}
+
+// FetchEvent - fetches details of a single event
+func (c *NanitClient) FetchEvent(babyUID string, eventUID string) (*message.Event, error) {
+	req, reqErr := http.NewRequest("GET", fmt.Sprintf("https://api.nanit.com/babies/%s/events/%s", babyUID, url.PathEscape(eventUID)), nil)
+
+	if reqErr != nil {
+		return nil, reqErr
+	}
 
-	return data.Messages
+	data := new(eventResponsePayload)
+	if err := c.FetchAuthorized(req, data); err != nil {
+		return nil, err
+	}
+
+	return &data.Event, nil
+}
+
+// UpdateMessage - marks the message as seen, read or dismissed
+func (c *NanitClient) UpdateMessage(babyUID string, messageID int, action message.Action) error {
+	if !action.IsValid() {
+		return fmt.Errorf("Unknown message action %v", action)
+	}
+
+	requestBody, requestBodyErr := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			action.Field(): time.Now().UTC().Format(time.RFC3339),
+		},
+	})
+
+	if requestBodyErr != nil {
+		return requestBodyErr
+	}
+
+	req, reqErr := http.NewRequest("PUT", fmt.Sprintf("https://api.nanit.com/babies/%s/messages/%d", babyUID, messageID), bytes.NewBuffer(requestBody))
+	if reqErr != nil {
+		return reqErr
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	log.Debug().Str("baby_uid", babyUID).Int("message_id", messageID).Str("action", string(action)).Msg("Updating message")
+	return c.FetchAuthorized(req, nil)
+}
+
+// UpdateMessages - marks all given messages as seen, read or dismissed, returns first error
+func (c *NanitClient) UpdateMessages(babyUID string, messageIDs []int, action message.Action) error {
+	var firstErr error
+	for _, messageID := range messageIDs {
+		if err := c.UpdateMessage(babyUID, messageID, action); err != nil {
+			log.Warn().Str("baby_uid", babyUID).Int("message_id", messageID).Str("action", string(action)).Err(err).Msg("Unable to update message")
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// RemoteStreamURL - returns URL of the stream provided by Nanit servers
+func RemoteStreamURL(babyUID string, authToken string) string {
+	return fmt.Sprintf("rtmps://media-secured.nanit.com/nanit/%v.%v", babyUID, authToken)
 }
 
 // EnsureBabies - fetches baby list if not fetched already
 func (c *NanitClient) EnsureBabies() []baby.Baby {
-	if len(c.SessionStore.Session.Babies) == 0 {
-		return c.FetchBabies()
+	if babies := c.SessionStore.GetBabies(); len(babies) > 0 {
+		return babies
+	}
+
+	babies, err := c.FetchBabies()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Unable to fetch babies list")
+	}
+
+	return babies
+}
+
+// FetchNewMessages - fetches all messages of the baby which were not delivered yet, paginating back until the baby's cursor is reached.
+// If there is no cursor yet, only messages younger than defaultMessageTimeout are returned.
+// Messages are returned from the oldest one. The cursor is not moved, call CommitMessages once they are processed.
+// Returns false as complete if some messages between the cursor and the returned ones could not be fetched, the cursor
+// should not be moved past them then.
+func (c *NanitClient) FetchNewMessages(babyUID string, defaultMessageTimeout time.Duration) ([]message.Message, bool, error) {
+	cursor := c.SessionStore.GetMessageCursor(babyUID)
+	log.Debug().Str("baby_uid", babyUID).Time("cursor_time", cursor.Time).Int("cursor_id", cursor.MessageID).Msg("Fetching new messages")
+
+	isDelivered := func(msg message.Message) bool {
+		msgTime := msg.Time.Time()
+		if cursor.IsZero() {
+			return !msgTime.After(time.Now().UTC().Add(-defaultMessageTimeout))
+		} else if msgTime.Equal(cursor.Time) && cursor.MessageID > 0 {
+			return msg.Id <= cursor.MessageID
+		}
+
+		return !msgTime.After(cursor.Time)
+	}
+
+	newMessages, complete, err := c.paginateMessages(babyUID, isDelivered)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !complete {
+		log.Warn().Str("baby_uid", babyUID).Int("fetched", len(newMessages)).Msg("Not all messages since the cursor could be fetched, some might be missing")
 	}
 
-	return c.SessionStore.Session.Babies
+	log.Debug().Str("baby_uid", babyUID).Msgf("Found %d new messages", len(newMessages))
+	log.Trace().Msgf("%+v\n", newMessages)
+
+	return newMessages, complete, nil
+}
+
+// FetchMessagesSince - fetches messages of the baby not older than since, does not touch the cursor
+// Messages are returned from the oldest one
+func (c *NanitClient) FetchMessagesSince(babyUID string, since time.Time) ([]message.Message, error) {
+	messages, complete, err := c.paginateMessages(babyUID, func(msg message.Message) bool {
+		return msg.Time.Time().Before(since)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !complete {
+		log.Warn().Str("baby_uid", babyUID).Time("since", since).Msg("Not all messages since the given time could be fetched")
+	}
+
+	return messages, nil
 }
 
-// FetchNewMessages - fetches 10 newest messages, ignores any messages which were already fetched or which are older than 5 minutes
-func (c *NanitClient) FetchNewMessages(babyUID string, defaultMessageTimeout time.Duration) []message.Message {
-	fetchedMessages := c.FetchMessages(babyUID, 10)
-	newMessages := make([]message.Message, 0)
+// paginateMessages - fetches pages of messages from the newest one until a message satisfying stop (not included) or
+// the end of the list is reached. Messages are returned from the oldest one.
+// Returns false as complete if the pagination had to give up earlier (page limit reached or the API did not return
+// older messages after a full page), the messages between the stop and the returned ones might be missing then.
+func (c *NanitClient) paginateMessages(babyUID string, stop func(msg message.Message) bool) ([]message.Message, bool, error) {
+	messages := make([]message.Message, 0)
+	seenIDs := make(map[int]bool)
+	beforeID := 0
+	complete := false
+
+	for page := 0; page < MessagesMaxPages; page++ {
+		fetchedMessages, numReceived, err := c.fetchMessagesPage(babyUID, MessagesPageSize, beforeID)
+		if err != nil {
+			return nil, false, err
+		}
+
+		// sort fetchedMessages starting with most recent
+		sort.Slice(fetchedMessages, func(i, j int) bool {
+			return isNewerMessage(fetchedMessages[i], fetchedMessages[j])
+		})
+
+		reachedStop := false
+		for _, msg := range fetchedMessages {
+			if stop(msg) {
+				reachedStop = true
+				break
+			}
+
+			if !seenIDs[msg.Id] {
+				seenIDs[msg.Id] = true
+				messages = append(messages, msg)
+			}
+		}
+
+		// Short page is the end of the list, a full one dropped by the guard is not (see FetchMessages)
+		if reachedStop || numReceived < MessagesPageSize {
+			complete = true
+			break
+		}
+
+		if len(fetchedMessages) == 0 {
+			break
+		}
+
+		nextBeforeID := fetchedMessages[len(fetchedMessages)-1].Id
+		if nextBeforeID == beforeID {
+			break
+		}
+
+		beforeID = nextBeforeID
 
-	// return empty [] if there are no fetchedMessages
-	if len(fetchedMessages) == 0 {
-		log.Debug().Msg("No messages fetched")
-		return newMessages
+		if page == MessagesMaxPages-1 {
+			log.Warn().Str("baby_uid", babyUID).Int("pages", MessagesMaxPages).Msg("Reached maximum number of message pages, older messages will be skipped")
+		}
 	}
 
-	// sort fetechedMessages starting with most recent
-	sort.Slice(fetchedMessages, func(i, j int) bool {
-		return fetchedMessages[i].Time.Time().After(fetchedMessages[j].Time.Time())
+	// Deliver from the oldest one
+	sort.Slice(messages, func(i, j int) bool {
+		return isNewerMessage(messages[j], messages[i])
 	})
 
-	lastSeenMessageTime := c.SessionStore.Session.LastSeenMessageTime
-	messageTimeoutTime := lastSeenMessageTime
-	log.Debug().Msgf("Last seen message time was %s", lastSeenMessageTime)
+	return messages, complete, nil
+}
 
-	// Don't know when last message was, set messageTimeout to default
-	if lastSeenMessageTime.IsZero() {
-		messageTimeoutTime = time.Now().UTC().Add(-defaultMessageTimeout)
+// CommitMessages - moves the baby's cursor past given messages, so that they are not delivered again (even after restart)
+func (c *NanitClient) CommitMessages(babyUID string, messages []message.Message) {
+	if len(messages) == 0 {
+		return
 	}
 
-	// lastSeenMessageTime is older than most recent fetchedMessage, or is unset
-	if lastSeenMessageTime.Before(fetchedMessages[0].Time.Time()) {
-		lastSeenMessageTime = fetchedMessages[0].Time.Time()
-		c.SessionStore.Session.LastSeenMessageTime = lastSeenMessageTime
-		c.SessionStore.Save()
+	newest := messages[0]
+	for _, msg := range messages[1:] {
+		if isNewerMessage(msg, newest) {
+			newest = msg
+		}
 	}
 
-	// Only keep messages that are more recent than messageTimeoutTime
-	filteredMessages := message.FilterMessages(fetchedMessages, func(message message.Message) bool {
-		return message.Time.Time().After(messageTimeoutTime)
+	c.SessionStore.SetMessageCursor(babyUID, session.MessageCursor{
+		Time:      newest.Time.Time(),
+		MessageID: newest.Id,
 	})
+}
 
-	log.Debug().Msgf("Found %d new messages", len(filteredMessages))
-	log.Debug().Msgf("%+v\n", filteredMessages)
+func isNewerMessage(a message.Message, b message.Message) bool {
+	if a.Time.Time().Equal(b.Time.Time()) {
+		return a.Id > b.Id
+	}
 
-	return filteredMessages
+	return a.Time.Time().After(b.Time.Time())
 }
@@ -3,17 +3,22 @@ package client
 import (
 	"errors"
 	"fmt"
+	"net/http"
 	sync "sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog/log"
-	"github.com/sacOO7/gowebsocket"
 	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/metrics"
 	"github.com/indiefan/home_assistant_nanit/pkg/session"
 	"github.com/indiefan/home_assistant_nanit/pkg/utils"
 	"google.golang.org/protobuf/proto"
 )
 
+// websocketDispatchQueueSize - number of received messages waiting for the message handlers before reading blocks
+const websocketDispatchQueueSize = 64
+
 type readyState struct {
 	Context    utils.GracefulContext
 	Connection *WebsocketConnection
@@ -26,23 +31,37 @@ type WebsocketConnectionHandler func(*WebsocketConnection, utils.GracefulContext
 type WebsocketConnectionManager struct {
 	BabyUID          string
 	CameraUID        string
-	Session          *session.Session
+	SessionStore     *session.Store
 	API              *NanitClient
 	BabyStateManager *baby.StateManager
 
+	// Capture - optional recorder of all sent and received messages
+	Capture *Capture
+
+	// URL - websocket endpoint, remote Nanit endpoint of the camera if empty
+	URL string
+
+	// PingInterval - how often is the server pinged
+	PingInterval time.Duration
+	// PongTimeout - connection is considered dead if nothing (not even pong) is received for this long
+	PongTimeout time.Duration
+
 	mu               sync.RWMutex
 	readyState       *readyState
 	readySubscribers []WebsocketConnectionHandler
+	numRuns          int32
 }
 
 // NewWebsocketConnectionManager - constructor
-func NewWebsocketConnectionManager(babyUID string, cameraUID string, session *session.Session, api *NanitClient, babyStateManager *baby.StateManager) *WebsocketConnectionManager {
+func NewWebsocketConnectionManager(babyUID string, cameraUID string, sessionStore *session.Store, api *NanitClient, babyStateManager *baby.StateManager) *WebsocketConnectionManager {
 	manager := &WebsocketConnectionManager{
 		BabyUID:          babyUID,
 		CameraUID:        cameraUID,
-		Session:          session,
+		SessionStore:     sessionStore,
 		API:              api,
 		BabyStateManager: babyStateManager,
+		PingInterval:     WebsocketPingInterval,
+		PongTimeout:      WebsocketPongTimeout,
 	}
 
 	manager.WithReadyConnection(func(conn *WebsocketConnection, ctx utils.GracefulContext) {
@@ -93,94 +112,161 @@ func (manager *WebsocketConnectionManager) RunWithinContext(ctx utils.GracefulCo
 }
 
 func (manager *WebsocketConnectionManager) run(attempt utils.AttemptContext) {
+	if atomic.AddInt32(&manager.numRuns, 1) > 1 {
+		metrics.WebsocketReconnects.Inc(manager.CameraUID)
+	}
+
 	// Reauthorize if it is not a first try or we assume we don't have a valid token
-	manager.API.MaybeAuthorize(attempt.GetTry() > 1)
+	if err := manager.API.MaybeAuthorize(attempt.GetTry() > 1); err != nil {
+		log.Error().Err(err).Msg("Unable to authorize websocket connection")
+		attempt.Fail(err)
+		return
+	}
 
 	// Remote
-	url := fmt.Sprintf("wss://api.nanit.com/focus/cameras/%v/user_connect", manager.CameraUID)
-	auth := fmt.Sprintf("Bearer %v", manager.Session.AuthToken)
+	url := manager.URL
+	if url == "" {
+		url = fmt.Sprintf("wss://api.nanit.com/focus/cameras/%v/user_connect", manager.CameraUID)
+	}
+
+	authToken, _ := manager.SessionStore.GetAuthToken()
+	header := http.Header{}
+	header.Set("Authorization", fmt.Sprintf("Bearer %v", authToken))
 
 	// Local
 	// url := "wss://192.168.3.195:442"
-	// auth := fmt.Sprintf("token %v", userCamToken)
+	// header.Set("Authorization", fmt.Sprintf("token %v", userCamToken))
 
 	// -------
 
-	var once sync.Once // Just because gowebsocket is buggy and can invoke OnDisconnect multiple times :-/
+	ctx, cancel := utils.ContextOf(attempt)
+	defer cancel()
 
-	socket := gowebsocket.New(url)
-	socket.RequestHeader.Set("Authorization", auth)
+	log.Trace().Msg("Connecting to websocket")
 
-	// Handle new connection
-	socket.OnConnected = func(socket gowebsocket.Socket) {
-		log.Info().Str("url", url).Msg("Connected to websocket")
+	socket, err := dialWebsocket(ctx, url, header, manager.PongTimeout)
+	if err != nil {
+		log.Error().Str("url", url).Err(err).Msg("Unable to establish websocket connection")
+		attempt.Fail(err)
+		return
+	}
 
-		go func() {
-			conn := NewWebsocketConnection(&socket)
-			readyState := readyState{attempt, conn}
+	log.Info().Str("url", url).Msg("Connected to websocket")
 
-			manager.mu.Lock()
-			manager.readyState = &readyState
-			subscribedHandlers := make([]WebsocketConnectionHandler, len(manager.readySubscribers))
-			copy(subscribedHandlers, manager.readySubscribers)
-			manager.mu.Unlock()
+	conn := newWebsocketConnection(socket)
+	conn.capture = manager.Capture
 
-			manager.BabyStateManager.Update(manager.BabyUID, *baby.NewState().SetWebsocketAlive(true))
+	// Messages are read and dispatched to the handlers in the order of arrival
+	messagesC := make(chan *Message, websocketDispatchQueueSize)
 
-			log.Trace().Int("num_handlers", len(subscribedHandlers)).Msg("Notifying websocket ready handlers")
+	var wg sync.WaitGroup
+	wg.Add(3)
 
-			for _, handler := range subscribedHandlers {
-				notifyReadyHandler(handler, readyState)
-			}
-		}()
-	}
+	go func() {
+		defer wg.Done()
+		manager.readMessages(socket, conn, messagesC, attempt)
 
-	// Handle failed attempts for connection
-	socket.OnConnectError = func(err error, socket gowebsocket.Socket) {
-		log.Error().Str("url", url).Err(err).Msg("Unable to establish websocket connection")
-		attempt.Fail(err)
+		// Requests awaiting the response would never get it, handlers blocked on them must not delay the teardown
+		conn.closePending()
+	}()
+
+	go func() {
+		defer wg.Done()
+		for m := range messagesC {
+			conn.notifyMessageHandlers(m)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		manager.pingPeer(socket, attempt)
+	}()
+
+	// Handle new connection
+	readyState := readyState{attempt, conn}
+
+	manager.mu.Lock()
+	manager.readyState = &readyState
+	subscribedHandlers := make([]WebsocketConnectionHandler, len(manager.readySubscribers))
+	copy(subscribedHandlers, manager.readySubscribers)
+	manager.mu.Unlock()
+
+	manager.BabyStateManager.Update(manager.BabyUID, *baby.NewState().SetWebsocketAlive(true))
+
+	log.Trace().Int("num_handlers", len(subscribedHandlers)).Msg("Notifying websocket ready handlers")
+
+	for _, handler := range subscribedHandlers {
+		notifyReadyHandler(handler, readyState)
 	}
 
-	// Handle lost connection
-	socket.OnDisconnected = func(err error, socket gowebsocket.Socket) {
-		once.Do(func() {
-			manager.BabyStateManager.Update(manager.BabyUID, *baby.NewState().SetWebsocketAlive(false))
+	<-attempt.Done()
+
+	log.Debug().Msg("Closing websocket")
+	socket.close()
+	wg.Wait()
+
+	manager.BabyStateManager.Update(manager.BabyUID, *baby.NewState().SetWebsocketAlive(false))
+}
+
+// readMessages - reads messages until the connection is lost, responses are resolved right away so that
+// message handlers can await them
+func (manager *WebsocketConnectionManager) readMessages(socket *websocketTransport, conn *WebsocketConnection, messagesC chan<- *Message, attempt utils.AttemptContext) {
+	defer close(messagesC)
+
+	for {
+		data, err := socket.read()
+		if err != nil {
+			select {
+			case <-attempt.Done():
+				// Closed by us
+			default:
+				if errors.Is(err, errServerClosed) {
+					log.Warn().Msg("Disconnected from server")
+				} else {
+					log.Error().Err(err).Msg("Disconnected from server")
+				}
 
-			if err != nil {
-				log.Error().Err(err).Msg("Disconnected from server")
 				attempt.Fail(err)
-			} else {
-				log.Warn().Msg("Disconnected from server")
-				attempt.Fail(errors.New("Server closed the connection"))
 			}
-		})
-	}
 
-	socket.OnBinaryMessage = func(data []byte, _ gowebsocket.Socket) {
+			return
+		}
+
 		m := &Message{}
-		err := proto.Unmarshal(data, m)
-		if err != nil {
+		if err := proto.Unmarshal(data, m); err != nil {
 			log.Error().Err(err).Bytes("rawdata", data).Msg("Received malformed binary message")
-			return
+			continue
 		}
 
 		log.Debug().Stringer("data", m).Msg("Received message")
+		manager.Capture.Record(CaptureInbound, m)
 
-		manager.mu.RLock()
-		readyState := manager.readyState
-		manager.mu.RUnlock()
+		conn.resolveResponse(m)
 
-		go readyState.Connection.handleMessage(m)
+		select {
+		case messagesC <- m:
+		case <-attempt.Done():
+			return
+		}
 	}
+}
 
-	log.Trace().Msg("Connecting to websocket")
-	socket.Connect()
+// pingPeer - pings the server periodically, missing pong is detected by the read deadline
+func (manager *WebsocketConnectionManager) pingPeer(socket *websocketTransport, attempt utils.AttemptContext) {
+	ticker := time.NewTicker(manager.PingInterval)
+	defer ticker.Stop()
 
-	<-attempt.Done()
-
-	if socket.IsConnected {
-		log.Debug().Msg("Closing websocket")
-		socket.Close()
+	for {
+		select {
+		case <-attempt.Done():
+			return
+		case <-ticker.C:
+			if err := socket.ping(); err != nil {
+				log.Error().Err(err).Msg("Unable to ping websocket server")
+				attempt.Fail(err)
+				return
+			}
+		}
 	}
 }
 
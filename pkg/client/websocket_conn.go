@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -9,7 +10,7 @@ import (
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"github.com/sacOO7/gowebsocket"
+	"github.com/indiefan/home_assistant_nanit/pkg/metrics"
 	"github.com/indiefan/home_assistant_nanit/pkg/utils"
 	"google.golang.org/protobuf/proto"
 )
@@ -19,26 +20,35 @@ type WebsocketMessageHandler func(*Message, *WebsocketConnection)
 
 // WebsocketConnection - ready websocket connection
 type WebsocketConnection struct {
-	socket *gowebsocket.Socket
+	socket *websocketTransport
+
+	// capture - optional recorder of sent messages
+	capture *Capture
 
 	msgHandlersMu sync.RWMutex
 	msgHandlers   []WebsocketMessageHandler
 
-	resHandlersMu sync.RWMutex
-	resHandlers   map[int32]unhandledRequest
+	resHandlersMu sync.Mutex
+	resHandlers   map[int32]pendingRequest
+	closed        bool
 
 	lastRequestID int32
 }
 
-// NewWebsocketConnection - constructor
-func NewWebsocketConnection(socket *gowebsocket.Socket) *WebsocketConnection {
+func newWebsocketConnection(socket *websocketTransport) *WebsocketConnection {
 	return &WebsocketConnection{
 		socket:        socket,
-		resHandlers:   make(map[int32]unhandledRequest),
+		resHandlers:   make(map[int32]pendingRequest),
 		lastRequestID: 0,
 	}
 }
 
+// NewReplayConnection - constructor of a connection without socket for replaying captures (see Replay)
+// Sent messages are dropped
+func NewReplayConnection() *WebsocketConnection {
+	return newWebsocketConnection(nil)
+}
+
 // RegisterMessageHandler - registers handler which will be called whenever new message is received
 func (conn *WebsocketConnection) RegisterMessageHandler(handler WebsocketMessageHandler) {
 	conn.msgHandlersMu.Lock()
@@ -47,8 +57,14 @@ func (conn *WebsocketConnection) RegisterMessageHandler(handler WebsocketMessage
 }
 
 // SendMessage - low-level helper for sending raw message
-// Note: Use SendRequest() for requests
+// Note: Use Do() or Camera for requests
 func (conn *WebsocketConnection) SendMessage(m *Message) {
+	if err := conn.send(m); err != nil {
+		log.Error().Err(err).Msg("Unable to send websocket message")
+	}
+}
+
+func (conn *WebsocketConnection) send(m *Message) error {
 	var msg *zerolog.Event
 
 	if *m.Type == Message_KEEPALIVE {
@@ -62,11 +78,50 @@ func (conn *WebsocketConnection) SendMessage(m *Message) {
 	bytes := getMessageBytes(m)
 	log.Trace().Bytes("rawdata", bytes).Msg("Sending data")
 
-	conn.socket.SendBinary(bytes)
+	conn.capture.Record(CaptureOutbound, m)
+
+	if conn.socket == nil {
+		return nil
+	}
+
+	return conn.socket.writeBinary(bytes)
+}
+
+// Do - sends request to the cam and waits for the response until the context is done
+// Returns the response together with *StatusError if the cam responded with non-200 status
+func (conn *WebsocketConnection) Do(ctx context.Context, reqType RequestType, requestData *Request) (*Response, error) {
+	id, resC, err := conn.sendRequest(reqType, requestData)
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.awaitResponse(ctx, id, reqType, resC)
 }
 
 // SendRequest - sends request to the cam and returns await function. Await function waits for the response and returns it
+// Note: Prefer Do() or the typed API of Camera
 func (conn *WebsocketConnection) SendRequest(reqType RequestType, requestData *Request) func(time.Duration) (*Response, error) {
+	id, resC, err := conn.sendRequest(reqType, requestData)
+
+	return func(timeout time.Duration) (*Response, error) {
+		if err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		return conn.awaitResponse(ctx, id, reqType, resC)
+	}
+}
+
+// pendingRequest - request awaiting the response, resC receives at most one response and is closed on disconnect
+type pendingRequest struct {
+	requestType RequestType
+	resC        chan *Response
+}
+
+func (conn *WebsocketConnection) sendRequest(reqType RequestType, requestData *Request) (int32, chan *Response, error) {
 	// Build request
 	id := atomic.AddInt32(&conn.lastRequestID, 1)
 
@@ -82,76 +137,89 @@ func (conn *WebsocketConnection) SendRequest(reqType RequestType, requestData *R
 	resC := make(chan *Response, 1)
 
 	conn.resHandlersMu.Lock()
-	conn.resHandlers[id] = unhandledRequest{
-		Request: m.Request,
-		HandleResponse: func(res *Response) {
-			select {
-			case <-resC:
-				return // Channel already closed (ie. timeout)
-			default:
-				resC <- res
-			}
-		},
+	if conn.closed {
+		conn.resHandlersMu.Unlock()
+		return 0, nil, ErrConnectionClosed
 	}
+
+	conn.resHandlers[id] = pendingRequest{requestType: reqType, resC: resC}
 	conn.resHandlersMu.Unlock()
 
 	// Send request
-	conn.SendMessage(m)
+	if err := conn.send(m); err != nil {
+		conn.resHandlersMu.Lock()
+		delete(conn.resHandlers, id)
+		conn.resHandlersMu.Unlock()
 
-	// Return awaiter
-	return func(timeout time.Duration) (*Response, error) {
-		timer := time.NewTimer(timeout)
-
-		select {
-		case <-timer.C:
-			close(resC)
-			return nil, errors.New("Request timeout")
-		case res := <-resC:
-			close(resC)
-			timer.Stop()
-
-			if res.StatusCode == nil {
-				return res, errors.New("No status code received")
-			} else if *res.StatusCode != 200 {
-				if res.GetStatusMessage() != "" {
-					return res, errors.New(res.GetStatusMessage())
-				}
-
-				return res, fmt.Errorf("Unexpected status code %v", *res.StatusCode)
-			}
-
-			return res, nil
-		}
+		return 0, nil, fmt.Errorf("%w: %v", ErrConnectionClosed, err)
 	}
-}
 
-type unhandledRequest struct {
-	Request        *Request
-	HandleResponse func(response *Response)
+	return id, resC, nil
 }
 
-func (conn *WebsocketConnection) handleResponse(r *Response) {
-	requestID := *r.RequestId
-	requestType := *r.RequestType
+func (conn *WebsocketConnection) awaitResponse(ctx context.Context, id int32, reqType RequestType, resC chan *Response) (*Response, error) {
+	select {
+	case res, ok := <-resC:
+		if !ok {
+			return nil, ErrConnectionClosed
+		}
 
-	conn.resHandlersMu.RLock()
-	unhandledReqCandidate, ok := conn.resHandlers[requestID]
-	conn.resHandlersMu.RUnlock()
+		return res, checkResponse(res)
 
-	if ok && requestType == *unhandledReqCandidate.Request.Type {
+	case <-ctx.Done():
 		conn.resHandlersMu.Lock()
-		delete(conn.resHandlers, requestID)
+		delete(conn.resHandlers, id)
 		conn.resHandlersMu.Unlock()
 
-		unhandledReqCandidate.HandleResponse(r)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			metrics.RequestTimeouts.Inc(reqType.String())
+			return nil, fmt.Errorf("%w: %v", ErrRequestTimeout, reqType)
+		}
+
+		return nil, ctx.Err()
+	}
+}
+
+// closePending - fails all requests awaiting the response with ErrConnectionClosed, new requests fail immediately
+func (conn *WebsocketConnection) closePending() {
+	conn.resHandlersMu.Lock()
+	defer conn.resHandlersMu.Unlock()
+
+	conn.closed = true
+	for id, req := range conn.resHandlers {
+		close(req.resC)
+		delete(conn.resHandlers, id)
+	}
+}
+
+func (conn *WebsocketConnection) handleResponse(r *Response) {
+	conn.resHandlersMu.Lock()
+	req, ok := conn.resHandlers[r.GetRequestId()]
+	if ok && req.requestType == r.GetRequestType() {
+		delete(conn.resHandlers, r.GetRequestId())
+	} else {
+		ok = false
+	}
+	conn.resHandlersMu.Unlock()
+
+	if ok {
+		req.resC <- r
 	}
 }
 
 func (conn *WebsocketConnection) handleMessage(m *Message) {
-	if *m.Type == Message_RESPONSE && m.Response != nil {
+	conn.resolveResponse(m)
+	conn.notifyMessageHandlers(m)
+}
+
+// resolveResponse - passes the response to the request awaiting it, never blocks
+func (conn *WebsocketConnection) resolveResponse(m *Message) {
+	if m.GetType() == Message_RESPONSE && m.Response != nil {
 		conn.handleResponse(m.Response)
 	}
+}
 
+func (conn *WebsocketConnection) notifyMessageHandlers(m *Message) {
 	conn.msgHandlersMu.RLock()
 	subscribedHandlers := make([]WebsocketMessageHandler, len(conn.msgHandlers))
 	copy(subscribedHandlers, conn.msgHandlers)
@@ -0,0 +1,182 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/client"
+	"github.com/indiefan/home_assistant_nanit/pkg/session"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+// newTestManager - connection manager of a fake camera server handled by serveCamera
+func newTestManager(t *testing.T, serveCamera func(*websocket.Conn)) *client.WebsocketConnectionManager {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		defer c.Close()
+		serveCamera(c)
+	}))
+	t.Cleanup(server.Close)
+
+	store := &session.Store{Session: &session.Session{AuthToken: "token", AuthTime: time.Now()}}
+	api := &client.NanitClient{SessionStore: store}
+
+	manager := client.NewWebsocketConnectionManager("b1", "c1", store, api, baby.NewStateManager())
+	manager.URL = "ws" + strings.TrimPrefix(server.URL, "http")
+
+	return manager
+}
+
+func TestWebsocketOrderedDispatch(t *testing.T) {
+	const numMessages = 50
+
+	manager := newTestManager(t, func(c *websocket.Conn) {
+		// Wait for the client to be ready
+		if _, _, err := c.ReadMessage(); err != nil {
+			return
+		}
+
+		for i := 1; i <= numMessages; i++ {
+			data, _ := proto.Marshal(&client.Message{
+				Type: client.Message_REQUEST.Enum(),
+				Request: &client.Request{
+					Id:   utils.ConstRefInt32(int32(i)),
+					Type: client.RequestType_PUT_SENSOR_DATA.Enum(),
+				},
+			})
+
+			c.WriteMessage(websocket.BinaryMessage, data)
+		}
+
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	var mu sync.Mutex
+	var received []int32
+	doneC := make(chan struct{})
+
+	manager.WithReadyConnection(func(conn *client.WebsocketConnection, ctx utils.GracefulContext) {
+		conn.RegisterMessageHandler(func(m *client.Message, _ *client.WebsocketConnection) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			received = append(received, m.GetRequest().GetId())
+			if len(received) == numMessages {
+				close(doneC)
+			}
+		})
+
+		conn.SendMessage(&client.Message{Type: client.Message_KEEPALIVE.Enum()})
+	})
+
+	runner := utils.RunWithGracefulCancel(manager.RunWithinContext)
+	defer runner.Cancel()
+
+	select {
+	case <-doneC:
+	case <-time.After(5 * time.Second):
+		t.Fatal("messages were not received")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i, id := range received {
+		assert.Equal(t, int32(i+1), id)
+	}
+
+	assert.True(t, manager.BabyStateManager.GetBabyState("b1").GetIsWebsocketAlive())
+}
+
+func TestWebsocketDeadPeer(t *testing.T) {
+	stopC := make(chan struct{})
+	defer close(stopC)
+
+	// Server never reads, so pings are never answered
+	manager := newTestManager(t, func(c *websocket.Conn) {
+		<-stopC
+	})
+	manager.PingInterval = 50 * time.Millisecond
+	manager.PongTimeout = 300 * time.Millisecond
+
+	errC := make(chan error, 1)
+	manager.WithReadyConnection(func(conn *client.WebsocketConnection, ctx utils.GracefulContext) {
+		_, err := client.NewCamera(conn).GetSettings(context.Background())
+		errC <- err
+	})
+
+	runner := utils.RunWithGracefulCancel(manager.RunWithinContext)
+	defer runner.Cancel()
+
+	select {
+	case err := <-errC:
+		// Pending request fails as soon as the connection is considered dead
+		assert.True(t, errors.Is(err, client.ErrConnectionClosed))
+	case <-time.After(5 * time.Second):
+		t.Fatal("dead peer was not detected")
+	}
+
+	assert.Eventually(t, func() bool {
+		return !manager.BabyStateManager.GetBabyState("b1").GetIsWebsocketAlive()
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestWebsocketHandlerAwaitingResponse(t *testing.T) {
+	// Server closes the connection without answering the request
+	manager := newTestManager(t, func(c *websocket.Conn) {
+		// Wait for the client to be ready
+		if _, _, err := c.ReadMessage(); err != nil {
+			return
+		}
+
+		data, _ := proto.Marshal(&client.Message{Type: client.Message_KEEPALIVE.Enum()})
+		c.WriteMessage(websocket.BinaryMessage, data)
+		c.ReadMessage()
+	})
+
+	errC := make(chan error, 1)
+	manager.WithReadyConnection(func(conn *client.WebsocketConnection, ctx utils.GracefulContext) {
+		conn.RegisterMessageHandler(func(m *client.Message, conn *client.WebsocketConnection) {
+			// Handler is blocked on the request, so the dispatcher can not finish until it is resolved
+			_, err := client.NewCamera(conn).GetSettings(context.Background())
+			errC <- err
+		})
+
+		conn.SendMessage(&client.Message{Type: client.Message_KEEPALIVE.Enum()})
+	})
+
+	runner := utils.RunWithGracefulCancel(manager.RunWithinContext)
+	defer runner.Cancel()
+
+	select {
+	case err := <-errC:
+		assert.True(t, errors.Is(err, client.ErrConnectionClosed))
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending request was not closed")
+	}
+
+	assert.Eventually(t, func() bool {
+		return !manager.BabyStateManager.GetBabyState("b1").GetIsWebsocketAlive()
+	}, time.Second, 10*time.Millisecond)
+}
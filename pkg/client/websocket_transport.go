@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// errServerClosed - server closed the websocket with a normal closure
+var errServerClosed = errors.New("server closed the connection")
+
+// websocketTransport - websocket with serialized writes and ping / pong based dead peer detection
+type websocketTransport struct {
+	conn *websocket.Conn
+
+	// writeMu - websocket supports only one concurrent writer of data messages (control messages can be sent concurrently)
+	writeMu sync.Mutex
+
+	pongTimeout time.Duration
+}
+
+// dialWebsocket - opens websocket connection, dialing is cancelled together with the context
+func dialWebsocket(ctx context.Context, url string, header http.Header, pongTimeout time.Duration) (*websocketTransport, error) {
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: WebsocketHandshakeTimeout,
+	}
+
+	conn, res, err := dialer.DialContext(ctx, url, header)
+	if err != nil {
+		if res != nil {
+			return nil, fmt.Errorf("%w (%v)", err, res.Status)
+		}
+
+		return nil, err
+	}
+
+	t := &websocketTransport{conn: conn, pongTimeout: pongTimeout}
+
+	// Any received frame proves the peer is alive
+	conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+
+	return t, nil
+}
+
+// read - blocks until next binary message is received, fails once the peer does not respond within the pong timeout
+func (t *websocketTransport) read() ([]byte, error) {
+	for {
+		messageType, data, err := t.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return nil, errServerClosed
+			}
+
+			return nil, err
+		}
+
+		t.conn.SetReadDeadline(time.Now().Add(t.pongTimeout))
+
+		if messageType == websocket.BinaryMessage {
+			return data, nil
+		}
+	}
+}
+
+// writeBinary - sends binary message, the write is abandoned after WebsocketWriteTimeout
+func (t *websocketTransport) writeBinary(data []byte) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	t.conn.SetWriteDeadline(time.Now().Add(WebsocketWriteTimeout))
+	return t.conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+// ping - sends ping, the peer is expected to respond with pong before the read deadline
+func (t *websocketTransport) ping() error {
+	return t.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(WebsocketWriteTimeout))
+}
+
+// close - politely closes the websocket, pending read fails
+func (t *websocketTransport) close() {
+	t.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	t.conn.Close()
+}
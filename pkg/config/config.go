@@ -0,0 +1,266 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config - application configuration
+// Loaded from an optional YAML file, every setting can be overridden by the environment variable in its env tag
+// Settings with the secret tag can be also read from a file ({VAR}_FILE) or registered secret providers
+type Config struct {
+	DataDir     string `yaml:"data_dir" env:"NANIT_DATA_DIR"`
+	LogLevel    string `yaml:"log_level" env:"NANIT_LOG_LEVEL"`
+	SessionFile string `yaml:"session_file" env:"NANIT_SESSION_FILE"`
+	SessionKey  string `yaml:"session_key" env:"NANIT_SESSION_KEY" secret:"true"`
+
+	// BabiesRefreshInterval - how often the babies list is refetched (0 = only on demand)
+	BabiesRefreshInterval Duration `yaml:"babies_refresh_interval" env:"NANIT_BABIES_REFRESH_INTERVAL"`
+
+	// AdminToken - enables raw camera requests over HTTP and MQTT guarded by this token
+	AdminToken string `yaml:"admin_token" env:"NANIT_ADMIN_TOKEN" secret:"true"`
+
+	// WebsocketCapture - records all camera websocket messages into {data_dir}/captures
+	WebsocketCapture bool `yaml:"websocket_capture" env:"NANIT_WEBSOCKET_CAPTURE"`
+
+	Nanit    NanitConfig    `yaml:"nanit"`
+	HTTP     HTTPConfig     `yaml:"http"`
+	RTMP     RTMPConfig     `yaml:"rtmp"`
+	MQTT     MQTTConfig     `yaml:"mqtt"`
+	Events   EventsConfig   `yaml:"events"`
+	Health   HealthConfig   `yaml:"health"`
+	History  HistoryConfig  `yaml:"history"`
+	Report   ReportConfig   `yaml:"report"`
+	Sleep    SleepConfig    `yaml:"sleep"`
+	Webhooks WebhooksConfig `yaml:"webhooks"`
+	Influx   InfluxConfig   `yaml:"influx"`
+
+	Staleness StalenessConfig `yaml:"staleness"`
+
+	// Babies - per baby overrides (by baby UID), only available in the file
+	Babies map[string]BabyConfig `yaml:"babies"`
+}
+
+// NanitConfig - Nanit account credentials
+type NanitConfig struct {
+	Email        string `yaml:"email" env:"NANIT_EMAIL"`
+	Password     string `yaml:"password" env:"NANIT_PASSWORD" secret:"true"`
+	RefreshToken string `yaml:"refresh_token" env:"NANIT_REFRESH_TOKEN" secret:"true"`
+}
+
+// HTTPConfig - integrated HTTP server
+type HTTPConfig struct {
+	Enabled bool `yaml:"enabled" env:"NANIT_HTTP_ENABLED"`
+}
+
+// RTMPConfig - integrated RTMP server
+type RTMPConfig struct {
+	Enabled bool `yaml:"enabled" env:"NANIT_RTMP_ENABLED"`
+
+	// Addr - IP:Port under which is the app reachable from the cam
+	Addr string `yaml:"addr" env:"NANIT_RTMP_ADDR"`
+}
+
+// MQTTConfig - MQTT integration
+type MQTTConfig struct {
+	Enabled       bool     `yaml:"enabled" env:"NANIT_MQTT_ENABLED"`
+	BrokerURL     string   `yaml:"broker_url" env:"NANIT_MQTT_BROKER_URL"`
+	ClientID      string   `yaml:"client_id" env:"NANIT_MQTT_CLIENT_ID"`
+	Username      string   `yaml:"username" env:"NANIT_MQTT_USERNAME"`
+	Password      string   `yaml:"password" env:"NANIT_MQTT_PASSWORD" secret:"true"`
+	Prefix        string   `yaml:"prefix" env:"NANIT_MQTT_PREFIX"`
+	EventHoldTime Duration `yaml:"event_hold_time" env:"NANIT_MQTT_EVENT_HOLD_TIME"`
+}
+
+// EventsConfig - polling of event messages
+type EventsConfig struct {
+	Polling         bool     `yaml:"polling" env:"NANIT_EVENTS_POLLING"`
+	PollingInterval Duration `yaml:"polling_interval" env:"NANIT_EVENTS_POLLING_INTERVAL"`
+	PollingJitter   Duration `yaml:"polling_jitter" env:"NANIT_EVENTS_POLLING_JITTER"`
+	MessageTimeout  Duration `yaml:"message_timeout" env:"NANIT_EVENTS_MESSAGE_TIMEOUT"`
+	AutoMarkSeen    bool     `yaml:"auto_mark_seen" env:"NANIT_EVENTS_AUTO_MARK_SEEN"`
+}
+
+// HealthConfig - readiness thresholds
+type HealthConfig struct {
+	WebsocketGracePeriod Duration `yaml:"websocket_grace_period" env:"NANIT_HEALTH_WEBSOCKET_GRACE_PERIOD"`
+	StreamGracePeriod    Duration `yaml:"stream_grace_period" env:"NANIT_HEALTH_STREAM_GRACE_PERIOD"`
+
+	// EventPollMaxAge - 0 = 5 polling intervals
+	EventPollMaxAge Duration `yaml:"event_poll_max_age" env:"NANIT_HEALTH_EVENT_POLL_MAX_AGE"`
+}
+
+// HistoryConfig - state history store
+type HistoryConfig struct {
+	Enabled   bool     `yaml:"enabled" env:"NANIT_HISTORY_ENABLED"`
+	Retention Duration `yaml:"retention" env:"NANIT_HISTORY_RETENTION"`
+}
+
+// ReportConfig - night reports
+type ReportConfig struct {
+	Enabled    bool   `yaml:"enabled" env:"NANIT_REPORT_ENABLED"`
+	NightStart string `yaml:"night_start" env:"NANIT_REPORT_NIGHT_START"`
+	NightEnd   string `yaml:"night_end" env:"NANIT_REPORT_NIGHT_END"`
+
+	// Comfort bands as MIN-MAX
+	ComfortTemperature string `yaml:"comfort_temperature" env:"NANIT_REPORT_COMFORT_TEMPERATURE"`
+	ComfortHumidity    string `yaml:"comfort_humidity" env:"NANIT_REPORT_COMFORT_HUMIDITY"`
+}
+
+// SleepConfig - sleep tracking heuristics
+type SleepConfig struct {
+	Enabled         bool     `yaml:"enabled" env:"NANIT_SLEEP_ENABLED"`
+	FallAsleepAfter Duration `yaml:"fall_asleep_after" env:"NANIT_SLEEP_FALL_ASLEEP_AFTER"`
+	WakeEvents      int      `yaml:"wake_events" env:"NANIT_SLEEP_WAKE_EVENTS"`
+	WakeWindow      Duration `yaml:"wake_window" env:"NANIT_SLEEP_WAKE_WINDOW"`
+	NightOnly       bool     `yaml:"night_only" env:"NANIT_SLEEP_NIGHT_ONLY"`
+}
+
+// WebhooksConfig - webhooks
+// Hooks are replaced as a whole by numbered NANIT_WEBHOOK_{N}_* variables if there are any
+type WebhooksConfig struct {
+	Timeout Duration        `yaml:"timeout" env:"NANIT_WEBHOOK_TIMEOUT"`
+	Hooks   []WebhookConfig `yaml:"hooks"`
+}
+
+// WebhookConfig - single webhook
+type WebhookConfig struct {
+	URL    string   `yaml:"url"`
+	Secret string   `yaml:"secret" secret:"true"`
+	Babies []string `yaml:"babies"`
+	Fields []string `yaml:"fields"`
+	Events []string `yaml:"events"`
+}
+
+// InfluxConfig - InfluxDB / VictoriaMetrics output using the line protocol
+type InfluxConfig struct {
+	Enabled bool `yaml:"enabled" env:"NANIT_INFLUX_ENABLED"`
+
+	// URL - write endpoint, ie. http://influxdb:8086/api/v2/write?org=home&bucket=nanit
+	URL   string `yaml:"url" env:"NANIT_INFLUX_URL"`
+	Token string `yaml:"token" env:"NANIT_INFLUX_TOKEN" secret:"true"`
+
+	Measurement   string   `yaml:"measurement" env:"NANIT_INFLUX_MEASUREMENT"`
+	BatchSize     int      `yaml:"batch_size" env:"NANIT_INFLUX_BATCH_SIZE"`
+	FlushInterval Duration `yaml:"flush_interval" env:"NANIT_INFLUX_FLUSH_INTERVAL"`
+	Timeout       Duration `yaml:"timeout" env:"NANIT_INFLUX_TIMEOUT"`
+}
+
+// StalenessConfig - state fields which were not reported by the camera for too long are marked unavailable
+type StalenessConfig struct {
+	// Sensors - TTL of temperature, humidity and night mode (0 = never stale)
+	Sensors Duration `yaml:"sensors" env:"NANIT_STALENESS_SENSORS"`
+
+	// Fields - TTLs by state field name (ie. temperature: 10m), override Sensors, only available in the file
+	Fields map[string]Duration `yaml:"fields"`
+}
+
+// BabyConfig - per baby overrides
+type BabyConfig struct {
+	// Streaming - enables / disables local streaming of the baby (default: enabled if RTMP is enabled)
+	Streaming *bool `yaml:"streaming"`
+
+	// MQTTPrefix - topic prefix used instead of the global one
+	MQTTPrefix string `yaml:"mqtt_prefix"`
+
+	// Comfort bands as MIN-MAX used in night reports
+	ComfortTemperature string `yaml:"comfort_temperature"`
+	ComfortHumidity    string `yaml:"comfort_humidity"`
+}
+
+// Default - returns configuration with default values
+func Default() *Config {
+	return &Config{
+		DataDir:               "/data",
+		LogLevel:              "info",
+		SessionFile:           "/data/session.json",
+		BabiesRefreshInterval: Duration(1 * time.Hour),
+		RTMP: RTMPConfig{
+			Enabled: true,
+		},
+		MQTT: MQTTConfig{
+			ClientID:      "nanit",
+			Prefix:        "nanit",
+			EventHoldTime: Duration(60 * time.Second),
+		},
+		Events: EventsConfig{
+			PollingInterval: Duration(30 * time.Second),
+			PollingJitter:   Duration(5 * time.Second),
+			// Unseen messages are ignored once they are this old
+			MessageTimeout: Duration(300 * time.Second),
+		},
+		Health: HealthConfig{
+			WebsocketGracePeriod: Duration(2 * time.Minute),
+			StreamGracePeriod:    Duration(2 * time.Minute),
+		},
+		History: HistoryConfig{
+			Retention: Duration(30 * 24 * time.Hour),
+		},
+		Report: ReportConfig{
+			NightStart:         "19:00",
+			NightEnd:           "07:00",
+			ComfortTemperature: "19-22",
+			ComfortHumidity:    "40-60",
+		},
+		Sleep: SleepConfig{
+			FallAsleepAfter: Duration(15 * time.Minute),
+			WakeEvents:      3,
+			WakeWindow:      Duration(5 * time.Minute),
+		},
+		Webhooks: WebhooksConfig{
+			Timeout: Duration(10 * time.Second),
+		},
+		Influx: InfluxConfig{
+			Measurement:   "nanit",
+			BatchSize:     500,
+			FlushInterval: Duration(10 * time.Second),
+			Timeout:       Duration(10 * time.Second),
+		},
+	}
+}
+
+// Load - loads configuration from the file (optional) and applies environment overrides
+// Returns all problems found, configuration is usable only if there are none
+func Load(filename string, lookupEnv func(string) (string, bool)) (*Config, []error) {
+	cfg := Default()
+	var errs []error
+
+	if filename != "" {
+		errs = append(errs, cfg.loadFile(filename)...)
+	}
+
+	errs = append(errs, applyEnv(cfg, lookupEnv)...)
+
+	return cfg, errs
+}
+
+func (cfg *Config) loadFile(filename string) []error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return []error{fmt.Errorf("unable to read config file: %w", err)}
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	err = decoder.Decode(cfg)
+
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		errs := make([]error, 0, len(typeErr.Errors))
+		for _, msg := range typeErr.Errors {
+			errs = append(errs, fmt.Errorf("%v: %v", filename, msg))
+		}
+
+		return errs
+	} else if err != nil && err.Error() != "EOF" {
+		return []error{fmt.Errorf("%v: %w", filename, err)}
+	}
+
+	return nil
+}
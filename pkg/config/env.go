@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+)
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// applyEnv - overrides configuration by environment variables listed in the env tags
+// Empty variables are ignored, same as unset ones
+func applyEnv(cfg *Config, lookupEnv func(string) (string, bool)) []error {
+	errs := applyEnvToStruct(reflect.ValueOf(cfg).Elem(), lookupEnv)
+
+	hooks, hookErrs := webhooksFromEnv(lookupEnv)
+	errs = append(errs, hookErrs...)
+	if len(hooks) > 0 {
+		cfg.Webhooks.Hooks = hooks
+	}
+
+	return errs
+}
+
+func applyEnvToStruct(v reflect.Value, lookupEnv func(string) (string, bool)) []error {
+	var errs []error
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		if field.Type.Kind() == reflect.Struct {
+			errs = append(errs, applyEnvToStruct(v.Field(i), lookupEnv)...)
+			continue
+		}
+
+		varName := field.Tag.Get("env")
+		if varName == "" {
+			continue
+		}
+
+		value, found := lookupEnv(varName)
+		if field.Tag.Get("secret") == "true" {
+			var err error
+			if value, found, err = utils.LookupSecret(varName, lookupEnv); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+
+		if !found || value == "" {
+			continue
+		}
+
+		if err := setValue(v.Field(i), value); err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", varName, err))
+		}
+	}
+
+	return errs
+}
+
+func setValue(v reflect.Value, value string) error {
+	if v.Type() == durationType {
+		d, err := ParseDuration(value)
+		if err != nil {
+			return err
+		}
+
+		v.Set(reflect.ValueOf(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		if value != "true" && value != "false" {
+			return fmt.Errorf("unexpected value %q (allowed values true, false)", value)
+		}
+
+		v.SetBool(value == "true")
+	case reflect.Int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("unexpected value %q, expected integer", value)
+		}
+
+		v.SetInt(int64(i))
+	default:
+		return fmt.Errorf("unsupported type %v", v.Type())
+	}
+
+	return nil
+}
+
+// webhooksFromEnv - reads numbered webhook definitions (NANIT_WEBHOOK_1_URL, NANIT_WEBHOOK_2_URL, ...)
+func webhooksFromEnv(lookupEnv func(string) (string, bool)) ([]WebhookConfig, []error) {
+	var hooks []WebhookConfig
+	var errs []error
+
+	str := func(varName string) string {
+		value, _ := lookupEnv(varName)
+		return value
+	}
+
+	list := func(varName string) []string {
+		var items []string
+		for _, item := range strings.Split(str(varName), ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+
+		return items
+	}
+
+	for i := 1; ; i++ {
+		prefix := fmt.Sprintf("NANIT_WEBHOOK_%v_", i)
+		url := str(prefix + "URL")
+		if url == "" {
+			break
+		}
+
+		secret, _, err := utils.LookupSecret(prefix+"SECRET", lookupEnv)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		hooks = append(hooks, WebhookConfig{
+			URL:    url,
+			Secret: secret,
+			Babies: list(prefix + "BABIES"),
+			Fields: list(prefix + "FIELDS"),
+			Events: list(prefix + "EVENTS"),
+		})
+	}
+
+	return hooks, errs
+}
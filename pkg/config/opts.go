@@ -0,0 +1,184 @@
+package config
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/app"
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/history"
+	"github.com/indiefan/home_assistant_nanit/pkg/influx"
+	"github.com/indiefan/home_assistant_nanit/pkg/mqtt"
+	"github.com/indiefan/home_assistant_nanit/pkg/report"
+	"github.com/indiefan/home_assistant_nanit/pkg/sleep"
+	"github.com/indiefan/home_assistant_nanit/pkg/webhook"
+)
+
+// AppOpts - maps validated configuration onto application options
+func (cfg *Config) AppOpts(dataDirs app.DataDirectories) app.Opts {
+	var sessionKey []byte
+	if cfg.SessionKey != "" {
+		sessionKey = []byte(cfg.SessionKey)
+	}
+
+	opts := app.Opts{
+		NanitCredentials: app.NanitCredentials{
+			Email:        cfg.Nanit.Email,
+			Password:     cfg.Nanit.Password,
+			RefreshToken: cfg.Nanit.RefreshToken,
+		},
+		SessionFile:     cfg.SessionFile,
+		SessionKey:      sessionKey,
+		DataDirectories: dataDirs,
+		HTTPEnabled:     cfg.HTTP.Enabled,
+		EventPolling: app.EventPollingOpts{
+			Enabled:         cfg.Events.Polling,
+			PollingInterval: cfg.Events.PollingInterval.Duration(),
+			PollingJitter:   cfg.Events.PollingJitter.Duration(),
+			MessageTimeout:  cfg.Events.MessageTimeout.Duration(),
+			AutoMarkSeen:    cfg.Events.AutoMarkSeen,
+		},
+		Health: app.HealthOpts{
+			WebsocketGracePeriod: cfg.Health.WebsocketGracePeriod.Duration(),
+			StreamGracePeriod:    cfg.Health.StreamGracePeriod.Duration(),
+			EventPollMaxAge:      cfg.Health.EventPollMaxAge.Duration(),
+		},
+		BabiesRefreshInterval: cfg.BabiesRefreshInterval.Duration(),
+		AdminToken:            cfg.AdminToken,
+		Babies:                make(map[string]app.BabyOpts),
+	}
+
+	// Allow a few failed polls by default
+	if opts.Health.EventPollMaxAge == 0 {
+		opts.Health.EventPollMaxAge = 5 * opts.EventPolling.PollingInterval
+	}
+
+	if cfg.Staleness.Sensors > 0 || len(cfg.Staleness.Fields) > 0 {
+		opts.Staleness = make(baby.StalenessOpts)
+		for _, field := range []string{"temperature", "humidity", "is_night"} {
+			opts.Staleness[field] = cfg.Staleness.Sensors.Duration()
+		}
+
+		for field, ttl := range cfg.Staleness.Fields {
+			opts.Staleness[field] = ttl.Duration()
+		}
+	}
+
+	if cfg.WebsocketCapture {
+		opts.WebsocketCaptureDir = filepath.Join(dataDirs.BaseDir, "captures")
+	}
+
+	if cfg.RTMP.Enabled {
+		opts.RTMP = &app.RTMPOpts{
+			ListenAddr: portRX.FindStringSubmatch(cfg.RTMP.Addr)[1],
+			PublicAddr: cfg.RTMP.Addr,
+		}
+	}
+
+	if cfg.MQTT.Enabled {
+		opts.MQTT = &mqtt.Opts{
+			BrokerURL:         cfg.MQTT.BrokerURL,
+			ClientID:          cfg.MQTT.ClientID,
+			Username:          cfg.MQTT.Username,
+			Password:          cfg.MQTT.Password,
+			TopicPrefix:       cfg.MQTT.Prefix,
+			EventHoldTime:     cfg.MQTT.EventHoldTime.Duration(),
+			BabyTopicPrefixes: make(map[string]string),
+		}
+	}
+
+	if len(cfg.Webhooks.Hooks) > 0 {
+		opts.Webhooks = &webhook.Opts{
+			DeadLetterFile: filepath.Join(dataDirs.BaseDir, "webhooks-dead-letter.jsonl"),
+			Timeout:        cfg.Webhooks.Timeout.Duration(),
+			Retries: []time.Duration{
+				2 * time.Second,
+				10 * time.Second,
+				1 * time.Minute,
+				5 * time.Minute,
+			},
+		}
+
+		for _, hook := range cfg.Webhooks.Hooks {
+			opts.Webhooks.Hooks = append(opts.Webhooks.Hooks, webhook.Hook{
+				URL:        hook.URL,
+				Secret:     hook.Secret,
+				Babies:     hook.Babies,
+				Fields:     hook.Fields,
+				EventTypes: hook.Events,
+			})
+		}
+	}
+
+	if cfg.Influx.Enabled {
+		opts.Influx = &influx.Opts{
+			URL:           cfg.Influx.URL,
+			Token:         cfg.Influx.Token,
+			Measurement:   cfg.Influx.Measurement,
+			BatchSize:     cfg.Influx.BatchSize,
+			FlushInterval: cfg.Influx.FlushInterval.Duration(),
+			Timeout:       cfg.Influx.Timeout.Duration(),
+			Retries: []time.Duration{
+				1 * time.Second,
+				5 * time.Second,
+				30 * time.Second,
+			},
+			MaxBuffered: 10000,
+		}
+	}
+
+	if cfg.History.Enabled {
+		opts.History = &history.Opts{
+			Dir:       filepath.Join(dataDirs.BaseDir, "history"),
+			Retention: cfg.History.Retention.Duration(),
+		}
+	}
+
+	if cfg.Report.Enabled {
+		// Values are validated already
+		opts.Report = &report.Opts{
+			Dir:    filepath.Join(dataDirs.BaseDir, "reports"),
+			Babies: make(map[string]report.BabyOpts),
+		}
+
+		opts.Report.NightStart, _ = report.ParseClock(cfg.Report.NightStart)
+		opts.Report.NightEnd, _ = report.ParseClock(cfg.Report.NightEnd)
+		opts.Report.ComfortTemperature, _ = report.ParseBand(cfg.Report.ComfortTemperature)
+		opts.Report.ComfortHumidity, _ = report.ParseBand(cfg.Report.ComfortHumidity)
+	}
+
+	if cfg.Sleep.Enabled {
+		opts.Sleep = &sleep.Opts{
+			Dir:             filepath.Join(dataDirs.BaseDir, "sleep"),
+			FallAsleepAfter: cfg.Sleep.FallAsleepAfter.Duration(),
+			WakeEvents:      cfg.Sleep.WakeEvents,
+			WakeWindow:      cfg.Sleep.WakeWindow.Duration(),
+			NightOnly:       cfg.Sleep.NightOnly,
+		}
+	}
+
+	for babyUID, babyCfg := range cfg.Babies {
+		opts.Babies[babyUID] = app.BabyOpts{Streaming: babyCfg.Streaming}
+
+		if opts.MQTT != nil && babyCfg.MQTTPrefix != "" {
+			opts.MQTT.BabyTopicPrefixes[babyUID] = babyCfg.MQTTPrefix
+		}
+
+		if opts.Report != nil {
+			babyOpts := report.BabyOpts{}
+			if babyCfg.ComfortTemperature != "" {
+				band, _ := report.ParseBand(babyCfg.ComfortTemperature)
+				babyOpts.ComfortTemperature = &band
+			}
+
+			if babyCfg.ComfortHumidity != "" {
+				band, _ := report.ParseBand(babyCfg.ComfortHumidity)
+				babyOpts.ComfortHumidity = &band
+			}
+
+			opts.Report.Babies[babyUID] = babyOpts
+		}
+	}
+
+	return opts
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"reflect"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+)
+
+// Redacted - returns copy of the configuration which is safe to be logged (secrets and URL credentials are anonymized)
+func (cfg *Config) Redacted() Config {
+	redacted := *cfg
+	redactStruct(reflect.ValueOf(&redacted).Elem())
+
+	redacted.MQTT.BrokerURL = utils.AnonymizeURL(cfg.MQTT.BrokerURL)
+	redacted.Influx.URL = utils.AnonymizeURL(cfg.Influx.URL)
+
+	redacted.Webhooks.Hooks = make([]WebhookConfig, len(cfg.Webhooks.Hooks))
+	for i, hook := range cfg.Webhooks.Hooks {
+		redactStruct(reflect.ValueOf(&hook).Elem())
+		hook.URL = utils.AnonymizeURL(hook.URL)
+		redacted.Webhooks.Hooks[i] = hook
+	}
+
+	return redacted
+}
+
+func redactStruct(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		if field.Type.Kind() == reflect.Struct {
+			redactStruct(v.Field(i))
+		} else if field.Tag.Get("secret") == "true" && field.Type.Kind() == reflect.String {
+			v.Field(i).SetString(utils.AnonymizeToken(v.Field(i).String(), 0))
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Duration - accepts number of seconds (as the env variables always did) or Go duration (ie. 90s, 5m, 1h)
+type Duration time.Duration
+
+// ParseDuration - parses number of seconds or Go duration
+func ParseDuration(value string) (Duration, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return Duration(time.Duration(seconds) * time.Second), nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, expected number of seconds or duration like 90s, 5m", value)
+	}
+
+	return Duration(d), nil
+}
+
+// UnmarshalYAML - implements yaml.Unmarshaler
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	value, err := ParseDuration(node.Value)
+	if err != nil {
+		// Type errors are collected by the decoder, so that all problems are reported at once
+		return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %v: %v", node.Line, err)}}
+	}
+
+	*d = value
+	return nil
+}
+
+// Duration - returns standard duration
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
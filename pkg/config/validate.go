@@ -0,0 +1,202 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/report"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/rs/zerolog"
+)
+
+var portRX = regexp.MustCompile("(:[0-9]+)$")
+
+// Validate - checks the configuration, returns all problems found
+func (cfg *Config) Validate() []error {
+	var errs []error
+	fail := func(path string, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%v: %v", path, fmt.Sprintf(format, args...)))
+	}
+
+	nonNegative := func(path string, d Duration) {
+		if d < 0 {
+			fail(path, "must not be negative")
+		}
+	}
+
+	if level, _ := zerolog.ParseLevel(cfg.LogLevel); level == zerolog.NoLevel {
+		fail("log_level", "unknown log level %q (allowed values trace, debug, info, warn, error, fatal, panic)", cfg.LogLevel)
+	}
+
+	if cfg.DataDir == "" {
+		fail("data_dir", "must not be empty")
+	}
+
+	nonNegative("babies_refresh_interval", cfg.BabiesRefreshInterval)
+
+	if cfg.AdminToken != "" && len(cfg.AdminToken) < 16 {
+		fail("admin_token", "must be at least 16 characters long")
+	}
+
+	if cfg.RTMP.Enabled {
+		if cfg.RTMP.Addr == "" {
+			fail("rtmp.addr", "required when RTMP is enabled")
+		} else if !portRX.MatchString(cfg.RTMP.Addr) {
+			fail("rtmp.addr", "unable to parse port from %q", cfg.RTMP.Addr)
+		}
+	}
+
+	if cfg.MQTT.Enabled {
+		if cfg.MQTT.BrokerURL == "" {
+			fail("mqtt.broker_url", "required when MQTT is enabled")
+		} else if u, err := url.Parse(cfg.MQTT.BrokerURL); err != nil || u.Scheme == "" || u.Host == "" {
+			fail("mqtt.broker_url", "invalid URL %q, expected ie. tcp://host:1883", utils.AnonymizeURL(cfg.MQTT.BrokerURL))
+		}
+
+		validateTopicPrefix("mqtt.prefix", cfg.MQTT.Prefix, fail)
+		nonNegative("mqtt.event_hold_time", cfg.MQTT.EventHoldTime)
+	}
+
+	if cfg.Events.Polling {
+		if cfg.Events.PollingInterval <= 0 {
+			fail("events.polling_interval", "must be positive")
+		}
+
+		nonNegative("events.polling_jitter", cfg.Events.PollingJitter)
+		nonNegative("events.message_timeout", cfg.Events.MessageTimeout)
+	}
+
+	nonNegative("health.websocket_grace_period", cfg.Health.WebsocketGracePeriod)
+	nonNegative("health.stream_grace_period", cfg.Health.StreamGracePeriod)
+	nonNegative("health.event_poll_max_age", cfg.Health.EventPollMaxAge)
+	nonNegative("history.retention", cfg.History.Retention)
+	nonNegative("staleness.sensors", cfg.Staleness.Sensors)
+
+	if len(cfg.Staleness.Fields) > 0 {
+		knownFields := make(map[string]bool)
+		for _, field := range baby.FieldNames() {
+			knownFields[field] = true
+		}
+
+		fields := make([]string, 0, len(cfg.Staleness.Fields))
+		for field := range cfg.Staleness.Fields {
+			fields = append(fields, field)
+		}
+
+		sort.Strings(fields)
+
+		for _, field := range fields {
+			path := fmt.Sprintf("staleness.fields.%v", field)
+			if !knownFields[field] {
+				fail(path, "unknown state field (allowed values %v)", strings.Join(baby.FieldNames(), ", "))
+			}
+
+			nonNegative(path, cfg.Staleness.Fields[field])
+		}
+	}
+
+	if cfg.Report.Enabled {
+		if _, err := report.ParseClock(cfg.Report.NightStart); err != nil {
+			fail("report.night_start", "%v", err)
+		}
+
+		if _, err := report.ParseClock(cfg.Report.NightEnd); err != nil {
+			fail("report.night_end", "%v", err)
+		}
+
+		if _, err := report.ParseBand(cfg.Report.ComfortTemperature); err != nil {
+			fail("report.comfort_temperature", "%v", err)
+		}
+
+		if _, err := report.ParseBand(cfg.Report.ComfortHumidity); err != nil {
+			fail("report.comfort_humidity", "%v", err)
+		}
+	}
+
+	if cfg.Sleep.Enabled {
+		if cfg.Sleep.WakeEvents < 1 {
+			fail("sleep.wake_events", "must be at least 1")
+		}
+
+		nonNegative("sleep.fall_asleep_after", cfg.Sleep.FallAsleepAfter)
+		nonNegative("sleep.wake_window", cfg.Sleep.WakeWindow)
+	}
+
+	nonNegative("webhooks.timeout", cfg.Webhooks.Timeout)
+	for i, hook := range cfg.Webhooks.Hooks {
+		path := fmt.Sprintf("webhooks.hooks[%v].url", i)
+		if hook.URL == "" {
+			fail(path, "required")
+		} else if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			fail(path, "invalid URL %q, expected http(s)://...", utils.AnonymizeURL(hook.URL))
+		}
+	}
+
+	if cfg.Influx.Enabled {
+		if cfg.Influx.URL == "" {
+			fail("influx.url", "required when InfluxDB output is enabled")
+		} else if u, err := url.Parse(cfg.Influx.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			fail("influx.url", "invalid URL %q, expected http(s)://...", utils.AnonymizeURL(cfg.Influx.URL))
+		}
+
+		if cfg.Influx.Measurement == "" {
+			fail("influx.measurement", "must not be empty")
+		}
+
+		if cfg.Influx.BatchSize < 1 {
+			fail("influx.batch_size", "must be at least 1")
+		}
+
+		if cfg.Influx.FlushInterval <= 0 {
+			fail("influx.flush_interval", "must be positive")
+		}
+
+		nonNegative("influx.timeout", cfg.Influx.Timeout)
+	}
+
+	babyUIDs := make([]string, 0, len(cfg.Babies))
+	for babyUID := range cfg.Babies {
+		babyUIDs = append(babyUIDs, babyUID)
+	}
+
+	sort.Strings(babyUIDs)
+
+	for _, babyUID := range babyUIDs {
+		babyCfg := cfg.Babies[babyUID]
+		path := fmt.Sprintf("babies.%v", babyUID)
+
+		if !baby.IsValidBabyUID(babyUID) {
+			fail(path, "baby UID contains unsafe characters")
+		}
+
+		if babyCfg.MQTTPrefix != "" {
+			validateTopicPrefix(path+".mqtt_prefix", babyCfg.MQTTPrefix, fail)
+		}
+
+		if babyCfg.ComfortTemperature != "" {
+			if _, err := report.ParseBand(babyCfg.ComfortTemperature); err != nil {
+				fail(path+".comfort_temperature", "%v", err)
+			}
+		}
+
+		if babyCfg.ComfortHumidity != "" {
+			if _, err := report.ParseBand(babyCfg.ComfortHumidity); err != nil {
+				fail(path+".comfort_humidity", "%v", err)
+			}
+		}
+	}
+
+	return errs
+}
+
+func validateTopicPrefix(path string, prefix string, fail func(path string, format string, args ...interface{})) {
+	if prefix == "" {
+		fail(path, "must not be empty")
+	} else if strings.ContainsAny(prefix, "+#") || strings.HasSuffix(prefix, "/") {
+		fail(path, "invalid topic prefix %q (wildcards and trailing slash are not allowed)", prefix)
+	}
+}
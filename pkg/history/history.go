@@ -0,0 +1,331 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/rs/zerolog/log"
+)
+
+const dayLayout = "2006-01-02"
+
+// Sample - single recorded state delta
+type Sample struct {
+	Time   time.Time          `json:"time"`
+	Values map[string]float64 `json:"values"`
+}
+
+// Point - aggregated values of a field within a single bucket
+// Without downsampling, every sample forms its own bucket
+type Point struct {
+	Time  time.Time `json:"time"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	Count int       `json:"count"`
+}
+
+// Query - history query parameters
+type Query struct {
+	BabyUID string
+	Fields  []string
+	From    time.Time
+	To      time.Time
+
+	// Step - bucket size for downsampling (0 = raw samples)
+	Step time.Duration
+}
+
+type dayFile struct {
+	day  string
+	file *os.File
+}
+
+// Store - append-only store of state deltas
+// Samples are written as JSON lines into {Dir}/{babyUID}/{YYYY-MM-DD}.jsonl (UTC days)
+type Store struct {
+	Opts Opts
+
+	mu    sync.Mutex
+	files map[string]*dayFile
+}
+
+// NewStore - constructor
+func NewStore(opts Opts) *Store {
+	return &Store{
+		Opts:  opts,
+		files: make(map[string]*dayFile),
+	}
+}
+
+// Run - records state changes and prunes old samples until the context is cancelled
+func (store *Store) Run(manager *baby.StateManager, ctx utils.GracefulContext) {
+	unsubscribe := manager.SubscribeFields(func(babyUID string, state baby.State, fields map[string]baby.FieldValue) {
+		values := SampleValues(state)
+
+		// Stale values (ie. restored from the snapshot) were recorded when they were reported
+		for key := range values {
+			if fields[key].Stale {
+				delete(values, key)
+			}
+		}
+
+		if len(values) == 0 {
+			return
+		}
+
+		if err := store.Record(babyUID, time.Now(), values); err != nil {
+			log.Error().Err(err).Str("baby_uid", babyUID).Msg("Unable to record state history")
+		}
+	}, baby.SubscribeOpts{Name: "history"})
+
+	defer unsubscribe()
+	defer store.Close()
+
+	store.pruneAndLog(time.Now())
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			store.pruneAndLog(now)
+		}
+	}
+}
+
+// SampleValues - converts public state fields into numeric values (booleans are stored as 0 / 1)
+func SampleValues(state baby.State) map[string]float64 {
+	values := make(map[string]float64)
+
+	for key, value := range state.AsMap(false) {
+		switch v := value.(type) {
+		case float64:
+			values[key] = v
+		case int64:
+			values[key] = float64(v)
+		case bool:
+			if v {
+				values[key] = 1
+			} else {
+				values[key] = 0
+			}
+		}
+	}
+
+	return values
+}
+
+// Record - appends sample to the baby history
+func (store *Store) Record(babyUID string, t time.Time, values map[string]float64) error {
+	baby.EnsureValidBabyUID(babyUID)
+
+	line, err := json.Marshal(Sample{Time: t.UTC(), Values: values})
+	if err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	f, err := store.openDay(babyUID, t.UTC().Format(dayLayout))
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+func (store *Store) openDay(babyUID string, day string) (*os.File, error) {
+	if current, ok := store.files[babyUID]; ok {
+		if current.day == day {
+			return current.file, nil
+		}
+
+		current.file.Close()
+		delete(store.files, babyUID)
+	}
+
+	dir := filepath.Join(store.Opts.Dir, babyUID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, day+".jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	store.files[babyUID] = &dayFile{day: day, file: f}
+	return f, nil
+}
+
+// Close - closes all open files
+func (store *Store) Close() {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for babyUID, current := range store.files {
+		current.file.Close()
+		delete(store.files, babyUID)
+	}
+}
+
+// Query - reads samples of requested fields within the range and aggregates them into buckets
+// Returns points per field in chronological order
+func (store *Store) Query(q Query) (map[string][]Point, error) {
+	if !baby.IsValidBabyUID(q.BabyUID) {
+		return nil, fmt.Errorf("invalid baby UID")
+	}
+
+	if q.To.Before(q.From) {
+		return nil, fmt.Errorf("end of the range is before its start")
+	}
+
+	buckets := make(map[string]map[int64]*Point, len(q.Fields))
+	for _, field := range q.Fields {
+		buckets[field] = make(map[int64]*Point)
+	}
+
+	addValue := func(field string, t time.Time, value float64) {
+		var key int64
+		bucketTime := t
+		if q.Step > 0 {
+			key = int64(t.Sub(q.From) / q.Step)
+			bucketTime = q.From.Add(time.Duration(key) * q.Step)
+		} else {
+			key = t.UnixNano()
+		}
+
+		p, ok := buckets[field][key]
+		if !ok {
+			buckets[field][key] = &Point{Time: bucketTime, Min: value, Max: value, Avg: value, Count: 1}
+			return
+		}
+
+		if value < p.Min {
+			p.Min = value
+		}
+
+		if value > p.Max {
+			p.Max = value
+		}
+
+		p.Avg = (p.Avg*float64(p.Count) + value) / float64(p.Count+1)
+		p.Count++
+	}
+
+	for day := q.From.UTC().Truncate(24 * time.Hour); !day.After(q.To); day = day.Add(24 * time.Hour) {
+		err := store.readDay(q.BabyUID, day.Format(dayLayout), func(s Sample) {
+			if s.Time.Before(q.From) || s.Time.After(q.To) {
+				return
+			}
+
+			for _, field := range q.Fields {
+				if value, ok := s.Values[field]; ok {
+					addValue(field, s.Time, value)
+				}
+			}
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := make(map[string][]Point, len(buckets))
+	for field, fieldBuckets := range buckets {
+		points := make([]Point, 0, len(fieldBuckets))
+		for _, p := range fieldBuckets {
+			points = append(points, *p)
+		}
+
+		sort.Slice(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+		result[field] = points
+	}
+
+	return result, nil
+}
+
+func (store *Store) readDay(babyUID string, day string, handler func(s Sample)) error {
+	f, err := os.Open(filepath.Join(store.Opts.Dir, babyUID, day+".jsonl"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var s Sample
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			// Partially written line (ie. crash in the middle of a write), skip it
+			continue
+		}
+
+		handler(s)
+	}
+
+	return scanner.Err()
+}
+
+// Prune - removes day files which are completely outside of the retention period
+func (store *Store) Prune(now time.Time) (int, error) {
+	if store.Opts.Retention <= 0 {
+		return 0, nil
+	}
+
+	cutoff := now.UTC().Add(-store.Opts.Retention).Truncate(24 * time.Hour)
+
+	files, err := filepath.Glob(filepath.Join(store.Opts.Dir, "*", "*.jsonl"))
+	if err != nil {
+		return 0, err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	removed := 0
+	for _, filename := range files {
+		day, err := time.Parse(dayLayout, strings.TrimSuffix(filepath.Base(filename), ".jsonl"))
+		if err != nil || !day.Before(cutoff) {
+			continue
+		}
+
+		if current, ok := store.files[filepath.Base(filepath.Dir(filename))]; ok && current.file.Name() == filename {
+			continue
+		}
+
+		if err := os.Remove(filename); err != nil {
+			return removed, err
+		}
+
+		removed++
+	}
+
+	return removed, nil
+}
+
+func (store *Store) pruneAndLog(now time.Time) {
+	removed, err := store.Prune(now)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to prune state history")
+	} else if removed > 0 {
+		log.Info().Int("files", removed).Msg("Pruned old state history")
+	}
+}
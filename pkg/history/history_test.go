@@ -0,0 +1,71 @@
+package history_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/history"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryDownsamplesAcrossDays(t *testing.T) {
+	store := history.NewStore(history.Opts{Dir: t.TempDir()})
+	defer store.Close()
+
+	start := time.Date(2024, 1, 1, 23, 50, 0, 0, time.UTC)
+	for i, value := range []float64{20, 22, 21, 25} {
+		err := store.Record("b1", start.Add(time.Duration(i)*5*time.Minute), map[string]float64{"temperature": value, "humidity": 50})
+		assert.NoError(t, err)
+	}
+
+	series, err := store.Query(history.Query{
+		BabyUID: "b1",
+		Fields:  []string{"temperature"},
+		From:    start,
+		To:      start.Add(time.Hour),
+		Step:    10 * time.Minute,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []history.Point{
+		{Time: start, Min: 20, Max: 22, Avg: 21, Count: 2},
+		{Time: start.Add(10 * time.Minute), Min: 21, Max: 25, Avg: 23, Count: 2},
+	}, series["temperature"])
+
+	raw, err := store.Query(history.Query{BabyUID: "b1", Fields: []string{"humidity"}, From: start.Add(time.Minute), To: start.Add(time.Hour)})
+	assert.NoError(t, err)
+	assert.Len(t, raw["humidity"], 3)
+}
+
+func TestQueryRejectsUnsafeBabyUID(t *testing.T) {
+	store := history.NewStore(history.Opts{Dir: t.TempDir()})
+
+	_, err := store.Query(history.Query{BabyUID: "../b1", Fields: []string{"temperature"}})
+	assert.Error(t, err)
+}
+
+func TestPruneRemovesOldDays(t *testing.T) {
+	dir := t.TempDir()
+	store := history.NewStore(history.Opts{Dir: dir, Retention: 48 * time.Hour})
+	defer store.Close()
+
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	store.Record("b1", now.Add(-5*24*time.Hour), map[string]float64{"temperature": 20})
+	store.Record("b1", now, map[string]float64{"temperature": 21})
+
+	removed, err := store.Prune(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	entries, _ := os.ReadDir(filepath.Join(dir, "b1"))
+	assert.Len(t, entries, 1)
+}
+
+func TestSampleValues(t *testing.T) {
+	state := baby.NewState().SetTemperatureMilli(21500).SetIsNight(true).SetWebsocketAlive(true)
+
+	assert.Equal(t, map[string]float64{"temperature": 21.5, "is_night": 1}, history.SampleValues(*state))
+}
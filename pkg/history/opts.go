@@ -0,0 +1,12 @@
+package history
+
+import "time"
+
+// Opts - history store configuration
+type Opts struct {
+	// Dir - directory where the samples are stored (one subdirectory per baby, one file per day)
+	Dir string
+
+	// Retention - how long are the samples kept (0 = forever)
+	Retention time.Duration
+}
@@ -0,0 +1,248 @@
+package influx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/metrics"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// sinkName - identifies the sink in metrics and logs
+const sinkName = "influx"
+
+// statusError - the database rejected the write
+type statusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (err *statusError) Error() string {
+	return fmt.Sprintf("unexpected status code %v: %v", err.StatusCode, err.Message)
+}
+
+// isRetryable - rejected points (ie. conflicting field types) would be rejected again
+func isRetryable(err error) bool {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	return true
+}
+
+// Sink - writes state changes and events to InfluxDB (or compatible database) using the line protocol
+type Sink struct {
+	Opts   Opts
+	client *http.Client
+
+	mu     sync.Mutex
+	lines  []string
+	flushC chan struct{}
+}
+
+var _ baby.Sink = (*Sink)(nil)
+
+// NewSink - constructor
+func NewSink(opts Opts) *Sink {
+	return &Sink{
+		Opts:   opts,
+		client: &http.Client{Timeout: opts.Timeout},
+		flushC: make(chan struct{}, 1),
+	}
+}
+
+// HandleState - collects the changed fields as a point of {measurement} tagged by baby_uid
+func (s *Sink) HandleState(babyUID string, state baby.State) {
+	fields := state.AsMap(false)
+	if state.StreamState != nil && *state.StreamState != baby.StreamState_Unknown {
+		fields["is_stream_alive"] = *state.StreamState == baby.StreamState_Alive
+	}
+
+	s.add(Point{
+		Measurement: s.Opts.Measurement,
+		Tags:        map[string]string{"baby_uid": babyUID},
+		Fields:      fields,
+		Time:        time.Now(),
+	})
+}
+
+// HandleEvent - collects the event as a point of {measurement}_events tagged by baby_uid and type
+func (s *Sink) HandleEvent(babyUID string, event baby.Event) {
+	fields := map[string]interface{}{"count": 1}
+	if event.MessageID != 0 {
+		fields["message_id"] = event.MessageID
+	}
+
+	s.add(Point{
+		Measurement: s.Opts.Measurement + "_events",
+		Tags:        map[string]string{"baby_uid": babyUID, "type": strings.ToLower(event.Type)},
+		Fields:      fields,
+		Time:        event.Time,
+	})
+}
+
+// add - buffers the point, full batch is written right away
+func (s *Sink) add(p Point) {
+	line, ok := p.Line()
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	s.lines = append(s.lines, line)
+	dropped := s.trim()
+	batchReady := len(s.lines) >= s.Opts.BatchSize
+	s.mu.Unlock()
+
+	if dropped > 0 {
+		metrics.SinkPointsDropped.Add(float64(dropped), sinkName)
+	}
+
+	if batchReady {
+		select {
+		case s.flushC <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// trim - drops the oldest points above the limit, must be called with the lock held
+func (s *Sink) trim() int {
+	if s.Opts.MaxBuffered <= 0 || len(s.lines) <= s.Opts.MaxBuffered {
+		return 0
+	}
+
+	dropped := len(s.lines) - s.Opts.MaxBuffered
+	s.lines = append([]string(nil), s.lines[dropped:]...)
+
+	log.Warn().Str("sink", sinkName).Int("dropped", dropped).Msg("Too many points waiting for write, dropping the oldest")
+	return dropped
+}
+
+// Run - feeds the sink with state changes and events and writes them in batches until the context is cancelled
+func (s *Sink) Run(manager *baby.StateManager, ctx utils.GracefulContext) {
+	unsubscribe := manager.SubscribeSink(s, baby.SubscribeOpts{Name: sinkName})
+
+	log.Info().Str("url", utils.AnonymizeURL(s.Opts.URL)).Msg("InfluxDB sink started")
+
+	ticker := time.NewTicker(s.Opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			unsubscribe()
+
+			// Last chance, the context is done so there are no retries
+			s.flush(ctx, true)
+			return
+		case <-ticker.C:
+			s.flush(ctx, false)
+		case <-s.flushC:
+			s.flush(ctx, false)
+		}
+	}
+}
+
+// flush - writes all buffered points in batches
+// Points which could not be written are kept for the next flush, unless it is the final one
+func (s *Sink) flush(ctx utils.GracefulContext, final bool) {
+	for {
+		s.mu.Lock()
+		n := len(s.lines)
+		if n > s.Opts.BatchSize {
+			n = s.Opts.BatchSize
+		}
+
+		batch := s.lines[:n:n]
+		s.lines = s.lines[n:]
+		s.mu.Unlock()
+
+		if len(batch) == 0 {
+			return
+		}
+
+		err := s.writeWithRetries(batch, ctx)
+		if err == nil {
+			metrics.SinkPointsWritten.Add(float64(len(batch)), sinkName)
+			continue
+		}
+
+		metrics.SinkWriteFailures.Inc(sinkName)
+
+		if final || !isRetryable(err) {
+			log.Error().Str("url", utils.AnonymizeURL(s.Opts.URL)).Err(err).Int("points", len(batch)).Msg("Unable to write points, dropping them")
+			metrics.SinkPointsDropped.Add(float64(len(batch)), sinkName)
+			continue
+		}
+
+		log.Warn().Str("url", utils.AnonymizeURL(s.Opts.URL)).Err(err).Int("points", len(batch)).Msg("Unable to write points, keeping them for the next flush")
+
+		// Put them back in front of the newer ones
+		s.mu.Lock()
+		s.lines = append(batch, s.lines...)
+		dropped := s.trim()
+		s.mu.Unlock()
+
+		if dropped > 0 {
+			metrics.SinkPointsDropped.Add(float64(dropped), sinkName)
+		}
+
+		return
+	}
+}
+
+// writeWithRetries - writes the batch, retrying with backoff until the context is cancelled
+func (s *Sink) writeWithRetries(batch []string, ctx utils.GracefulContext) error {
+	body := []byte(strings.Join(batch, "\n") + "\n")
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = s.write(body); err == nil || !isRetryable(err) || attempt >= len(s.Opts.Retries) {
+			return err
+		}
+
+		log.Debug().Str("url", utils.AnonymizeURL(s.Opts.URL)).Err(err).Int("attempt", attempt+1).Msg("Write of points failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(s.Opts.Retries[attempt]):
+		}
+	}
+}
+
+func (s *Sink) write(body []byte) error {
+	req, err := http.NewRequest("POST", s.Opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.Opts.Token != "" {
+		req.Header.Set("Authorization", "Token "+s.Opts.Token)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return &statusError{StatusCode: res.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package influx_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/influx"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPointLine(t *testing.T) {
+	line, ok := influx.Point{
+		Measurement: "nanit room",
+		Tags:        map[string]string{"baby_uid": "a,b=c", "empty": ""},
+		Fields: map[string]interface{}{
+			"temperature": 21.5,
+			"is_night":    true,
+			"timestamp":   int64(1700000000),
+			"note":        `say "hi" \o/`,
+		},
+		Time: time.Unix(1, 5),
+	}.Line()
+
+	assert.True(t, ok)
+	assert.Equal(t, `nanit\ room,baby_uid=a\,b\=c is_night=true,note="say \"hi\" \\o/",temperature=21.5,timestamp=1700000000i 1000000005`, line)
+
+	_, ok = influx.Point{Measurement: "nanit", Time: time.Now()}.Line()
+	assert.False(t, ok, "Point without fields")
+}
+
+// database - fake write endpoint, responds with the queued status codes (200 once they run out)
+type database struct {
+	mu       sync.Mutex
+	statuses []int
+	writes   []string
+	auth     string
+}
+
+func (db *database) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.auth = r.Header.Get("Authorization")
+
+	status := http.StatusNoContent
+	if len(db.statuses) > 0 {
+		status, db.statuses = db.statuses[0], db.statuses[1:]
+	}
+
+	if status == http.StatusNoContent {
+		db.writes = append(db.writes, string(body))
+	}
+
+	w.WriteHeader(status)
+}
+
+func (db *database) lines() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var lines []string
+	for _, write := range db.writes {
+		lines = append(lines, strings.Split(strings.TrimSpace(write), "\n")...)
+	}
+
+	return lines
+}
+
+func (db *database) numWrites() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return len(db.writes)
+}
+
+func newSink(t *testing.T, db *database, batchSize int) *influx.Sink {
+	server := httptest.NewServer(db)
+	t.Cleanup(server.Close)
+
+	return influx.NewSink(influx.Opts{
+		URL:           server.URL + "/api/v2/write?org=home&bucket=nanit",
+		Token:         "secret",
+		Measurement:   "nanit",
+		BatchSize:     batchSize,
+		FlushInterval: time.Hour,
+		Timeout:       time.Second,
+		Retries:       []time.Duration{10 * time.Millisecond, 10 * time.Millisecond},
+		MaxBuffered:   100,
+	})
+}
+
+func TestSinkBatching(t *testing.T) {
+	db := &database{}
+	sink := newSink(t, db, 2)
+	manager := baby.NewStateManager()
+
+	runner := utils.RunWithGracefulCancel(func(ctx utils.GracefulContext) {
+		sink.Run(manager, ctx)
+	})
+
+	sink.HandleState("b1", *baby.NewState().SetTemperatureMilli(21_500))
+	sink.HandleState("b1", *baby.NewState().SetIsNight(true))
+
+	// Full batch is written right away
+	assert.Eventually(t, func() bool { return db.numWrites() == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "Token secret", db.auth)
+
+	lines := db.lines()
+	assert.True(t, strings.HasPrefix(lines[0], "nanit,baby_uid=b1 temperature=21.5 "), lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "nanit,baby_uid=b1 is_night=true "), lines[1])
+
+	// The rest is written on shutdown
+	sink.HandleEvent("b1", baby.Event{Type: "MOTION", Time: time.Unix(1700000000, 0), MessageID: 7})
+	runner.Cancel()
+
+	lines = db.lines()
+	assert.Len(t, lines, 3)
+	assert.Equal(t, "nanit_events,baby_uid=b1,type=motion count=1i,message_id=7i 1700000000000000000", lines[2])
+}
+
+func TestSinkRetries(t *testing.T) {
+	db := &database{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	sink := newSink(t, db, 1)
+	manager := baby.NewStateManager()
+
+	runner := utils.RunWithGracefulCancel(func(ctx utils.GracefulContext) {
+		sink.Run(manager, ctx)
+	})
+	defer runner.Cancel()
+
+	sink.HandleState("b1", *baby.NewState().SetTemperatureMilli(21_500))
+
+	assert.Eventually(t, func() bool { return db.numWrites() == 1 }, time.Second, 10*time.Millisecond)
+	assert.Len(t, db.lines(), 1)
+}
+
+func TestSinkDropsRejectedPoints(t *testing.T) {
+	db := &database{statuses: []int{http.StatusBadRequest}}
+	sink := newSink(t, db, 1)
+	manager := baby.NewStateManager()
+
+	runner := utils.RunWithGracefulCancel(func(ctx utils.GracefulContext) {
+		sink.Run(manager, ctx)
+	})
+	defer runner.Cancel()
+
+	sink.HandleState("b1", *baby.NewState().SetTemperatureMilli(21_500))
+	time.Sleep(50 * time.Millisecond)
+	sink.HandleState("b1", *baby.NewState().SetTemperatureMilli(22_000))
+
+	// Rejected point is not retried, following ones are written
+	assert.Eventually(t, func() bool { return db.numWrites() == 1 }, time.Second, 10*time.Millisecond)
+	assert.Contains(t, db.lines()[0], "temperature=22 ")
+}
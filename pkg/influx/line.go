@@ -0,0 +1,101 @@
+package influx
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Point - single point of the line protocol
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringEscaper      = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+// Line - encodes the point as a line of the line protocol (without the trailing newline)
+// Points without fields cannot be written, false is returned for them
+func (p Point) Line() (string, bool) {
+	if len(p.Fields) == 0 {
+		return "", false
+	}
+
+	var b strings.Builder
+	b.WriteString(measurementEscaper.Replace(p.Measurement))
+
+	for _, key := range sortedKeys(p.Tags) {
+		// Empty tag values are not allowed
+		if p.Tags[key] == "" {
+			continue
+		}
+
+		b.WriteString(",")
+		b.WriteString(tagEscaper.Replace(key))
+		b.WriteString("=")
+		b.WriteString(tagEscaper.Replace(p.Tags[key]))
+	}
+
+	fieldKeys := make([]string, 0, len(p.Fields))
+	for key := range p.Fields {
+		fieldKeys = append(fieldKeys, key)
+	}
+
+	sort.Strings(fieldKeys)
+
+	for i, key := range fieldKeys {
+		if i == 0 {
+			b.WriteString(" ")
+		} else {
+			b.WriteString(",")
+		}
+
+		b.WriteString(tagEscaper.Replace(key))
+		b.WriteString("=")
+		b.WriteString(formatFieldValue(p.Fields[key]))
+	}
+
+	b.WriteString(" ")
+	b.WriteString(strconv.FormatInt(p.Time.UnixNano(), 10))
+
+	return b.String(), true
+}
+
+func formatFieldValue(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case int:
+		return strconv.FormatInt(int64(v), 10) + "i"
+	case int32:
+		return strconv.FormatInt(int64(v), 10) + "i"
+	case int64:
+		return strconv.FormatInt(v, 10) + "i"
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return `"` + stringEscaper.Replace(v) + `"`
+	default:
+		return `"` + stringEscaper.Replace(fmt.Sprintf("%v", v)) + `"`
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
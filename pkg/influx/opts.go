@@ -0,0 +1,31 @@
+package influx
+
+import "time"
+
+// Opts - line protocol sink configuration
+type Opts struct {
+	// URL - write endpoint, ie. http://influxdb:8086/api/v2/write?org=home&bucket=nanit (InfluxDB 2),
+	// http://influxdb:8086/write?db=nanit (InfluxDB 1) or http://victoriametrics:8428/write
+	URL string
+
+	// Token - optional API token sent as Authorization: Token {token}
+	Token string
+
+	// Measurement - measurement of the state fields, events are written to {measurement}_events
+	Measurement string
+
+	// BatchSize - maximum number of points written by a single request, reaching it triggers the write
+	BatchSize int
+
+	// FlushInterval - how often are the collected points written
+	FlushInterval time.Duration
+
+	// Timeout - timeout of a single write request
+	Timeout time.Duration
+
+	// Retries - cooldowns between write attempts, points are kept for the next flush once they are exhausted
+	Retries []time.Duration
+
+	// MaxBuffered - maximum number of points kept while the database is unreachable, the oldest are dropped
+	MaxBuffered int
+}
@@ -0,0 +1,23 @@
+package message
+
+// Action - state change which can be applied to a message
+type Action string
+
+const (
+	// SeenAction marks message as seen (ie. notification displayed)
+	SeenAction Action = "seen"
+	// ReadAction marks message as read
+	ReadAction Action = "read"
+	// DismissedAction dismisses the message
+	DismissedAction Action = "dismissed"
+)
+
+// IsValid - returns true for known actions
+func (a Action) IsValid() bool {
+	return a == SeenAction || a == ReadAction || a == DismissedAction
+}
+
+// Field - returns name of the message timestamp field updated by the action
+func (a Action) Field() string {
+	return string(a) + "_at"
+}
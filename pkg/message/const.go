@@ -1,5 +1,7 @@
 package message
 
+// Note: only SOUND, MOTION and TEMPERATURE have been observed in the Nanit API, the other types are not verified
+// (names are guessed from the app), messages of the real types end up as UnknownEventData carrying the raw type
 const (
 	// SoundEventMessageType is for working with sound event messages
 	SoundEventMessageType = "SOUND"
@@ -7,4 +9,12 @@ const (
 	MotionEventMessageType = "MOTION"
 	// TemperatureEventMessageType is for working with temperature event messages
 	TemperatureEventMessageType = "TEMPERATURE"
+	// HumidityEventMessageType is for working with humidity event messages (not verified)
+	HumidityEventMessageType = "HUMIDITY"
+	// CameraOfflineEventMessageType is for working with camera offline event messages (not verified)
+	CameraOfflineEventMessageType = "CAMERA_OFFLINE"
+	// BreathingEventMessageType is for working with breathing (motion monitoring) event messages (not verified)
+	BreathingEventMessageType = "BREATHING"
+	// StandingEventMessageType is for working with baby standing event messages (not verified)
+	StandingEventMessageType = "STANDING"
 )
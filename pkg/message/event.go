@@ -0,0 +1,14 @@
+package message
+
+import "encoding/json"
+
+// Event - event info (matching the Nanit API /babies/{baby_uid}/events/{event_uid})
+type Event struct {
+	UID       string          `json:"uid"`
+	BabyUID   string          `json:"baby_uid"`
+	Key       string          `json:"key"`
+	Time      UnixTime        `json:"time"`
+	CreatedAt ISOTime         `json:"created_at"`
+	UpdatedAt ISOTime         `json:"updated_at"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
@@ -0,0 +1,59 @@
+package message
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// ISOTime - ISO8601 timestamp as used by the Nanit API
+type ISOTime time.Time
+
+var isoTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+}
+
+// MarshalJSON is used to convert the timestamp to JSON
+func (t ISOTime) MarshalJSON() ([]byte, error) {
+	if time.Time(t).IsZero() {
+		return []byte("null"), nil
+	}
+
+	return time.Time(t).UTC().MarshalJSON()
+}
+
+// UnmarshalJSON is used to convert the timestamp from JSON
+func (t *ISOTime) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) || bytes.Equal(data, []byte(`""`)) {
+		*t = ISOTime{}
+		return nil
+	}
+
+	str := string(bytes.Trim(data, `"`))
+	for _, layout := range isoTimeLayouts {
+		if parsed, err := time.Parse(layout, str); err == nil {
+			*t = ISOTime(parsed)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unable to parse ISO8601 timestamp %v", string(data))
+}
+
+// Time returns the timestamp as a time.Time instance in UTC
+func (t ISOTime) Time() time.Time {
+	return time.Time(t).UTC()
+}
+
+// IsZero reports whether the timestamp is unset
+func (t ISOTime) IsZero() bool {
+	return time.Time(t).IsZero()
+}
+
+// String returns t as a formatted string
+func (t ISOTime) String() string {
+	return t.Time().String()
+}
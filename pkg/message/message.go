@@ -1,21 +1,69 @@
 package message
 
+import "encoding/json"
+
 // Message - message info (matching the Nanit API)
 type Message struct {
-	// TODO: Determin format for ReadAt, SeenAt, and DismissedAt
-	// TODO: unmarshall UpdatedAt and CreatedAt ISO8601 timestamp into time.Time
-	// TODO: enumerate possible Data interface structures
-	Id          int         `json:"id"`
-	BabyUid     string      `json:"baby_uid"`
-	UserId      int         `json:"user_id"`
-	Type        string      `json:"type"`
-	Time        UnixTime    `json:"time"`
-	ReadAt      string      `json:"read_at"`
-	SeenAt      string      `json:"seen_at"`
-	DismissedAt string      `json:"dismissed_at"`
-	UpdatedAt   string      `json:"updated_at"`
-	CreatedAt   string      `json:"created_at"`
-	Data        interface{} `json:"data"`
+	Id          int             `json:"id"`
+	BabyUid     string          `json:"baby_uid"`
+	UserId      int             `json:"user_id"`
+	Type        string          `json:"type"`
+	Time        UnixTime        `json:"time"`
+	ReadAt      *ISOTime        `json:"read_at"`
+	SeenAt      *ISOTime        `json:"seen_at"`
+	DismissedAt *ISOTime        `json:"dismissed_at"`
+	UpdatedAt   ISOTime         `json:"updated_at"`
+	CreatedAt   ISOTime         `json:"created_at"`
+	Data        json.RawMessage `json:"data"`
+
+	// Event - event details, if they were fetched (see Payload.GetEventUID)
+	Event *Event `json:"-"`
+}
+
+// Payload - parses type specific data of the message
+// Unknown message types are returned as UnknownEventData, so are the payloads which could not be parsed (together with
+// the error)
+func (m *Message) Payload() (Payload, error) {
+	var payload Payload
+
+	switch m.Type {
+	case SoundEventMessageType:
+		payload = &SoundEventData{}
+	case MotionEventMessageType:
+		payload = &MotionEventData{}
+	case TemperatureEventMessageType:
+		payload = &TemperatureEventData{}
+	case HumidityEventMessageType:
+		payload = &HumidityEventData{}
+	case CameraOfflineEventMessageType:
+		payload = &CameraOfflineEventData{}
+	case BreathingEventMessageType:
+		payload = &BreathingEventData{}
+	case StandingEventMessageType:
+		payload = &StandingEventData{}
+	default:
+		payload = m.unknownPayload()
+	}
+
+	if len(m.Data) == 0 || string(m.Data) == "null" {
+		return payload, nil
+	}
+
+	if err := json.Unmarshal(m.Data, payload); err != nil {
+		return m.unknownPayload(), err
+	}
+
+	return payload, nil
+}
+
+// unknownPayload - generic payload with the raw type and data, event UID is filled in if it can be found
+func (m *Message) unknownPayload() *UnknownEventData {
+	payload := &UnknownEventData{Type: m.Type, Data: m.Data}
+	if len(m.Data) > 0 {
+		json.Unmarshal(m.Data, &payload.EventRef)
+	}
+
+	return payload
 }
 
 // FilterMessages allows a slice (?) of Messages to be filtered by an aribitrary function that returns true or false for each element, indicating whether it should be included in the filtered set or not
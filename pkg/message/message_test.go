@@ -0,0 +1,71 @@
+package message_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageUnmarshal(t *testing.T) {
+	raw := `{
+		"id": 12,
+		"type": "TEMPERATURE",
+		"time": 1700000000,
+		"read_at": null,
+		"seen_at": "2023-11-14T22:14:00.123Z",
+		"created_at": "2023-11-14T22:13:20Z",
+		"updated_at": "2023-11-14T22:14:00Z",
+		"data": {"event_uid": "ev1", "value": 27.5, "high": 25}
+	}`
+
+	m := message.Message{}
+	assert.NoError(t, json.Unmarshal([]byte(raw), &m))
+
+	assert.Nil(t, m.ReadAt)
+	assert.NotNil(t, m.SeenAt)
+	assert.Equal(t, int64(1700000000), m.CreatedAt.Time().Unix())
+
+	payload, err := m.Payload()
+	assert.NoError(t, err)
+	assert.Equal(t, "ev1", payload.GetEventUID())
+
+	temperature, ok := payload.(*message.TemperatureEventData)
+	assert.True(t, ok)
+	assert.Equal(t, 27.5, *temperature.Value)
+	assert.Equal(t, 25.0, *temperature.High)
+	assert.Nil(t, temperature.Low)
+}
+
+func TestMessageUnknownPayload(t *testing.T) {
+	m := message.Message{Type: "SOMETHING_NEW"}
+
+	payload, err := m.Payload()
+	assert.NoError(t, err)
+	assert.IsType(t, &message.UnknownEventData{}, payload)
+	assert.Equal(t, "", payload.GetEventUID())
+
+	m.Data = json.RawMessage(`{"event_uid": "ev2", "level": 3}`)
+	payload, err = m.Payload()
+	assert.NoError(t, err)
+	assert.Equal(t, "ev2", payload.GetEventUID())
+
+	unknown := payload.(*message.UnknownEventData)
+	assert.Equal(t, "SOMETHING_NEW", unknown.Type)
+	assert.JSONEq(t, `{"event_uid": "ev2", "level": 3}`, string(unknown.Data))
+}
+
+func TestMessageUnparsedPayload(t *testing.T) {
+	m := message.Message{Type: message.TemperatureEventMessageType, Data: json.RawMessage(`{"event_uid": "ev3", "value": "hot"}`)}
+
+	payload, err := m.Payload()
+	assert.Error(t, err)
+
+	// Falls back to the generic payload, so that nothing is lost
+	unknown, ok := payload.(*message.UnknownEventData)
+	assert.True(t, ok)
+	assert.Equal(t, message.TemperatureEventMessageType, unknown.Type)
+	assert.Equal(t, "ev3", unknown.GetEventUID())
+	assert.JSONEq(t, `{"event_uid": "ev3", "value": "hot"}`, string(unknown.Data))
+}
@@ -0,0 +1,76 @@
+package message
+
+import "encoding/json"
+
+// Payload - type specific data of a message
+type Payload interface {
+	// GetEventUID - returns UID of the related event (empty if there is none)
+	GetEventUID() string
+}
+
+// EventRef - reference to the event, shared by the majority of payloads
+type EventRef struct {
+	EventUID string `json:"event_uid,omitempty"`
+}
+
+// GetEventUID - returns UID of the related event
+func (ref *EventRef) GetEventUID() string {
+	return ref.EventUID
+}
+
+// SoundEventData - payload of SOUND message
+type SoundEventData struct {
+	EventRef
+}
+
+// MotionEventData - payload of MOTION message
+type MotionEventData struct {
+	EventRef
+}
+
+// ThresholdEventData - payload shared by sensor threshold alerts
+// Note: the fields besides event_uid are not verified against the Nanit API, they stay nil if it sends something else
+// (the raw data are always kept in Message.Data)
+type ThresholdEventData struct {
+	EventRef
+	// Value - measured value which triggered the alert (not verified)
+	Value *float64 `json:"value,omitempty"`
+	// Low / High - configured comfort range (not verified)
+	Low  *float64 `json:"low,omitempty"`
+	High *float64 `json:"high,omitempty"`
+}
+
+// TemperatureEventData - payload of TEMPERATURE message
+type TemperatureEventData struct {
+	ThresholdEventData
+}
+
+// HumidityEventData - payload of HUMIDITY message (type not verified)
+type HumidityEventData struct {
+	ThresholdEventData
+}
+
+// CameraOfflineEventData - payload of CAMERA_OFFLINE message (type not verified)
+type CameraOfflineEventData struct {
+	EventRef
+	// CameraUID - camera which went offline (not verified)
+	CameraUID string `json:"camera_uid,omitempty"`
+}
+
+// BreathingEventData - payload of BREATHING message (type not verified)
+type BreathingEventData struct {
+	EventRef
+}
+
+// StandingEventData - payload of STANDING message (type not verified)
+type StandingEventData struct {
+	EventRef
+}
+
+// UnknownEventData - generic payload of message types we don't know about yet and of payloads which could not be parsed
+// Nothing is dropped, the raw type and data are kept for the consumers
+type UnknownEventData struct {
+	EventRef
+	Type string          `json:"-"`
+	Data json.RawMessage `json:"-"`
+}
@@ -0,0 +1,225 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Minimal implementation of Prometheus text exposition format
+// @see https://prometheus.io/docs/instrumenting/exposition_formats/
+
+type metric interface {
+	write(w *Writer)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   []metric
+)
+
+func register(m metric) {
+	registryMu.Lock()
+	registry = append(registry, m)
+	registryMu.Unlock()
+}
+
+// Writer - helper for writing metric families
+type Writer struct {
+	out io.Writer
+}
+
+// Header - writes HELP and TYPE lines of a metric family
+func (w *Writer) Header(name string, help string, metricType string) {
+	fmt.Fprintf(w.out, "# HELP %v %v\n", name, strings.ReplaceAll(help, "\n", " "))
+	fmt.Fprintf(w.out, "# TYPE %v %v\n", name, metricType)
+}
+
+// Sample - writes single sample, labels are given as name, value pairs
+func (w *Writer) Sample(name string, value float64, labels ...string) {
+	fmt.Fprintf(w.out, "%v%v %v\n", name, formatLabels(labels), formatValue(value))
+}
+
+// Handler - returns HTTP handler exposing all registered metrics followed by the given collectors
+func Handler(collectors ...func(w *Writer)) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w := &Writer{out: res}
+
+		registryMu.RLock()
+		metrics := make([]metric, len(registry))
+		copy(metrics, registry)
+		registryMu.RUnlock()
+
+		for _, m := range metrics {
+			m.write(w)
+		}
+
+		for _, collect := range collectors {
+			collect(w)
+		}
+	})
+}
+
+// -----------------------------
+
+type vec struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+
+	mu     sync.RWMutex
+	values map[string]float64
+	labels map[string][]string
+}
+
+func newVec(name string, help string, metricType string, labelNames []string) *vec {
+	return &vec{
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labelNames: labelNames,
+		values:     make(map[string]float64),
+		labels:     make(map[string][]string),
+	}
+}
+
+func (v *vec) add(delta float64, labelValues []string) {
+	key := v.key(labelValues)
+
+	v.mu.Lock()
+	v.values[key] += delta
+	v.mu.Unlock()
+}
+
+func (v *vec) set(value float64, labelValues []string) {
+	key := v.key(labelValues)
+
+	v.mu.Lock()
+	v.values[key] = value
+	v.mu.Unlock()
+}
+
+func (v *vec) delete(labelValues []string) {
+	key := v.key(labelValues)
+
+	v.mu.Lock()
+	delete(v.values, key)
+	delete(v.labels, key)
+	v.mu.Unlock()
+}
+
+func (v *vec) key(labelValues []string) string {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metric %v expects %v label values, got %v", v.name, len(v.labelNames), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.Lock()
+	if _, ok := v.labels[key]; !ok {
+		pairs := make([]string, 0, 2*len(labelValues))
+		for i, name := range v.labelNames {
+			pairs = append(pairs, name, labelValues[i])
+		}
+		v.labels[key] = pairs
+	}
+	v.mu.Unlock()
+
+	return key
+}
+
+func (v *vec) write(w *Writer) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	w.Header(v.name, v.help, v.metricType)
+
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		w.Sample(v.name, v.values[key], v.labels[key]...)
+	}
+}
+
+// CounterVec - monotonically increasing value partitioned by labels
+type CounterVec struct{ v *vec }
+
+// NewCounterVec - creates and registers new counter
+func NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, "counter", labelNames)}
+	register(c.v)
+	return c
+}
+
+// Inc - increments the counter by 1
+func (c *CounterVec) Inc(labelValues ...string) { c.v.add(1, labelValues) }
+
+// Add - increments the counter by given (non-negative) value
+func (c *CounterVec) Add(delta float64, labelValues ...string) { c.v.add(delta, labelValues) }
+
+// GaugeVec - arbitrary value partitioned by labels
+type GaugeVec struct{ v *vec }
+
+// NewGaugeVec - creates and registers new gauge
+func NewGaugeVec(name string, help string, labelNames ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, "gauge", labelNames)}
+	register(g.v)
+	return g
+}
+
+// Set - sets the gauge value
+func (g *GaugeVec) Set(value float64, labelValues ...string) { g.v.set(value, labelValues) }
+
+// Add - adds given (possibly negative) value to the gauge
+func (g *GaugeVec) Add(delta float64, labelValues ...string) { g.v.add(delta, labelValues) }
+
+// Delete - removes the gauge for given labels
+func (g *GaugeVec) Delete(labelValues ...string) { g.v.delete(labelValues) }
+
+// -----------------------------
+
+func formatLabels(pairs []string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf("%v=%v", pairs[i], strconv.Quote(pairs[i+1])))
+	}
+
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// BoolValue - converts boolean to gauge value
+func BoolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,33 @@
+package metrics_test
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerExposition(t *testing.T) {
+	counter := metrics.NewCounterVec("test_requests_total", "Test counter", "kind")
+	counter.Inc("a")
+	counter.Add(2, "a")
+	counter.Inc(`b"c`)
+
+	gauge := metrics.NewGaugeVec("test_value", "Test gauge")
+	gauge.Set(1.5)
+
+	res := httptest.NewRecorder()
+	metrics.Handler(func(w *metrics.Writer) {
+		w.Header("test_collected", "Collected gauge", "gauge")
+		w.Sample("test_collected", 1, "baby_uid", "x")
+	}).ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, _ := io.ReadAll(res.Body)
+	assert.Contains(t, string(body), "# TYPE test_requests_total counter\n")
+	assert.Contains(t, string(body), "test_requests_total{kind=\"a\"} 3\n")
+	assert.Contains(t, string(body), "test_requests_total{kind=\"b\\\"c\"} 1\n")
+	assert.Contains(t, string(body), "test_value 1.5\n")
+	assert.Contains(t, string(body), "test_collected{baby_uid=\"x\"} 1\n")
+}
@@ -0,0 +1,56 @@
+package metrics
+
+// Metrics shared across the packages
+
+var (
+	// WebsocketReconnects - websocket connection attempts after the initial one
+	WebsocketReconnects = NewCounterVec("nanit_websocket_reconnects_total", "Number of websocket reconnection attempts", "camera_uid")
+
+	// RequestTimeouts - websocket requests which did not receive response in time
+	RequestTimeouts = NewCounterVec("nanit_websocket_request_timeouts_total", "Number of websocket requests which timed out", "request_type")
+
+	// RTMPPublisherReconnects - stream publisher connections after the first one
+	RTMPPublisherReconnects = NewCounterVec("nanit_rtmp_publisher_reconnects_total", "Number of RTMP publisher reconnections", "baby_uid")
+
+	// RTMPSubscribers - currently connected stream subscribers
+	RTMPSubscribers = NewGaugeVec("nanit_rtmp_subscribers", "Number of connected RTMP subscribers", "baby_uid")
+
+	// RTMPPacketsRelayed - packets sent to stream subscribers
+	RTMPPacketsRelayed = NewCounterVec("nanit_rtmp_packets_relayed_total", "Number of RTMP packets relayed to subscribers", "baby_uid")
+
+	// RTMPBytesRelayed - payload bytes sent to stream subscribers
+	RTMPBytesRelayed = NewCounterVec("nanit_rtmp_bytes_relayed_total", "Number of RTMP payload bytes relayed to subscribers", "baby_uid")
+
+	// EventPollDuration - duration of the last successful event poll
+	EventPollDuration = NewGaugeVec("nanit_event_poll_duration_seconds", "Duration of the last successful event poll", "baby_uid")
+
+	// EventPollLastSuccess - time of the last successful event poll
+	EventPollLastSuccess = NewGaugeVec("nanit_event_poll_last_success_timestamp_seconds", "Unix time of the last successful event poll", "baby_uid")
+
+	// EventPollFailures - failed event polls
+	EventPollFailures = NewCounterVec("nanit_event_poll_failures_total", "Number of failed event polls", "baby_uid")
+
+	// EventMessageGaps - event polls which could not fetch all the messages since the cursor
+	EventMessageGaps = NewCounterVec("nanit_event_message_gaps_total", "Number of event polls which could not fetch all messages since the cursor", "baby_uid")
+
+	// StateSubscriberQueueLength - state updates and events waiting for delivery to the subscriber
+	StateSubscriberQueueLength = NewGaugeVec("nanit_state_subscriber_queue_length", "Number of state updates and events waiting for delivery to the subscriber", "subscriber")
+
+	// StateUpdatesDelivered - state updates delivered to the subscriber
+	StateUpdatesDelivered = NewCounterVec("nanit_state_updates_delivered_total", "Number of state updates delivered to the subscriber", "subscriber")
+
+	// StateUpdatesCoalesced - state updates merged into a pending update of the coalescing subscriber
+	StateUpdatesCoalesced = NewCounterVec("nanit_state_updates_coalesced_total", "Number of state updates merged into a pending update", "subscriber")
+
+	// SinkPointsWritten - points written by the sink
+	SinkPointsWritten = NewCounterVec("nanit_sink_points_written_total", "Number of points written by the sink", "sink")
+
+	// SinkPointsDropped - points which the sink gave up on
+	SinkPointsDropped = NewCounterVec("nanit_sink_points_dropped_total", "Number of points dropped by the sink", "sink")
+
+	// SinkWriteFailures - batches which could not be written (after all retries)
+	SinkWriteFailures = NewCounterVec("nanit_sink_write_failures_total", "Number of failed sink writes", "sink")
+
+	// MQTTPublishFailures - MQTT messages which could not be published
+	MQTTPublishFailures = NewCounterVec("nanit_mqtt_publish_failures_total", "Number of failed MQTT publishes")
+)
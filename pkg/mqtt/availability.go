@@ -0,0 +1,103 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// stalenessCheckInterval - how often availability of the state fields is re-evaluated
+const stalenessCheckInterval = 10 * time.Second
+
+// fieldAttributes - payload of {prefix}/babies/{baby_uid}/{key}/attributes
+type fieldAttributes struct {
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	ChangedAt *time.Time `json:"changed_at,omitempty"`
+	Stale     bool       `json:"stale"`
+}
+
+// publishAttributes - publishes timestamps of the given fields to {key}/attributes
+func (conn *Connection) publishAttributes(babyUID string, fields map[string]baby.FieldValue) {
+	for key, field := range fields {
+		payload, err := json.Marshal(fieldAttributes{
+			UpdatedAt: field.UpdatedAt,
+			ChangedAt: field.ChangedAt,
+			Stale:     field.Stale,
+		})
+
+		if err != nil {
+			log.Error().Err(err).Str("baby_uid", babyUID).Str("field", key).Msg("Unable to marshal field attributes")
+			continue
+		}
+
+		conn.publish(conn.babyTopic(babyUID, key+"/attributes"), false, payload)
+	}
+}
+
+// publishAvailability - publishes retained {key}/available (online / offline) of the fields whose staleness changed
+func (conn *Connection) publishAvailability(babyUID string, fields map[string]baby.FieldValue) {
+	conn.availabilityMu.Lock()
+	defer conn.availabilityMu.Unlock()
+
+	for key, field := range fields {
+		available := !field.Stale
+		availabilityKey := babyUID + "/" + key
+
+		if published, ok := conn.availability[availabilityKey]; ok && published == available {
+			continue
+		}
+
+		payload := "online"
+		if !available {
+			payload = "offline"
+			log.Debug().Str("baby_uid", babyUID).Str("field", key).Msg("State field is stale")
+		}
+
+		conn.publish(conn.babyTopic(babyUID, key+"/available"), true, payload)
+		conn.availability[availabilityKey] = available
+	}
+}
+
+// HandleRemoval - marks all fields of the removed baby offline and clears its retained night report
+func (conn *Connection) HandleRemoval(babyUID string) {
+	log.Info().Str("baby_uid", babyUID).Msg("Baby removed, clearing its retained MQTT topics")
+
+	conn.availabilityMu.Lock()
+	defer conn.availabilityMu.Unlock()
+
+	for _, key := range baby.FieldNames() {
+		conn.publish(conn.babyTopic(babyUID, key+"/available"), true, "offline")
+		delete(conn.availability, babyUID+"/"+key)
+	}
+
+	// Empty retained message deletes the retained one
+	conn.publish(conn.babyTopic(babyUID, "report"), true, "")
+}
+
+// resetAvailability - forgets published availability so that it is published again (ie. after reconnect)
+func (conn *Connection) resetAvailability() {
+	conn.availabilityMu.Lock()
+	conn.availability = make(map[string]bool)
+	conn.availabilityMu.Unlock()
+}
+
+// watchStaleness - fields go stale without any update, so their availability is checked periodically
+func (conn *Connection) watchStaleness(ctx utils.GracefulContext) {
+	ticker := time.NewTicker(stalenessCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for babyUID := range conn.StateManager.GetBabyStates() {
+			conn.publishAvailability(babyUID, conn.StateManager.GetBabyFields(babyUID))
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/message"
+	"github.com/indiefan/home_assistant_nanit/pkg/metrics"
+	"github.com/indiefan/home_assistant_nanit/pkg/report"
+	"github.com/rs/zerolog/log"
+)
+
+// detectionSensors - event types which are exposed as auto-resetting binary sensors
+var detectionSensors = map[string]string{
+	message.MotionEventMessageType: "motion_detected",
+	message.SoundEventMessageType:  "sound_detected",
+}
+
+type eventPayload struct {
+	baby.Event
+	// EventType - duplicate of the type, expected by Home Assistant MQTT event entity
+	EventType string `json:"event_type"`
+	BabyUID   string `json:"baby_uid"`
+}
+
+// HandleEvent - publishes the event to {prefix}/babies/{baby_uid}/events and switches the detection sensors
+func (conn *Connection) HandleEvent(babyUID string, event baby.Event) {
+	payload, err := json.Marshal(eventPayload{
+		Event:     event,
+		EventType: strings.ToLower(event.Type),
+		BabyUID:   babyUID,
+	})
+
+	if err != nil {
+		log.Error().Err(err).Str("baby_uid", babyUID).Msg("Unable to marshal event")
+		return
+	}
+
+	conn.publish(conn.babyTopic(babyUID, "events"), false, payload)
+
+	if key, ok := detectionSensors[event.Type]; ok {
+		conn.holdDetection(babyUID, key, event.Time)
+	}
+}
+
+// holdDetection - turns detection sensor on and schedules its reset after the hold time (counted from the event time)
+func (conn *Connection) holdDetection(babyUID string, key string, eventTime time.Time) {
+	remaining := time.Until(eventTime.Add(conn.GetOpts().EventHoldTime))
+	if remaining <= 0 {
+		log.Trace().Str("baby_uid", babyUID).Str("sensor", key).Msg("Event is older than hold time, not turning on detection sensor")
+		return
+	}
+
+	topic := conn.babyTopic(babyUID, key)
+	timerKey := babyUID + "/" + key
+
+	conn.detectionMu.Lock()
+	defer conn.detectionMu.Unlock()
+
+	// Sensor is still on, just prolong it
+	isOn := false
+	if timer, ok := conn.detectionTimers[timerKey]; ok {
+		isOn = timer.Stop()
+	}
+
+	if !isOn {
+		conn.publish(topic, false, "true")
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(remaining, func() {
+		conn.detectionMu.Lock()
+		if conn.detectionTimers[timerKey] != timer {
+			// Superseded by newer event
+			conn.detectionMu.Unlock()
+			return
+		}
+
+		delete(conn.detectionTimers, timerKey)
+		conn.detectionMu.Unlock()
+
+		conn.publish(topic, false, "false")
+	})
+
+	conn.detectionTimers[timerKey] = timer
+}
+
+// stopDetections - cancels pending resets and turns all active sensors off
+func (conn *Connection) stopDetections() {
+	conn.detectionMu.Lock()
+	defer conn.detectionMu.Unlock()
+
+	for timerKey, timer := range conn.detectionTimers {
+		if timer.Stop() {
+			parts := strings.SplitN(timerKey, "/", 2)
+			conn.publish(conn.babyTopic(parts[0], parts[1]), false, "false")
+		}
+	}
+
+	conn.detectionTimers = make(map[string]*time.Timer)
+}
+
+func (conn *Connection) publish(topic string, retained bool, payload interface{}) {
+	log.Trace().Str("topic", topic).Interface("value", payload).Msg("MQTT publish")
+
+	token := conn.getClient().Publish(topic, 0, retained, payload)
+	if token.Wait(); token.Error() != nil {
+		log.Error().Err(token.Error()).Str("topic", topic).Msg("Unable to publish")
+		metrics.MQTTPublishFailures.Inc()
+	}
+}
+
+// PublishReport - publishes retained night report to {prefix}/babies/{baby_uid}/report
+func (conn *Connection) PublishReport(r report.Report) {
+	if !conn.IsConnected() {
+		log.Warn().Str("baby_uid", r.BabyUID).Msg("MQTT is not connected, night report not published")
+		return
+	}
+
+	payload, err := json.Marshal(r)
+	if err != nil {
+		log.Error().Err(err).Str("baby_uid", r.BabyUID).Msg("Unable to marshal night report")
+		return
+	}
+
+	conn.publish(conn.babyTopic(r.BabyUID, "report"), true, payload)
+}
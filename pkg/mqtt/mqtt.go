@@ -1,47 +1,98 @@
 package mqtt
 
 import (
+	"encoding/json"
 	"fmt"
-	"strings"
+	"sync"
 	"time"
 
 	MQTT "github.com/eclipse/paho.mqtt.golang"
 	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/message"
 	"github.com/indiefan/home_assistant_nanit/pkg/utils"
 	"github.com/rs/zerolog/log"
 )
 
 type SendLightCommandHandler func(nightLightState bool)
 type SendStandbyCommandHandler func(standbyState bool)
+type RefreshBabiesHandler func()
+type MessageActionHandler func(babyUID string, action message.Action, messageIDs []int)
+
+// RawRequestHandler - handles raw camera request payload, returns payload of the reply
+type RawRequestHandler func(babyUID string, payload []byte) []byte
 
 // Connection - MQTT context
 type Connection struct {
-	Opts                      Opts
-	StateManager              *baby.StateManager
-	client                    MQTT.Client
-	sendLightCommandHandler   SendLightCommandHandler
-	sendStandbyCommandHandler SendStandbyCommandHandler
+	StateManager         *baby.StateManager
+	handlersMu           sync.RWMutex
+	lightHandlers        map[string]*SendLightCommandHandler
+	standbyHandlers      map[string]*SendStandbyCommandHandler
+	refreshBabiesHandler RefreshBabiesHandler
+	messageActionHandler MessageActionHandler
+	rawRequestHandler    RawRequestHandler
+	detectionMu          sync.Mutex
+	detectionTimers      map[string]*time.Timer
+	availabilityMu       sync.Mutex
+	availability         map[string]bool
+
+	// opts and client - replaced on reload while timers and other components might be using them
+	mu     sync.RWMutex
+	opts   Opts
+	client MQTT.Client
 }
 
+// Connection is fed by the state manager as a sink
+var _ baby.RemovalSink = (*Connection)(nil)
+
 // NewConnection - constructor
 func NewConnection(opts Opts) *Connection {
 	return &Connection{
-		Opts: opts,
+		opts:            opts,
+		lightHandlers:   make(map[string]*SendLightCommandHandler),
+		standbyHandlers: make(map[string]*SendStandbyCommandHandler),
+		detectionTimers: make(map[string]*time.Timer),
+		availability:    make(map[string]bool),
 	}
 }
 
+// GetOpts - returns current options in thread safe manner
+func (conn *Connection) GetOpts() Opts {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+
+	return conn.opts
+}
+
+// SetOpts - replaces the options, the broker connection ones are applied on the next Run
+func (conn *Connection) SetOpts(opts Opts) {
+	conn.mu.Lock()
+	conn.opts = opts
+	conn.mu.Unlock()
+}
+
+// getClient - returns client of the current run, nil if the connection has not been run yet
+func (conn *Connection) getClient() MQTT.Client {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+
+	return conn.client
+}
+
 // Run - runs the mqtt connection handler
 func (conn *Connection) Run(manager *baby.StateManager, ctx utils.GracefulContext) {
 	conn.StateManager = manager
 
+	connOpts := conn.GetOpts()
 	opts := MQTT.NewClientOptions()
-	opts.AddBroker(conn.Opts.BrokerURL)
-	opts.SetClientID(conn.Opts.TopicPrefix)
-	opts.SetUsername(conn.Opts.Username)
-	opts.SetPassword(conn.Opts.Password)
+	opts.AddBroker(connOpts.BrokerURL)
+	opts.SetClientID(connOpts.TopicPrefix)
+	opts.SetUsername(connOpts.Username)
+	opts.SetPassword(connOpts.Password)
 	opts.SetCleanSession(false)
 
+	conn.mu.Lock()
 	conn.client = MQTT.NewClient(opts)
+	conn.mu.Unlock()
 
 	utils.RunWithPerseverance(func(attempt utils.AttemptContext) {
 		runMqtt(conn, attempt)
@@ -56,29 +107,40 @@ func (conn *Connection) Run(manager *baby.StateManager, ctx utils.GracefulContex
 	})
 }
 
-func (conn *Connection) RegisterLightHandler(sendLightCommandHandler SendLightCommandHandler) {
-	conn.sendLightCommandHandler = sendLightCommandHandler
+// IsConnected - returns true if connection to the broker is open
+func (conn *Connection) IsConnected() bool {
+	client := conn.getClient()
+	return client != nil && client.IsConnectionOpen()
 }
 
-func (conn *Connection) subscribeToLightCommand() {
-	commandTopic := fmt.Sprintf("%v/babies/+/night_light/switch", conn.Opts.TopicPrefix)
-	log.Debug().
-		Str("topic", commandTopic).
-		Msg("Subscribing to command topic")
+// RegisterLightHandler - registers handler of light commands for given baby
+// Returns unregister function
+func (conn *Connection) RegisterLightHandler(babyUID string, sendLightCommandHandler SendLightCommandHandler) func() {
+	handler := &sendLightCommandHandler
+
+	conn.handlersMu.Lock()
+	conn.lightHandlers[babyUID] = handler
+	conn.handlersMu.Unlock()
 
+	return func() {
+		conn.handlersMu.Lock()
+		if conn.lightHandlers[babyUID] == handler {
+			delete(conn.lightHandlers, babyUID)
+		}
+		conn.handlersMu.Unlock()
+	}
+}
+
+func (conn *Connection) subscribeToLightCommand() {
 	lightMessageHandler := func(mqttConn MQTT.Client, msg MQTT.Message) {
 		// Extract baby UID and command from topic
-		parts := strings.Split(msg.Topic(), "/")
-		if len(parts) < 4 {
+		babyUID, parts, ok := conn.parseBabyTopic(msg.Topic())
+		if !ok || len(parts) < 2 || !baby.IsValidBabyUID(babyUID) {
 			log.Error().Str("topic", msg.Topic()).Msg("Invalid command topic format")
 			return
 		}
 
-		babyUID := parts[2]
-		command := parts[4]
-
-		// Validate baby UID
-		baby.EnsureValidBabyUID(babyUID)
+		command := parts[1]
 
 		// Handle different commands
 		switch command {
@@ -90,40 +152,180 @@ func (conn *Connection) subscribeToLightCommand() {
 				Str("payload", string(msg.Payload())).
 				Msg("Received light command")
 
-			conn.sendLightCommandHandler(enabled)
+			conn.handlersMu.RLock()
+			handler, ok := conn.lightHandlers[babyUID]
+			conn.handlersMu.RUnlock()
+
+			if !ok {
+				log.Warn().Str("baby", babyUID).Msg("No active connection for the baby, ignoring light command")
+				return
+			}
+
+			(*handler)(enabled)
 		default:
 			log.Warn().Str("command", command).Msg("Unknown command received")
 		}
 	}
 
-	if token := conn.client.Subscribe(commandTopic, 0, lightMessageHandler); token.Wait() && token.Error() != nil {
-		log.Error().Err(token.Error()).Str("topic", commandTopic).Msg("Failed to subscribe to command topic")
+	conn.subscribeBabyCommand("night_light/switch", lightMessageHandler)
+}
+
+// RegisterStandyHandler - registers handler of standby commands for given baby
+// Returns unregister function
+func (conn *Connection) RegisterStandyHandler(babyUID string, sendStandbyCommandHandler SendStandbyCommandHandler) func() {
+	handler := &sendStandbyCommandHandler
+
+	conn.handlersMu.Lock()
+	conn.standbyHandlers[babyUID] = handler
+	conn.handlersMu.Unlock()
+
+	return func() {
+		conn.handlersMu.Lock()
+		if conn.standbyHandlers[babyUID] == handler {
+			delete(conn.standbyHandlers, babyUID)
+		}
+		conn.handlersMu.Unlock()
 	}
 }
 
-func (conn *Connection) RegisterStandyHandler(sendStandbyCommandHandler SendStandbyCommandHandler) {
-	conn.sendStandbyCommandHandler = sendStandbyCommandHandler
+// RegisterRefreshBabiesHandler - registers handler for on demand refresh of the babies list
+func (conn *Connection) RegisterRefreshBabiesHandler(refreshBabiesHandler RefreshBabiesHandler) {
+	conn.handlersMu.Lock()
+	conn.refreshBabiesHandler = refreshBabiesHandler
+	conn.handlersMu.Unlock()
 }
 
-func (conn *Connection) subscribeToStandbyCommand() {
-	commandTopic := fmt.Sprintf("%v/babies/+/standby/switch", conn.Opts.TopicPrefix)
+// RegisterMessageActionHandler - registers handler for marking messages as seen, read or dismissed
+func (conn *Connection) RegisterMessageActionHandler(messageActionHandler MessageActionHandler) {
+	conn.handlersMu.Lock()
+	conn.messageActionHandler = messageActionHandler
+	conn.handlersMu.Unlock()
+}
+
+// RegisterRawRequestHandler - registers handler of raw camera requests ({prefix}/babies/{baby_uid}/requests/send)
+func (conn *Connection) RegisterRawRequestHandler(rawRequestHandler RawRequestHandler) {
+	conn.handlersMu.Lock()
+	conn.rawRequestHandler = rawRequestHandler
+	conn.handlersMu.Unlock()
+}
+
+// subscribeToRawRequestCommand - passes raw requests to the handler and publishes its reply to {prefix}/babies/{baby_uid}/requests/reply
+func (conn *Connection) subscribeToRawRequestCommand() {
+	rawRequestHandler := func(mqttConn MQTT.Client, msg MQTT.Message) {
+		babyUID, _, ok := conn.parseBabyTopic(msg.Topic())
+		if !ok || !baby.IsValidBabyUID(babyUID) {
+			log.Error().Str("topic", msg.Topic()).Msg("Invalid command topic format")
+			return
+		}
+
+		conn.handlersMu.RLock()
+		handler := conn.rawRequestHandler
+		conn.handlersMu.RUnlock()
+
+		if handler == nil {
+			return
+		}
+
+		log.Debug().Str("baby", babyUID).Msg("Received raw request command")
+
+		// Do not block other MQTT messages while waiting for the camera
+		payload := msg.Payload()
+		go func() {
+			conn.publish(conn.babyTopic(babyUID, "requests/reply"), false, handler(babyUID, payload))
+		}()
+	}
+
+	conn.subscribeBabyCommand("requests/send", rawRequestHandler)
+}
+
+func (conn *Connection) subscribeToMessageActionCommand() {
+	messageActionHandler := func(mqttConn MQTT.Client, msg MQTT.Message) {
+		// Extract baby UID and action from topic
+		babyUID, parts, ok := conn.parseBabyTopic(msg.Topic())
+		if !ok || len(parts) < 2 || !baby.IsValidBabyUID(babyUID) {
+			log.Error().Str("topic", msg.Topic()).Msg("Invalid command topic format")
+			return
+		}
+
+		action := message.Action(parts[1])
+
+		if !action.IsValid() {
+			log.Warn().Str("action", string(action)).Msg("Unknown message action received")
+			return
+		}
+
+		messageIDs, err := parseMessageIDs(msg.Payload())
+		if err != nil {
+			log.Error().Str("topic", msg.Topic()).Str("payload", string(msg.Payload())).Err(err).Msg("Invalid message IDs")
+			return
+		}
+
+		log.Debug().
+			Str("baby", babyUID).
+			Str("action", string(action)).
+			Ints("message_ids", messageIDs).
+			Msg("Received message action command")
+
+		conn.handlersMu.RLock()
+		handler := conn.messageActionHandler
+		conn.handlersMu.RUnlock()
+
+		if handler != nil {
+			handler(babyUID, action, messageIDs)
+		}
+	}
+
+	conn.subscribeBabyCommand("messages/+", messageActionHandler)
+}
+
+// parseMessageIDs - accepts single ID or JSON array of IDs
+func parseMessageIDs(payload []byte) ([]int, error) {
+	var messageIDs []int
+	if err := json.Unmarshal(payload, &messageIDs); err == nil {
+		return messageIDs, nil
+	}
+
+	var messageID int
+	if err := json.Unmarshal(payload, &messageID); err != nil {
+		return nil, err
+	}
+
+	return []int{messageID}, nil
+}
+
+func (conn *Connection) subscribeToRefreshBabiesCommand() {
+	commandTopic := fmt.Sprintf("%v/babies/refresh", conn.GetOpts().TopicPrefix)
 	log.Debug().
 		Str("topic", commandTopic).
 		Msg("Subscribing to command topic")
 
+	refreshMessageHandler := func(mqttConn MQTT.Client, msg MQTT.Message) {
+		log.Debug().Msg("Received babies refresh command")
+
+		conn.handlersMu.RLock()
+		handler := conn.refreshBabiesHandler
+		conn.handlersMu.RUnlock()
+
+		if handler != nil {
+			handler()
+		}
+	}
+
+	if token := conn.getClient().Subscribe(commandTopic, 0, refreshMessageHandler); token.Wait() && token.Error() != nil {
+		log.Error().Err(token.Error()).Str("topic", commandTopic).Msg("Failed to subscribe to command topic")
+	}
+}
+
+func (conn *Connection) subscribeToStandbyCommand() {
 	standbyMessageHandler := func(mqttConn MQTT.Client, msg MQTT.Message) {
 		// Extract baby UID and command from topic
-		parts := strings.Split(msg.Topic(), "/")
-		if len(parts) < 4 {
+		babyUID, parts, ok := conn.parseBabyTopic(msg.Topic())
+		if !ok || len(parts) < 2 || !baby.IsValidBabyUID(babyUID) {
 			log.Error().Str("topic", msg.Topic()).Msg("Invalid command topic format")
 			return
 		}
 
-		babyUID := parts[2]
-		command := parts[4]
-
-		// Validate baby UID
-		baby.EnsureValidBabyUID(babyUID)
+		command := parts[1]
 
 		// Handle different commands
 		switch command {
@@ -135,55 +337,80 @@ func (conn *Connection) subscribeToStandbyCommand() {
 				Str("payload", string(msg.Payload())).
 				Msg("Received standby command")
 
-			conn.sendStandbyCommandHandler(enabled)
+			conn.handlersMu.RLock()
+			handler, ok := conn.standbyHandlers[babyUID]
+			conn.handlersMu.RUnlock()
+
+			if !ok {
+				log.Warn().Str("baby", babyUID).Msg("No active connection for the baby, ignoring standby command")
+				return
+			}
+
+			(*handler)(enabled)
 		default:
 			log.Warn().Str("command", command).Msg("Unknown command received")
 		}
 	}
 
-	if token := conn.client.Subscribe(commandTopic, 0, standbyMessageHandler); token.Wait() && token.Error() != nil {
-		log.Error().Err(token.Error()).Str("topic", commandTopic).Msg("Failed to subscribe to command topic")
+	conn.subscribeBabyCommand("standby/switch", standbyMessageHandler)
+}
+
+// HandleState - publishes the changed fields to {prefix}/babies/{baby_uid}/{key} together with their attributes
+func (conn *Connection) HandleState(babyUID string, state baby.State) {
+	publish := func(key string, value interface{}) {
+		conn.publish(conn.babyTopic(babyUID, key), false, fmt.Sprintf("%v", value))
+	}
+
+	values := state.AsMap(false)
+	for key, value := range values {
+		publish(key, value)
+	}
+
+	// Only the changed fields, availability of the others is re-evaluated by watchStaleness
+	fields := conn.StateManager.GetBabyFields(babyUID)
+	changedFields := make(map[string]baby.FieldValue, len(values))
+	for key := range values {
+		if field, ok := fields[key]; ok {
+			changedFields[key] = field
+		}
+	}
+
+	conn.publishAttributes(babyUID, changedFields)
+	conn.publishAvailability(babyUID, changedFields)
+
+	if state.StreamState != nil && *state.StreamState != baby.StreamState_Unknown {
+		publish("is_stream_alive", *state.StreamState == baby.StreamState_Alive)
 	}
 }
 
 func runMqtt(conn *Connection, attempt utils.AttemptContext) {
 
-	if token := conn.client.Connect(); token.Wait() && token.Error() != nil {
-		log.Error().Str("broker_url", conn.Opts.BrokerURL).Err(token.Error()).Msg("Unable to connect to MQTT broker")
+	if token := conn.getClient().Connect(); token.Wait() && token.Error() != nil {
+		log.Error().Str("broker_url", utils.AnonymizeURL(conn.GetOpts().BrokerURL)).Err(token.Error()).Msg("Unable to connect to MQTT broker")
 		attempt.Fail(token.Error())
 		return
 	}
 
-	log.Info().Str("broker_url", conn.Opts.BrokerURL).Msg("Successfully connected to MQTT broker")
+	log.Info().Str("broker_url", utils.AnonymizeURL(conn.GetOpts().BrokerURL)).Msg("Successfully connected to MQTT broker")
 
-	unsubscribe := conn.StateManager.Subscribe(func(babyUID string, state baby.State) {
-		publish := func(key string, value interface{}) {
-			topic := fmt.Sprintf("%v/babies/%v/%v", conn.Opts.TopicPrefix, babyUID, key)
-			log.Trace().Str("topic", topic).Interface("value", value).Msg("MQTT publish")
+	conn.resetAvailability()
 
-			token := conn.client.Publish(topic, 0, false, fmt.Sprintf("%v", value))
-			if token.Wait(); token.Error() != nil {
-				log.Error().Err(token.Error()).Msgf("Unable to publish %v update", key)
-			}
-		}
-
-		for key, value := range state.AsMap(false) {
-			publish(key, value)
-		}
-
-		if state.StreamState != nil && *state.StreamState != baby.StreamState_Unknown {
-			publish("is_stream_alive", *state.StreamState == baby.StreamState_Alive)
-		}
-	})
+	unsubscribe := conn.StateManager.SubscribeSink(conn, baby.SubscribeOpts{Name: "mqtt", Coalesce: true, CurrentState: true})
+	stalenessWatcher := attempt.RunAsChild(conn.watchStaleness)
 
 	// Subscribe to accept light mqtt messages
 	conn.subscribeToLightCommand()
 	conn.subscribeToStandbyCommand()
+	conn.subscribeToRefreshBabiesCommand()
+	conn.subscribeToMessageActionCommand()
+	conn.subscribeToRawRequestCommand()
 
 	// Wait until interrupt signal is received
 	<-attempt.Done()
 
 	log.Debug().Msg("Closing MQTT connection on interrupt")
+	stalenessWatcher.Cancel()
 	unsubscribe()
-	conn.client.Disconnect(250)
+	conn.stopDetections()
+	conn.getClient().Disconnect(250)
 }
@@ -1,5 +1,7 @@
 package mqtt
 
+import "time"
+
 // Opts - holds configuration needed to establish connection to the broker
 type Opts struct {
 	BrokerURL string
@@ -9,4 +11,10 @@ type Opts struct {
 	Password string
 
 	TopicPrefix string
+
+	// BabyTopicPrefixes - topic prefixes of babies which should not use the global one (by baby UID)
+	BabyTopicPrefixes map[string]string
+
+	// EventHoldTime - how long motion / sound detected sensors stay on after an event
+	EventHoldTime time.Duration
 }
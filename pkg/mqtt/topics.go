@@ -0,0 +1,79 @@
+package mqtt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/rs/zerolog/log"
+)
+
+// babyPrefix - returns topic prefix used for the baby
+func (conn *Connection) babyPrefix(babyUID string) string {
+	opts := conn.GetOpts()
+	if prefix, ok := opts.BabyTopicPrefixes[babyUID]; ok && prefix != "" {
+		return prefix
+	}
+
+	return opts.TopicPrefix
+}
+
+// babyTopic - returns {prefix}/babies/{baby_uid}/{key}
+func (conn *Connection) babyTopic(babyUID string, key string) string {
+	return fmt.Sprintf("%v/babies/%v/%v", conn.babyPrefix(babyUID), babyUID, key)
+}
+
+// prefixes - returns all distinct topic prefixes (global one first)
+func (conn *Connection) prefixes() []string {
+	opts := conn.GetOpts()
+	prefixes := []string{opts.TopicPrefix}
+	seen := map[string]bool{opts.TopicPrefix: true}
+
+	var custom []string
+	for _, prefix := range opts.BabyTopicPrefixes {
+		if prefix != "" && !seen[prefix] {
+			seen[prefix] = true
+			custom = append(custom, prefix)
+		}
+	}
+
+	sort.Strings(custom)
+	return append(prefixes, custom...)
+}
+
+// parseBabyTopic - extracts baby UID and the rest of the topic split by '/'
+// Only topics under the prefix of the given baby are accepted
+func (conn *Connection) parseBabyTopic(topic string) (string, []string, bool) {
+	for _, prefix := range conn.prefixes() {
+		rest := strings.TrimPrefix(topic, prefix+"/babies/")
+		if rest == topic {
+			continue
+		}
+
+		parts := strings.Split(rest, "/")
+		babyUID := parts[0]
+		if !baby.IsValidBabyUID(babyUID) || conn.babyPrefix(babyUID) != prefix {
+			continue
+		}
+
+		return babyUID, parts[1:], true
+	}
+
+	return "", nil, false
+}
+
+// subscribeBabyCommand - subscribes to {prefix}/babies/+/{suffix} for all prefixes
+func (conn *Connection) subscribeBabyCommand(suffix string, handler MQTT.MessageHandler) {
+	for _, prefix := range conn.prefixes() {
+		commandTopic := fmt.Sprintf("%v/babies/+/%v", prefix, suffix)
+		log.Debug().
+			Str("topic", commandTopic).
+			Msg("Subscribing to command topic")
+
+		if token := conn.getClient().Subscribe(commandTopic, 0, handler); token.Wait() && token.Error() != nil {
+			log.Error().Err(token.Error()).Str("topic", commandTopic).Msg("Failed to subscribe to command topic")
+		}
+	}
+}
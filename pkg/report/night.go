@@ -0,0 +1,204 @@
+package report
+
+import (
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/message"
+)
+
+// Report - summary of a single night of a baby
+type Report struct {
+	BabyUID     string          `json:"baby_uid"`
+	Date        string          `json:"date"` // date of the morning (YYYY-MM-DD)
+	From        time.Time       `json:"from"`
+	To          time.Time       `json:"to"`
+	Temperature *ClimateStats   `json:"temperature,omitempty"`
+	Humidity    *ClimateStats   `json:"humidity,omitempty"`
+	Motion      ActivityStats   `json:"motion"`
+	Sound       ActivityStats   `json:"sound"`
+	NightLight  NightLightStats `json:"night_light"`
+}
+
+// ClimateStats - statistics of a sensor value over the night
+// Average is weighted by the time for which the value was reported
+type ClimateStats struct {
+	Min                 float64 `json:"min"`
+	Max                 float64 `json:"max"`
+	Avg                 float64 `json:"avg"`
+	ComfortMin          float64 `json:"comfort_min"`
+	ComfortMax          float64 `json:"comfort_max"`
+	OutOfComfortSeconds float64 `json:"out_of_comfort_seconds"`
+}
+
+// ActivityStats - detected events
+type ActivityStats struct {
+	Count    int         `json:"count"`
+	Timeline []time.Time `json:"timeline"`
+}
+
+// NightLightStats - night light usage
+type NightLightStats struct {
+	// TurnedOn - how many times was the light turned on
+	TurnedOn  int     `json:"turned_on"`
+	OnSeconds float64 `json:"on_seconds"`
+}
+
+type climateTracker struct {
+	band     Band
+	hasValue bool
+	last     float64
+	lastTime time.Time
+	min      float64
+	max      float64
+	weighted float64
+	total    time.Duration
+	outside  time.Duration
+}
+
+func (tracker *climateTracker) advance(t time.Time) {
+	if tracker.hasValue {
+		if d := t.Sub(tracker.lastTime); d > 0 {
+			tracker.weighted += tracker.last * d.Seconds()
+			tracker.total += d
+			if !tracker.band.Contains(tracker.last) {
+				tracker.outside += d
+			}
+		}
+	}
+
+	tracker.lastTime = t
+}
+
+func (tracker *climateTracker) observe(t time.Time, value float64) {
+	tracker.advance(t)
+
+	if !tracker.hasValue || value < tracker.min {
+		tracker.min = value
+	}
+
+	if !tracker.hasValue || value > tracker.max {
+		tracker.max = value
+	}
+
+	tracker.hasValue = true
+	tracker.last = value
+}
+
+func (tracker *climateTracker) stats(end time.Time) *ClimateStats {
+	tracker.advance(end)
+	if !tracker.hasValue {
+		return nil
+	}
+
+	avg := tracker.last
+	if tracker.total > 0 {
+		avg = tracker.weighted / tracker.total.Seconds()
+	}
+
+	return &ClimateStats{
+		Min:                 tracker.min,
+		Max:                 tracker.max,
+		Avg:                 avg,
+		ComfortMin:          tracker.band.Min,
+		ComfortMax:          tracker.band.Max,
+		OutOfComfortSeconds: tracker.outside.Seconds(),
+	}
+}
+
+// Night - accumulates data of a single night of a baby
+type Night struct {
+	BabyUID string
+	Start   time.Time
+
+	temperature climateTracker
+	humidity    climateTracker
+	motion      ActivityStats
+	sound       ActivityStats
+	nightLight  NightLightStats
+	lightOn     bool
+	lightSince  time.Time
+}
+
+// NewNight - constructor, the initial state is applied as of the start of the night
+func NewNight(babyUID string, start time.Time, opts Opts, initial baby.State) *Night {
+	night := &Night{
+		BabyUID:     babyUID,
+		Start:       start,
+		temperature: climateTracker{band: opts.ComfortTemperature, lastTime: start},
+		humidity:    climateTracker{band: opts.ComfortHumidity, lastTime: start},
+		motion:      ActivityStats{Timeline: []time.Time{}},
+		sound:       ActivityStats{Timeline: []time.Time{}},
+	}
+
+	if initial.TemperatureMilli != nil {
+		night.temperature.observe(start, initial.GetTemperature())
+	}
+
+	if initial.HumidityMilli != nil {
+		night.humidity.observe(start, initial.GetHumidity())
+	}
+
+	night.lightOn = initial.GetNightLight()
+	night.lightSince = start
+
+	return night
+}
+
+// ObserveState - applies state update which happened at the given time
+func (night *Night) ObserveState(t time.Time, state baby.State) {
+	if state.TemperatureMilli != nil {
+		night.temperature.observe(t, state.GetTemperature())
+	}
+
+	if state.HumidityMilli != nil {
+		night.humidity.observe(t, state.GetHumidity())
+	}
+
+	if state.NightLight != nil && *state.NightLight != night.lightOn {
+		if night.lightOn {
+			night.nightLight.OnSeconds += t.Sub(night.lightSince).Seconds()
+		} else {
+			night.nightLight.TurnedOn++
+		}
+
+		night.lightOn = *state.NightLight
+		night.lightSince = t
+	}
+}
+
+// ObserveEvent - records motion / sound events, other events are ignored
+func (night *Night) ObserveEvent(event baby.Event) {
+	if event.Time.Before(night.Start) {
+		return
+	}
+
+	switch event.Type {
+	case message.MotionEventMessageType:
+		night.motion.Count++
+		night.motion.Timeline = append(night.motion.Timeline, event.Time)
+	case message.SoundEventMessageType:
+		night.sound.Count++
+		night.sound.Timeline = append(night.sound.Timeline, event.Time)
+	}
+}
+
+// Report - finishes the night at the given time and returns its summary
+func (night *Night) Report(end time.Time) Report {
+	nightLight := night.nightLight
+	if night.lightOn {
+		nightLight.OnSeconds += end.Sub(night.lightSince).Seconds()
+	}
+
+	return Report{
+		BabyUID:     night.BabyUID,
+		Date:        end.Format(dateLayout),
+		From:        night.Start,
+		To:          end,
+		Temperature: night.temperature.stats(end),
+		Humidity:    night.humidity.stats(end),
+		Motion:      night.motion,
+		Sound:       night.sound,
+		NightLight:  nightLight,
+	}
+}
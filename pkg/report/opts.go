@@ -0,0 +1,86 @@
+package report
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Band - comfortable range of a value (inclusive)
+type Band struct {
+	Min float64
+	Max float64
+}
+
+// Contains - checks if the value is within the band
+func (band Band) Contains(value float64) bool {
+	return value >= band.Min && value <= band.Max
+}
+
+// Opts - nightly report configuration
+type Opts struct {
+	// Dir - directory where the reports are written (one subdirectory per baby, one file per morning)
+	Dir string
+
+	// NightStart, NightEnd - local time of day when the night starts / ends (offset from midnight)
+	// Night may wrap over midnight (ie. 19:00 - 07:00)
+	NightStart time.Duration
+	NightEnd   time.Duration
+
+	// Location - timezone of the night window (default: local)
+	Location *time.Location
+
+	ComfortTemperature Band
+	ComfortHumidity    Band
+
+	// Babies - per baby overrides (by baby UID)
+	Babies map[string]BabyOpts
+}
+
+// BabyOpts - per baby overrides of the comfort bands
+type BabyOpts struct {
+	ComfortTemperature *Band
+	ComfortHumidity    *Band
+}
+
+// ForBaby - returns options with the baby overrides applied
+func (opts Opts) ForBaby(babyUID string) Opts {
+	if babyOpts, ok := opts.Babies[babyUID]; ok {
+		if babyOpts.ComfortTemperature != nil {
+			opts.ComfortTemperature = *babyOpts.ComfortTemperature
+		}
+
+		if babyOpts.ComfortHumidity != nil {
+			opts.ComfortHumidity = *babyOpts.ComfortHumidity
+		}
+	}
+
+	return opts
+}
+
+// ParseClock - parses HH:MM time of day into offset from midnight
+func ParseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ParseBand - parses MIN-MAX range
+func ParseBand(value string) (Band, error) {
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) != 2 {
+		return Band{}, fmt.Errorf("invalid range %q, expected MIN-MAX", value)
+	}
+
+	min, minErr := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	max, maxErr := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if minErr != nil || maxErr != nil || min > max {
+		return Band{}, fmt.Errorf("invalid range %q, expected MIN-MAX", value)
+	}
+
+	return Band{Min: min, Max: max}, nil
+}
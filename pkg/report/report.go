@@ -0,0 +1,244 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/rs/zerolog/log"
+)
+
+const dateLayout = "2006-01-02"
+
+// Generator - collects state updates and events during the night window and produces a report every morning
+type Generator struct {
+	Opts Opts
+
+	mu       sync.Mutex
+	nights   map[string]*Night
+	inWindow bool
+	start    time.Time
+	handlers []func(Report)
+}
+
+// NewGenerator - constructor
+func NewGenerator(opts Opts) *Generator {
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+
+	return &Generator{
+		Opts:   opts,
+		nights: make(map[string]*Night),
+	}
+}
+
+// OnReport - registers function to be called with every finished report
+func (g *Generator) OnReport(handler func(Report)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.handlers = append(g.handlers, handler)
+}
+
+// Window - returns the night window which contains the given time or the next one
+func (g *Generator) Window(now time.Time) (time.Time, time.Time, bool) {
+	length := (g.Opts.NightEnd - g.Opts.NightStart + 24*time.Hour) % (24 * time.Hour)
+	if length == 0 {
+		length = 24 * time.Hour
+	}
+
+	local := now.In(g.Opts.Location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, g.Opts.Location)
+
+	// Night which started yesterday may still be running
+	for _, dayOffset := range []int{-1, 0, 1} {
+		start := midnight.AddDate(0, 0, dayOffset).Add(g.Opts.NightStart)
+		end := start.Add(length)
+
+		if now.Before(end) {
+			return start, end, !now.Before(start)
+		}
+	}
+
+	// Not reachable, tomorrow's night always ends in the future
+	return now, now, false
+}
+
+// Run - tracks nights until the context is cancelled
+func (g *Generator) Run(manager *baby.StateManager, ctx utils.GracefulContext) {
+	unsubscribe := manager.SubscribeWithOpts(func(babyUID string, state baby.State) {
+		if night := g.night(manager, babyUID); night != nil {
+			g.mu.Lock()
+			night.ObserveState(time.Now(), state)
+			g.mu.Unlock()
+		}
+	}, baby.SubscribeOpts{Name: "report", CurrentState: true})
+
+	defer unsubscribe()
+
+	unsubscribeEvents := manager.SubscribeEvents(func(babyUID string, event baby.Event) {
+		if night := g.night(manager, babyUID); night != nil {
+			g.mu.Lock()
+			night.ObserveEvent(event)
+			g.mu.Unlock()
+		}
+	}, baby.SubscribeOpts{Name: "report_events"})
+
+	defer unsubscribeEvents()
+
+	for {
+		start, end, inWindow := g.Window(time.Now())
+
+		next := start
+		if inWindow {
+			g.startNight(manager, start)
+			next = end
+		}
+
+		log.Debug().Time("next", next).Bool("night", inWindow).Msg("Waiting for next night report boundary")
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if inWindow {
+			g.finishNight(end)
+		}
+	}
+}
+
+func (g *Generator) startNight(manager *baby.StateManager, start time.Time) {
+	g.mu.Lock()
+	g.inWindow = true
+	g.start = start
+	g.mu.Unlock()
+
+	for babyUID := range manager.GetBabyStates() {
+		g.night(manager, babyUID)
+	}
+}
+
+// night - returns night of the baby, starts it if the baby appeared during the night
+// Returns nil outside of the night window
+func (g *Generator) night(manager *baby.StateManager, babyUID string) *Night {
+	g.mu.Lock()
+	if !g.inWindow {
+		g.mu.Unlock()
+		return nil
+	}
+
+	if night, ok := g.nights[babyUID]; ok {
+		g.mu.Unlock()
+		return night
+	}
+
+	start := g.start
+	g.mu.Unlock()
+
+	// Seed with the current state, so that values which do not change during the night are accounted for
+	initial := *manager.GetBabyState(babyUID)
+	now := time.Now()
+	if now.After(start) {
+		start = now
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if night, ok := g.nights[babyUID]; ok {
+		return night
+	}
+
+	night := NewNight(babyUID, start, g.Opts.ForBaby(babyUID), initial)
+	g.nights[babyUID] = night
+	return night
+}
+
+func (g *Generator) finishNight(end time.Time) {
+	g.mu.Lock()
+	reports := make([]Report, 0, len(g.nights))
+	for _, night := range g.nights {
+		reports = append(reports, night.Report(end))
+	}
+
+	g.nights = make(map[string]*Night)
+	g.inWindow = false
+	handlers := append([]func(Report){}, g.handlers...)
+	g.mu.Unlock()
+
+	for _, r := range reports {
+		log.Info().Str("baby_uid", r.BabyUID).Str("date", r.Date).Msg("Night report finished")
+
+		if err := g.save(r); err != nil {
+			log.Error().Err(err).Str("baby_uid", r.BabyUID).Msg("Unable to write night report")
+		}
+
+		for _, handler := range handlers {
+			handler(r)
+		}
+	}
+}
+
+func (g *Generator) save(r Report) error {
+	baby.EnsureValidBabyUID(r.BabyUID)
+
+	dir := filepath.Join(g.Opts.Dir, r.BabyUID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, r.Date+".json"), data, 0644)
+}
+
+// Load - reads report of the baby for the given morning (YYYY-MM-DD), empty date returns the latest report
+// Returns nil if there is no such report
+func (g *Generator) Load(babyUID string, date string) (*Report, error) {
+	if !baby.IsValidBabyUID(babyUID) {
+		return nil, fmt.Errorf("invalid baby UID")
+	}
+
+	dir := filepath.Join(g.Opts.Dir, babyUID)
+
+	if date == "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil || len(files) == 0 {
+			return nil, err
+		}
+
+		sort.Strings(files)
+		date = strings.TrimSuffix(filepath.Base(files[len(files)-1]), ".json")
+	} else if _, err := time.Parse(dateLayout, date); err != nil {
+		return nil, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", date)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, date+".json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	r := &Report{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
@@ -0,0 +1,67 @@
+package report_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/message"
+	"github.com/indiefan/home_assistant_nanit/pkg/report"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNightReport(t *testing.T) {
+	opts := report.Opts{
+		ComfortTemperature: report.Band{Min: 19, Max: 22},
+		ComfortHumidity:    report.Band{Min: 40, Max: 60},
+	}
+
+	start := time.Date(2024, 1, 1, 19, 0, 0, 0, time.UTC)
+	night := report.NewNight("b1", start, opts, *baby.NewState().SetTemperatureMilli(20000).SetNightLight(true))
+
+	night.ObserveState(start.Add(1*time.Hour), *baby.NewState().SetTemperatureMilli(23000).SetNightLight(false))
+	night.ObserveState(start.Add(2*time.Hour), *baby.NewState().SetTemperatureMilli(21000).SetHumidityMilli(50000))
+	night.ObserveState(start.Add(3*time.Hour), *baby.NewState().SetNightLight(true))
+	night.ObserveEvent(baby.Event{Type: message.MotionEventMessageType, Time: start.Add(90 * time.Minute)})
+	night.ObserveEvent(baby.Event{Type: message.SoundEventMessageType, Time: start.Add(-time.Minute)})
+
+	r := night.Report(start.Add(4 * time.Hour))
+
+	assert.Equal(t, "2024-01-01", r.Date)
+	assert.Equal(t, 20.0, r.Temperature.Min)
+	assert.Equal(t, 23.0, r.Temperature.Max)
+	assert.InDelta(t, 21.25, r.Temperature.Avg, 0.001)
+	assert.Equal(t, 3600.0, r.Temperature.OutOfComfortSeconds)
+	assert.Equal(t, 50.0, r.Humidity.Avg)
+	assert.Equal(t, 1, r.Motion.Count)
+	assert.Equal(t, 0, r.Sound.Count, "Events from before the night are ignored")
+	assert.Equal(t, 1, r.NightLight.TurnedOn)
+	assert.Equal(t, 2*3600.0, r.NightLight.OnSeconds)
+}
+
+func TestWindowOverMidnight(t *testing.T) {
+	g := report.NewGenerator(report.Opts{NightStart: 19 * time.Hour, NightEnd: 7 * time.Hour, Location: time.UTC})
+
+	start, end, inWindow := g.Window(time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC))
+	assert.True(t, inWindow)
+	assert.Equal(t, time.Date(2024, 1, 1, 19, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2024, 1, 2, 7, 0, 0, 0, time.UTC), end)
+
+	start, _, inWindow = g.Window(time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC))
+	assert.False(t, inWindow)
+	assert.Equal(t, time.Date(2024, 1, 2, 19, 0, 0, 0, time.UTC), start)
+}
+
+func TestLoadReport(t *testing.T) {
+	g := report.NewGenerator(report.Opts{Dir: t.TempDir()})
+
+	r, err := g.Load("b1", "")
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+
+	_, err = g.Load("../b1", "")
+	assert.Error(t, err)
+
+	_, err = g.Load("b1", "yesterday")
+	assert.Error(t, err)
+}
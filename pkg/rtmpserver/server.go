@@ -9,32 +9,45 @@ import (
 	"github.com/notedit/rtmp/format/rtmp"
 	"github.com/rs/zerolog/log"
 	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/metrics"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
 )
 
 type rtmpHandler struct {
 	babyStateManager  *baby.StateManager
 	broadcastersMu    sync.RWMutex
 	broadcastersByUID map[string]*broadcaster
+	hadPublisher      map[string]bool
 }
 
-// StartRTMPServer - Blocking server
-func StartRTMPServer(addr string, babyStateManager *baby.StateManager) {
+// StartRTMPServer - Blocking server, stops accepting new connections once the context is cancelled
+func StartRTMPServer(addr string, babyStateManager *baby.StateManager, ctx utils.GracefulContext) {
 	lis, err := net.Listen("tcp", addr)
 	if err != nil {
-		log.Fatal().Str("addr", addr).Err(err).Msg("Unable to start RTMP server")
-		panic(err)
+		log.Error().Str("addr", addr).Err(err).Msg("Unable to start RTMP server")
+		return
 	}
 
 	log.Info().Str("addr", addr).Msg("RTMP server started")
 
+	go func() {
+		<-ctx.Done()
+		lis.Close()
+	}()
+
 	s := rtmp.NewServer()
 	s.HandleConn = newRtmpHandler(babyStateManager).handleConnection
 
 	for {
 		nc, err := lis.Accept()
 		if err != nil {
-			time.Sleep(time.Second)
-			continue
+			select {
+			case <-ctx.Done():
+				log.Info().Str("addr", addr).Msg("RTMP server stopped")
+				return
+			case <-time.After(time.Second):
+				continue
+			}
 		}
 		go s.HandleNetConn(nc)
 	}
@@ -43,6 +56,7 @@ func StartRTMPServer(addr string, babyStateManager *baby.StateManager) {
 func newRtmpHandler(babyStateManager *baby.StateManager) *rtmpHandler {
 	return &rtmpHandler{
 		broadcastersByUID: make(map[string]*broadcaster),
+		hadPublisher:      make(map[string]bool),
 		babyStateManager:  babyStateManager,
 	}
 }
@@ -90,6 +104,9 @@ func (s *rtmpHandler) handleConnection(c *rtmp.Conn, nc net.Conn) {
 			return
 		}
 
+		metrics.RTMPSubscribers.Add(1, babyUID)
+		defer metrics.RTMPSubscribers.Add(-1, babyUID)
+
 		closeC := c.CloseNotify()
 		for {
 			select {
@@ -101,10 +118,13 @@ func (s *rtmpHandler) handleConnection(c *rtmp.Conn, nc net.Conn) {
 				}
 
 				c.WritePacket(pkt)
+				metrics.RTMPPacketsRelayed.Inc(babyUID)
+				metrics.RTMPBytesRelayed.Add(float64(len(pkt.Data)), babyUID)
 
 			case <-closeC:
 				sublog.Debug().Msg("Stream subscriber disconnected")
 				unsubscribe()
+				return
 			}
 		}
 	}
@@ -116,8 +136,14 @@ func (s *rtmpHandler) getNewPublisher(babyUID string) *broadcaster {
 	s.broadcastersMu.Lock()
 	existingBroadcaster, hadExistingBroadcaster := s.broadcastersByUID[babyUID]
 	s.broadcastersByUID[babyUID] = broadcaster
+	isReconnect := s.hadPublisher[babyUID]
+	s.hadPublisher[babyUID] = true
 	s.broadcastersMu.Unlock()
 
+	if isReconnect {
+		metrics.RTMPPublisherReconnects.Inc(babyUID)
+	}
+
 	if hadExistingBroadcaster {
 		log.Warn().Msg("Baby already has active publisher, closing existing subscribers")
 		go existingBroadcaster.closeSubscribers()
@@ -0,0 +1,66 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+)
+
+// deriveKey - turns arbitrary key material (ie. passphrase) into AES-256 key
+func deriveKey(keyMaterial []byte) []byte {
+	sum := sha256.Sum256(keyMaterial)
+	return sum[:]
+}
+
+func newGCM(keyMaterial []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey(keyMaterial))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encrypt - seals plaintext with AES-GCM, returns base64 encoded nonce + ciphertext
+func encrypt(keyMaterial []byte, plaintext string) (string, error) {
+	gcm, err := newGCM(keyMaterial)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt - opens value produced by encrypt
+func decrypt(keyMaterial []byte, encoded string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := newGCM(keyMaterial)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
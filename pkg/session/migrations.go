@@ -0,0 +1,81 @@
+package session
+
+import (
+	"fmt"
+)
+
+// Migration - upgrades raw session data from one revision to the next one
+type Migration func(data map[string]interface{}) error
+
+// migrations - registered upgrades indexed by the revision they upgrade from
+// Note: whenever you increment Revision, add migration from the previous one here
+var migrations = map[int]Migration{
+	// Revision 4 introduced optional encryption of the refresh token (refreshTokenEncrypted)
+	3: func(data map[string]interface{}) error {
+		return nil
+	},
+
+	// Revision 5 replaced global lastSeenMessageTime with per-baby messageCursors
+	4: func(data map[string]interface{}) error {
+		lastSeen, _ := data["lastSeenMessageTime"].(string)
+		delete(data, "lastSeenMessageTime")
+
+		cursors := make(map[string]interface{})
+		if babies, ok := data["babies"].([]interface{}); ok && lastSeen != "" {
+			for _, b := range babies {
+				if babyData, ok := b.(map[string]interface{}); ok {
+					if uid, ok := babyData["uid"].(string); ok {
+						cursors[uid] = map[string]interface{}{"time": lastSeen}
+					}
+				}
+			}
+		}
+
+		data["messageCursors"] = cursors
+		return nil
+	},
+}
+
+// migrate - applies all migrations necessary to get the data to the current revision
+// Returns original revision of the data and flag if any migration has been applied
+func migrate(data map[string]interface{}) (int, bool, error) {
+	revision, err := readRevision(data)
+	if err != nil {
+		return 0, false, err
+	}
+
+	fromRevision := revision
+
+	if revision > Revision {
+		return fromRevision, false, fmt.Errorf("session revision %v is newer than supported revision %v", revision, Revision)
+	}
+
+	for revision < Revision {
+		migration, ok := migrations[revision]
+		if !ok {
+			return fromRevision, false, fmt.Errorf("no migration available from revision %v", revision)
+		}
+
+		if err := migration(data); err != nil {
+			return fromRevision, false, fmt.Errorf("migration from revision %v failed: %w", revision, err)
+		}
+
+		revision++
+		data["revision"] = revision
+	}
+
+	return fromRevision, fromRevision != revision, nil
+}
+
+func readRevision(data map[string]interface{}) (int, error) {
+	switch revision := data["revision"].(type) {
+	case float64:
+		return int(revision), nil
+	case int:
+		return revision, nil
+	case nil:
+		return 0, fmt.Errorf("session file does not contain revision")
+	default:
+		return 0, fmt.Errorf("unexpected revision value %v", revision)
+	}
+}
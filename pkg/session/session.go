@@ -4,30 +4,54 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
-	"github.com/rs/zerolog/log"
 	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/rs/zerolog/log"
 )
 
-// Revision - marks the version of the structure of a session file. Only files with equal revision will be loaded
-// Note: you should increment this whenever you change the Session structure
-const Revision = 3
+// Revision - marks the version of the structure of a session file. Older revisions are upgraded through migrations
+// Note: you should increment this whenever you change the Session structure and register a migration for it
+const Revision = 5
 
 // Session - application session data container
 type Session struct {
-	Revision            int         `json:"revision"`
-	AuthToken           string      `json:"authToken"`
-	AuthTime            time.Time   `json:"authTime"`
-	Babies              []baby.Baby `json:"babies"`
-	RefreshToken        string      `json:"refreshToken"`
-	LastSeenMessageTime time.Time   `json:"lastSeenMessageTime"`
+	Revision     int         `json:"revision"`
+	AuthToken    string      `json:"authToken"`
+	AuthTime     time.Time   `json:"authTime"`
+	Babies       []baby.Baby `json:"babies"`
+	RefreshToken string      `json:"refreshToken"`
+
+	// MessageCursors - position of the last delivered event message per baby UID
+	MessageCursors map[string]MessageCursor `json:"messageCursors"`
+
+	// RefreshTokenEncrypted - refresh token sealed with the store encryption key (only used in the file)
+	RefreshTokenEncrypted string `json:"refreshTokenEncrypted,omitempty"`
+}
+
+// MessageCursor - identifies the last event message which has been delivered
+type MessageCursor struct {
+	Time      time.Time `json:"time"`
+	MessageID int       `json:"messageId"`
+}
+
+// IsZero - returns true if nothing has been delivered yet
+func (cursor MessageCursor) IsZero() bool {
+	return cursor.Time.IsZero() && cursor.MessageID == 0
 }
 
 // Store - application session store context
+// Note: the session is shared by the app components, use the accessors instead of the Session fields once the app runs
 type Store struct {
 	Filename string
 	Session  *Session
+
+	// EncryptionKey - optional key material used for encrypting the refresh token at rest
+	EncryptionKey []byte
+
+	mu sync.Mutex
 }
 
 // NewSessionStore - constructor
@@ -51,50 +75,162 @@ func (store *Store) Load() {
 
 	defer f.Close()
 
-	session := &Session{}
-	jsonErr := json.NewDecoder(f).Decode(session)
+	data := make(map[string]interface{})
+	jsonErr := json.NewDecoder(f).Decode(&data)
 	if jsonErr != nil {
 		log.Fatal().Str("filename", store.Filename).Err(jsonErr).Msg("Unable to decode app session file")
 	}
 
-	if session.Revision == Revision {
-		store.Session = session
-		log.Info().Str("filename", store.Filename).Msg("Loaded app session from the file")
-	} else {
-		log.Warn().Str("filename", store.Filename).Msg("App session file contains older revision of the state, ignoring")
+	fromRevision, migrated, migrateErr := migrate(data)
+	if migrateErr != nil {
+		log.Warn().Str("filename", store.Filename).Int("revision", fromRevision).Err(migrateErr).Msg("Unable to migrate app session file, ignoring")
+		return
+	}
+
+	session, decodeErr := decodeSession(data)
+	if decodeErr != nil {
+		log.Fatal().Str("filename", store.Filename).Err(decodeErr).Msg("Unable to decode app session file")
 	}
 
+	if session.RefreshTokenEncrypted != "" {
+		if len(store.EncryptionKey) == 0 {
+			log.Warn().Str("filename", store.Filename).Msg("App session file contains encrypted refresh token, but no encryption key was provided. Relogin required.")
+		} else {
+			refreshToken, decryptErr := decrypt(store.EncryptionKey, session.RefreshTokenEncrypted)
+			if decryptErr != nil {
+				log.Fatal().Str("filename", store.Filename).Err(decryptErr).Msg("Unable to decrypt refresh token, check the session encryption key")
+			}
+
+			session.RefreshToken = refreshToken
+		}
+
+		session.RefreshTokenEncrypted = ""
+	}
+
+	store.mu.Lock()
+	store.Session = session
+	store.mu.Unlock()
+
+	if migrated {
+		log.Info().Str("filename", store.Filename).Int("from", fromRevision).Int("to", Revision).Msg("Migrated app session file to the current revision")
+		store.Save()
+	} else {
+		log.Info().Str("filename", store.Filename).Msg("Loaded app session from the file")
+	}
 }
 
 // Save - stores current data in a file
+// The file is written to a temporary location first and then renamed over the original one,
+// so that a crash in the middle of the write can never leave a corrupted session behind.
 func (store *Store) Save() {
 	if store.Filename == "" {
 		return
 	}
 
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
 	log.Trace().Str("filename", store.Filename).Msg("Storing app session to the file")
 
-	f, err := os.OpenFile(store.Filename, os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
-		log.Fatal().Str("filename", store.Filename).Err(err).Msg("Unable to open app session file for writing")
-	}
+	// All the writers hold the lock too (see the accessors)
+	persisted := *store.Session
+	if len(store.EncryptionKey) > 0 && persisted.RefreshToken != "" {
+		encrypted, encryptErr := encrypt(store.EncryptionKey, persisted.RefreshToken)
+		if encryptErr != nil {
+			log.Fatal().Str("filename", store.Filename).Err(encryptErr).Msg("Unable to encrypt refresh token")
+		}
 
-	defer f.Close()
+		persisted.RefreshToken = ""
+		persisted.RefreshTokenEncrypted = encrypted
+	}
 
-	data, jsonErr := json.Marshal(store.Session)
+	data, jsonErr := json.Marshal(persisted)
 	if jsonErr != nil {
 		log.Fatal().Str("filename", store.Filename).Err(jsonErr).Msg("Unable to marshal contents of app session file")
 	}
 
-	_, writeErr := f.Write(data)
-	if writeErr != nil {
-		log.Fatal().Str("filename", store.Filename).Err(writeErr).Msg("Unable to wrote to app session file")
+	if writeErr := utils.WriteFileAtomic(store.Filename, data, 0600); writeErr != nil {
+		log.Fatal().Str("filename", store.Filename).Err(writeErr).Msg("Unable to write to app session file")
+	}
+}
+
+// GetAuthToken - returns auth token and the time it was obtained in thread safe manner
+func (store *Store) GetAuthToken() (string, time.Time) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return store.Session.AuthToken, store.Session.AuthTime
+}
+
+// GetRefreshToken - returns refresh token in thread safe manner
+func (store *Store) GetRefreshToken() string {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return store.Session.RefreshToken
+}
+
+// SetRefreshToken - updates refresh token and persists the session
+func (store *Store) SetRefreshToken(refreshToken string) {
+	store.mu.Lock()
+	store.Session.RefreshToken = refreshToken
+	store.mu.Unlock()
+
+	store.Save()
+}
+
+// SetAuth - updates both tokens obtained by a successful authorization and persists the session
+func (store *Store) SetAuth(authToken string, refreshToken string) {
+	store.mu.Lock()
+	store.Session.AuthToken = authToken
+	store.Session.RefreshToken = refreshToken
+	store.Session.AuthTime = time.Now()
+	store.mu.Unlock()
+
+	store.Save()
+}
+
+// GetBabies - returns copy of the babies list in thread safe manner
+func (store *Store) GetBabies() []baby.Baby {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return append([]baby.Baby(nil), store.Session.Babies...)
+}
+
+// SetBabies - updates the babies list and persists the session
+func (store *Store) SetBabies(babies []baby.Baby) {
+	store.mu.Lock()
+	store.Session.Babies = append([]baby.Baby(nil), babies...)
+	store.mu.Unlock()
+
+	store.Save()
+}
+
+// GetMessageCursor - returns event message cursor of a baby in thread safe manner
+func (store *Store) GetMessageCursor(babyUID string) MessageCursor {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return store.Session.MessageCursors[babyUID]
+}
+
+// SetMessageCursor - updates event message cursor of a baby and persists the session
+func (store *Store) SetMessageCursor(babyUID string, cursor MessageCursor) {
+	store.mu.Lock()
+	if store.Session.MessageCursors == nil {
+		store.Session.MessageCursors = make(map[string]MessageCursor)
 	}
+	store.Session.MessageCursors[babyUID] = cursor
+	store.mu.Unlock()
+
+	store.Save()
 }
 
 // InitSessionStore - Initializes new application session store
-func InitSessionStore(sessionFile string) *Store {
+func InitSessionStore(sessionFile string, encryptionKey []byte) *Store {
 	sessionStore := NewSessionStore()
+	sessionStore.EncryptionKey = encryptionKey
 
 	// Load previous state of the application from session file
 	if sessionFile != "" {
@@ -110,3 +246,17 @@ func InitSessionStore(sessionFile string) *Store {
 
 	return sessionStore
 }
+
+func decodeSession(data map[string]interface{}) (*Session, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &Session{}
+	if err := json.Unmarshal(raw, session); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
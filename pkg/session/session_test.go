@@ -0,0 +1,109 @@
+package session_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionMigratesPreviousRevision(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "session.json")
+	os.WriteFile(filename, []byte(`{"revision":3,"authToken":"abc","refreshToken":"refresh"}`), 0644)
+
+	store := session.InitSessionStore(filename, nil)
+
+	assert.Equal(t, session.Revision, store.Session.Revision)
+	assert.Equal(t, "abc", store.Session.AuthToken)
+	assert.Equal(t, "refresh", store.Session.RefreshToken)
+
+	reloaded := session.InitSessionStore(filename, nil)
+	assert.Equal(t, session.Revision, reloaded.Session.Revision)
+	assert.Equal(t, "refresh", reloaded.Session.RefreshToken)
+}
+
+func TestSessionSaveReplacesFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "session.json")
+
+	store := session.InitSessionStore(filename, nil)
+	store.Session.AuthToken = strings.Repeat("x", 200)
+	store.Save()
+
+	store.Session.AuthToken = "short"
+	store.Save()
+
+	reloaded := session.InitSessionStore(filename, nil)
+	assert.Equal(t, "short", reloaded.Session.AuthToken)
+
+	entries, _ := os.ReadDir(filepath.Dir(filename))
+	assert.Len(t, entries, 1, "Temporary files should not be left behind")
+}
+
+func TestSessionEncryptedRefreshToken(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "session.json")
+	key := []byte("secret passphrase")
+
+	store := session.InitSessionStore(filename, key)
+	store.Session.RefreshToken = "my-refresh-token"
+	store.Save()
+
+	raw, _ := os.ReadFile(filename)
+	assert.NotContains(t, string(raw), "my-refresh-token")
+	assert.Contains(t, string(raw), "refreshTokenEncrypted")
+
+	reloaded := session.InitSessionStore(filename, key)
+	assert.Equal(t, "my-refresh-token", reloaded.Session.RefreshToken)
+	assert.Empty(t, reloaded.Session.RefreshTokenEncrypted)
+
+	withoutKey := session.InitSessionStore(filename, nil)
+	assert.Empty(t, withoutKey.Session.RefreshToken)
+}
+
+func TestSessionMigratesLastSeenMessageTimeToCursors(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "session.json")
+	os.WriteFile(filename, []byte(`{"revision":4,"babies":[{"uid":"b1"},{"uid":"b2"}],"lastSeenMessageTime":"2024-01-02T03:04:05Z"}`), 0644)
+
+	store := session.InitSessionStore(filename, nil)
+
+	assert.Equal(t, 2024, store.GetMessageCursor("b1").Time.Year())
+	assert.Equal(t, store.GetMessageCursor("b1"), store.GetMessageCursor("b2"))
+	assert.True(t, store.GetMessageCursor("unknown").IsZero())
+
+	store.SetMessageCursor("b2", session.MessageCursor{MessageID: 42})
+	reloaded := session.InitSessionStore(filename, nil)
+	assert.Equal(t, 42, reloaded.GetMessageCursor("b2").MessageID)
+	assert.Equal(t, 2024, reloaded.GetMessageCursor("b1").Time.Year())
+}
+
+func TestSessionAccessorsAreThreadSafe(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "session.json")
+	store := session.InitSessionStore(filename, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			store.SetAuth("token", "refresh")
+		}()
+
+		go func() {
+			defer wg.Done()
+			store.GetAuthToken()
+			store.Save()
+		}()
+	}
+
+	wg.Wait()
+
+	reloaded := session.InitSessionStore(filename, nil)
+	authToken, authTime := reloaded.GetAuthToken()
+	assert.Equal(t, "token", authToken)
+	assert.False(t, authTime.IsZero())
+	assert.Equal(t, "refresh", reloaded.GetRefreshToken())
+}
@@ -0,0 +1,147 @@
+package sleep
+
+import (
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/message"
+)
+
+// Session - single sleep session
+type Session struct {
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	DurationSeconds float64   `json:"duration_seconds"`
+}
+
+// Detector - infers sleep of a single baby from activity events, night mode and standby
+type Detector struct {
+	opts Opts
+
+	asleep       bool
+	sessionStart time.Time
+	lastSession  *Session
+
+	isNight      bool
+	standby      bool
+	lastActivity time.Time
+	recent       []time.Time
+}
+
+// NewDetector - constructor, quiet period is counted from the given time
+func NewDetector(opts Opts, now time.Time) *Detector {
+	return &Detector{
+		opts:         opts,
+		lastActivity: now,
+	}
+}
+
+// canSleep - checks whether the conditions allow the baby to be considered asleep
+func (d *Detector) canSleep() bool {
+	return !d.standby && (!d.opts.NightOnly || d.isNight)
+}
+
+// ObserveState - applies state update which happened at the given time
+// Returns finished session if the update woke the baby up
+func (d *Detector) ObserveState(t time.Time, state baby.State) *Session {
+	if state.IsNight != nil && *state.IsNight != d.isNight {
+		d.isNight = *state.IsNight
+
+		// Lights went off, quiet period starts now
+		if d.isNight && t.After(d.lastActivity) {
+			d.lastActivity = t
+		}
+	}
+
+	if state.Standby != nil && *state.Standby != d.standby {
+		d.standby = *state.Standby
+
+		// Nothing could be observed during the standby
+		if !d.standby && t.After(d.lastActivity) {
+			d.lastActivity = t
+		}
+	}
+
+	if d.asleep && !d.canSleep() {
+		return d.wake(t)
+	}
+
+	return nil
+}
+
+// ObserveEvent - applies motion / sound event, other events are ignored
+// Returns finished session if the event woke the baby up
+func (d *Detector) ObserveEvent(event baby.Event) *Session {
+	if event.Type != message.MotionEventMessageType && event.Type != message.SoundEventMessageType {
+		return nil
+	}
+
+	if event.Time.After(d.lastActivity) {
+		d.lastActivity = event.Time
+	}
+
+	if !d.asleep || event.Time.Before(d.sessionStart) {
+		return nil
+	}
+
+	// Keep only events within the wake window
+	recent := d.recent[:0]
+	for _, t := range d.recent {
+		if event.Time.Sub(t) < d.opts.WakeWindow {
+			recent = append(recent, t)
+		}
+	}
+
+	d.recent = append(recent, event.Time)
+
+	if len(d.recent) >= d.opts.WakeEvents {
+		return d.wake(d.recent[0])
+	}
+
+	return nil
+}
+
+// Evaluate - checks whether the baby fell asleep
+// Returns true if the baby just fell asleep
+func (d *Detector) Evaluate(now time.Time) bool {
+	if d.asleep || !d.canSleep() || now.Sub(d.lastActivity) < d.opts.FallAsleepAfter {
+		return false
+	}
+
+	d.asleep = true
+	d.sessionStart = d.lastActivity
+	d.recent = nil
+	return true
+}
+
+func (d *Detector) wake(t time.Time) *Session {
+	if t.Before(d.sessionStart) {
+		t = d.sessionStart
+	}
+
+	session := &Session{
+		Start:           d.sessionStart,
+		End:             t,
+		DurationSeconds: t.Sub(d.sessionStart).Seconds(),
+	}
+
+	d.asleep = false
+	d.recent = nil
+	d.lastSession = session
+	return session
+}
+
+// State - returns derived state fields as of the given time
+func (d *Detector) State(now time.Time) baby.State {
+	state := baby.NewState().SetIsAsleep(d.asleep)
+
+	if d.asleep {
+		state.SetSleepSessionStartTimestamp(int32(d.sessionStart.Unix()))
+		state.SetSleepSessionDuration(int32(now.Sub(d.sessionStart).Seconds()))
+	} else if d.lastSession != nil {
+		state.SetSleepSessionStartTimestamp(int32(d.lastSession.Start.Unix()))
+		state.SetSleepSessionDuration(int32(d.lastSession.DurationSeconds))
+	}
+
+	return *state
+}
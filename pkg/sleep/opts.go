@@ -0,0 +1,21 @@
+package sleep
+
+import "time"
+
+// Opts - sleep tracker configuration and heuristics
+type Opts struct {
+	// Dir - directory where finished sessions are appended (one file per baby)
+	Dir string
+
+	// FallAsleepAfter - quiet period (no motion / sound) after which the baby is considered asleep
+	// The session is backdated to the start of the quiet period
+	FallAsleepAfter time.Duration
+
+	// WakeEvents - number of motion / sound events within WakeWindow which mean the baby woke up
+	WakeEvents int
+	WakeWindow time.Duration
+
+	// NightOnly - only track sleep while the camera reports night (lights off)
+	// If set, turning the lights on ends the session
+	NightOnly bool
+}
@@ -0,0 +1,165 @@
+package sleep
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// evaluateInterval - how often is checked whether babies fell asleep (and the duration updated)
+const evaluateInterval = time.Minute
+
+// Tracker - tracks sleep sessions of all babies and publishes them as state fields
+// Finished sessions are appended as JSON lines into {Dir}/{babyUID}.jsonl
+type Tracker struct {
+	Opts Opts
+
+	mu        sync.Mutex
+	detectors map[string]*Detector
+	manager   *baby.StateManager
+}
+
+// NewTracker - constructor
+func NewTracker(opts Opts) *Tracker {
+	return &Tracker{
+		Opts:      opts,
+		detectors: make(map[string]*Detector),
+	}
+}
+
+// Run - tracks sleep until the context is cancelled
+func (tracker *Tracker) Run(manager *baby.StateManager, ctx utils.GracefulContext) {
+	tracker.manager = manager
+
+	unsubscribe := manager.SubscribeWithOpts(func(babyUID string, state baby.State) {
+		tracker.withDetector(babyUID, func(d *Detector, now time.Time) *Session {
+			return d.ObserveState(now, state)
+		})
+	}, baby.SubscribeOpts{Name: "sleep", CurrentState: true})
+
+	defer unsubscribe()
+
+	unsubscribeEvents := manager.SubscribeEvents(func(babyUID string, event baby.Event) {
+		tracker.withDetector(babyUID, func(d *Detector, now time.Time) *Session {
+			return d.ObserveEvent(event)
+		})
+	}, baby.SubscribeOpts{Name: "sleep_events"})
+
+	defer unsubscribeEvents()
+
+	ticker := time.NewTicker(evaluateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			tracker.mu.Lock()
+			babyUIDs := make([]string, 0, len(tracker.detectors))
+			for babyUID := range tracker.detectors {
+				babyUIDs = append(babyUIDs, babyUID)
+			}
+			tracker.mu.Unlock()
+
+			for _, babyUID := range babyUIDs {
+				tracker.withDetector(babyUID, func(d *Detector, now time.Time) *Session {
+					if d.Evaluate(now) {
+						log.Info().Str("baby_uid", babyUID).Msg("Baby fell asleep")
+					}
+
+					return nil
+				})
+			}
+		}
+	}
+}
+
+// withDetector - runs the function with the baby detector and publishes the outcome
+func (tracker *Tracker) withDetector(babyUID string, fn func(d *Detector, now time.Time) *Session) {
+	now := time.Now()
+
+	tracker.mu.Lock()
+	d, ok := tracker.detectors[babyUID]
+	if !ok {
+		d = NewDetector(tracker.Opts, now)
+		tracker.detectors[babyUID] = d
+	}
+
+	session := fn(d, now)
+	state := d.State(now)
+	tracker.mu.Unlock()
+
+	if session != nil {
+		log.Info().Str("baby_uid", babyUID).Float64("duration", session.DurationSeconds).Msg("Baby woke up")
+
+		if err := tracker.save(babyUID, *session); err != nil {
+			log.Error().Err(err).Str("baby_uid", babyUID).Msg("Unable to store sleep session")
+		}
+	}
+
+	tracker.manager.Update(babyUID, state)
+}
+
+func (tracker *Tracker) save(babyUID string, session Session) error {
+	baby.EnsureValidBabyUID(babyUID)
+
+	if err := os.MkdirAll(tracker.Opts.Dir, 0755); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(tracker.Opts.Dir, babyUID+".jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// Sessions - returns finished sessions of the baby which ended within the range
+func (tracker *Tracker) Sessions(babyUID string, from time.Time, to time.Time) ([]Session, error) {
+	if !baby.IsValidBabyUID(babyUID) {
+		return nil, fmt.Errorf("invalid baby UID")
+	}
+
+	sessions := []Session{}
+
+	f, err := os.Open(filepath.Join(tracker.Opts.Dir, babyUID+".jsonl"))
+	if os.IsNotExist(err) {
+		return sessions, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var session Session
+		if err := json.Unmarshal(scanner.Bytes(), &session); err != nil {
+			continue
+		}
+
+		if !session.End.Before(from) && !session.End.After(to) {
+			sessions = append(sessions, session)
+		}
+	}
+
+	return sessions, scanner.Err()
+}
@@ -0,0 +1,80 @@
+package sleep_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/message"
+	"github.com/indiefan/home_assistant_nanit/pkg/sleep"
+	"github.com/stretchr/testify/assert"
+)
+
+var opts = sleep.Opts{
+	FallAsleepAfter: 15 * time.Minute,
+	WakeEvents:      2,
+	WakeWindow:      5 * time.Minute,
+}
+
+func motion(t time.Time) baby.Event {
+	return baby.Event{Type: message.MotionEventMessageType, Time: t}
+}
+
+func TestDetectorSession(t *testing.T) {
+	start := time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)
+	d := sleep.NewDetector(opts, start)
+
+	d.ObserveEvent(motion(start.Add(5 * time.Minute)))
+	assert.False(t, d.Evaluate(start.Add(15*time.Minute)), "Quiet period is counted from the last activity")
+	assert.True(t, d.Evaluate(start.Add(20*time.Minute)))
+
+	state := d.State(start.Add(30 * time.Minute))
+	assert.True(t, state.GetIsAsleep())
+	assert.Equal(t, int32(start.Add(5*time.Minute).Unix()), *state.SleepSessionStartTimestamp)
+	assert.Equal(t, int32(25*60), *state.SleepSessionDuration)
+
+	// Single event does not wake the baby up
+	assert.Nil(t, d.ObserveEvent(motion(start.Add(60*time.Minute))))
+	assert.Nil(t, d.ObserveEvent(motion(start.Add(70*time.Minute))))
+
+	session := d.ObserveEvent(motion(start.Add(72 * time.Minute)))
+	if assert.NotNil(t, session) {
+		assert.Equal(t, start.Add(5*time.Minute), session.Start)
+		assert.Equal(t, start.Add(70*time.Minute), session.End)
+		assert.Equal(t, float64(65*60), session.DurationSeconds)
+	}
+
+	state = d.State(start.Add(80 * time.Minute))
+	assert.False(t, state.GetIsAsleep())
+	assert.Equal(t, int32(65*60), *state.SleepSessionDuration)
+}
+
+func TestDetectorStandbyEndsSession(t *testing.T) {
+	start := time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)
+	d := sleep.NewDetector(opts, start)
+
+	assert.True(t, d.Evaluate(start.Add(time.Hour)))
+
+	session := d.ObserveState(start.Add(2*time.Hour), *baby.NewState().SetStandby(true))
+	assert.NotNil(t, session)
+	assert.False(t, d.Evaluate(start.Add(3*time.Hour)), "Cannot fall asleep while in standby")
+
+	d.ObserveState(start.Add(3*time.Hour), *baby.NewState().SetStandby(false))
+	assert.False(t, d.Evaluate(start.Add(3*time.Hour+10*time.Minute)), "Quiet period restarts after standby")
+	assert.True(t, d.Evaluate(start.Add(3*time.Hour+15*time.Minute)))
+}
+
+func TestDetectorNightOnly(t *testing.T) {
+	nightOpts := opts
+	nightOpts.NightOnly = true
+
+	start := time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)
+	d := sleep.NewDetector(nightOpts, start)
+
+	assert.False(t, d.Evaluate(start.Add(time.Hour)))
+
+	d.ObserveState(start.Add(time.Hour), *baby.NewState().SetIsNight(true))
+	assert.True(t, d.Evaluate(start.Add(time.Hour+15*time.Minute)))
+
+	assert.NotNil(t, d.ObserveState(start.Add(8*time.Hour), *baby.NewState().SetIsNight(false)))
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"errors"
 	"sync"
 )
@@ -43,6 +44,22 @@ func RunWithGracefulCancel(callback func(GracefulContext)) GracefulRunner {
 	return newGracefulRunner(ctx)
 }
 
+// ContextOf - returns context.Context which is cancelled together with the graceful context
+// The returned cancel function releases the context once it is no longer needed
+func ContextOf(ctx GracefulContext) (context.Context, context.CancelFunc) {
+	stdCtx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-stdCtx.Done():
+		}
+	}()
+
+	return stdCtx, cancel
+}
+
 // -----------------------------
 
 type gracefulRunner struct {
@@ -1,6 +1,7 @@
 package utils_test
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -73,3 +74,21 @@ func TestGracefulRunnerFail(t *testing.T) {
 	assert.EqualError(t, err, "simulated failure")
 	assert.Equal(t, " main_finished sub_finished", out)
 }
+
+func TestContextOf(t *testing.T) {
+	ctxC := make(chan context.Context, 1)
+
+	runner := utils.RunWithGracefulCancel(func(ctx utils.GracefulContext) {
+		stdCtx, cancel := utils.ContextOf(ctx)
+		defer cancel()
+
+		ctxC <- stdCtx
+		<-stdCtx.Done()
+	})
+
+	stdCtx := <-ctxC
+	assert.NoError(t, stdCtx.Err())
+
+	runner.Cancel()
+	assert.Equal(t, context.Canceled, stdCtx.Err())
+}
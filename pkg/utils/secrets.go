@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// SecretProvider - source of secret values which should not be passed through plain environment variables
+type SecretProvider interface {
+	// LookupSecret - returns value of the secret setting (ie. NANIT_PASSWORD) and whether the provider has it
+	LookupSecret(varName string) (string, bool, error)
+}
+
+// FileSecretProvider - reads the secret from a file referenced by {varName}_FILE variable (ie. NANIT_PASSWORD_FILE=/run/secrets/nanit_password)
+type FileSecretProvider struct {
+	LookupEnv func(string) (string, bool)
+}
+
+// LookupSecret - implements SecretProvider
+func (provider FileSecretProvider) LookupSecret(varName string) (string, bool, error) {
+	lookupEnv := provider.LookupEnv
+	if lookupEnv == nil {
+		lookupEnv = os.LookupEnv
+	}
+
+	filename, found := lookupEnv(varName + "_FILE")
+	if !found || filename == "" {
+		return "", false, nil
+	}
+
+	value, err := readSecretFile(filename)
+	if err != nil {
+		return "", false, fmt.Errorf("%v_FILE: %w", varName, err)
+	}
+
+	return value, true, nil
+}
+
+// DirectorySecretProvider - reads secrets from files named after the lowercased variable (ie. /run/secrets/nanit_password)
+// Missing files are skipped
+type DirectorySecretProvider struct {
+	Dir string
+}
+
+// LookupSecret - implements SecretProvider
+func (provider DirectorySecretProvider) LookupSecret(varName string) (string, bool, error) {
+	filename := filepath.Join(provider.Dir, strings.ToLower(varName))
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return "", false, nil
+	}
+
+	value, err := readSecretFile(filename)
+	if err != nil {
+		return "", false, err
+	}
+
+	return value, true, nil
+}
+
+func readSecretFile(filename string) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("unable to read secret file: %w", err)
+	}
+
+	// Files usually end with a new line
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", fmt.Errorf("secret file %v is empty", filename)
+	}
+
+	return value, nil
+}
+
+var (
+	secretProvidersMu sync.RWMutex
+	secretProviders   []SecretProvider
+)
+
+// RegisterSecretProvider - adds provider consulted after the environment variables and their _FILE variants
+func RegisterSecretProvider(provider SecretProvider) {
+	secretProvidersMu.Lock()
+	defer secretProvidersMu.Unlock()
+
+	secretProviders = append(secretProviders, provider)
+}
+
+// LookupSecret - resolves secret setting from the environment variable, its _FILE variant and registered providers (in this order)
+func LookupSecret(varName string, lookupEnv func(string) (string, bool)) (string, bool, error) {
+	if value, found := lookupEnv(varName); found && value != "" {
+		return value, true, nil
+	}
+
+	secretProvidersMu.RLock()
+	providers := append([]SecretProvider{FileSecretProvider{LookupEnv: lookupEnv}}, secretProviders...)
+	secretProvidersMu.RUnlock()
+
+	for _, provider := range providers {
+		value, found, err := provider.LookupSecret(varName)
+		if err != nil || found {
+			return value, found, err
+		}
+	}
+
+	return "", false, nil
+}
@@ -1,6 +1,9 @@
 package utils
 
-import "strings"
+import (
+	"net/url"
+	"strings"
+)
 
 // AnonymizeToken - replaces chunk of a string with '*'
 func AnonymizeToken(token string, clearLen int) string {
@@ -11,3 +14,46 @@ func AnonymizeToken(token string, clearLen int) string {
 
 	return strings.Repeat("*", len(token))
 }
+
+// AnonymizeURL - replaces password, query values and the path except of its first segment with '*'
+// Webhook URLs (ie. Slack, Discord) carry the secret in the path
+func AnonymizeURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return AnonymizeToken(rawURL, 0)
+	}
+
+	if segments := strings.Split(u.EscapedPath(), "/"); len(segments) > 2 {
+		for i := 2; i < len(segments); i++ {
+			segments[i] = AnonymizeToken(segments[i], 0)
+		}
+
+		// Note: setting only the Path would escape the '*'
+		u.RawPath = strings.Join(segments, "/")
+		u.Path, _ = url.PathUnescape(u.RawPath)
+	}
+
+	// Note: url.UserPassword would escape the '*'
+	password, hasPassword := u.User.Password()
+	if hasPassword {
+		u.User = url.User(u.User.Username())
+	}
+
+	if u.RawQuery != "" {
+		params := strings.Split(u.RawQuery, "&")
+		for i, param := range params {
+			if key, value, ok := strings.Cut(param, "="); ok {
+				params[i] = key + "=" + AnonymizeToken(value, 0)
+			}
+		}
+
+		u.RawQuery = strings.Join(params, "&")
+	}
+
+	if hasPassword {
+		userinfo := u.User.String() + "@"
+		return strings.Replace(u.String(), userinfo, u.User.String()+":"+AnonymizeToken(password, 0)+"@", 1)
+	}
+
+	return u.String()
+}
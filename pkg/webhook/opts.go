@@ -0,0 +1,36 @@
+package webhook
+
+import "time"
+
+// Hook - configuration of a single webhook
+// Filters narrow down what is delivered. If neither Fields nor EventTypes are set, everything is delivered.
+// If only one of them is set, only the matching kind of notification is delivered.
+type Hook struct {
+	URL string
+
+	// Secret - optional key for HMAC-SHA256 signature of the body (X-Nanit-Signature header)
+	Secret string
+
+	// Babies - baby UIDs to deliver notifications for (empty = all)
+	Babies []string
+
+	// Fields - state fields to deliver (ie. temperature, is_night)
+	Fields []string
+
+	// EventTypes - event types to deliver (ie. MOTION, SOUND)
+	EventTypes []string
+}
+
+// Opts - webhook dispatcher configuration
+type Opts struct {
+	Hooks []Hook
+
+	// DeadLetterFile - file where notifications which could not be delivered are appended
+	DeadLetterFile string
+
+	// Timeout - timeout of a single delivery attempt
+	Timeout time.Duration
+
+	// Retries - cooldowns between delivery attempts, notification is dead-lettered once they are exhausted
+	Retries []time.Duration
+}
@@ -0,0 +1,274 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/rs/zerolog/log"
+)
+
+const queueSize = 100
+
+// SignatureHeader - name of the header carrying HMAC signature of the body
+const SignatureHeader = "X-Nanit-Signature"
+
+// Notification - body of the webhook request
+type Notification struct {
+	Kind    string                 `json:"kind"` // state | event
+	BabyUID string                 `json:"baby_uid"`
+	Time    time.Time              `json:"time"`
+	State   map[string]interface{} `json:"state,omitempty"`
+	Event   *baby.Event            `json:"event,omitempty"`
+
+	// Fields - timestamps and staleness of the changed fields (kind state)
+	Fields map[string]baby.FieldValue `json:"fields,omitempty"`
+}
+
+type deadLetter struct {
+	Time    time.Time       `json:"time"`
+	URL     string          `json:"url"`
+	Error   string          `json:"error"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Dispatcher - delivers state changes and events to configured webhooks
+type Dispatcher struct {
+	Opts   Opts
+	client *http.Client
+
+	deadLetterMu sync.Mutex
+}
+
+// NewDispatcher - constructor
+func NewDispatcher(opts Opts) *Dispatcher {
+	return &Dispatcher{
+		Opts:   opts,
+		client: &http.Client{Timeout: opts.Timeout},
+	}
+}
+
+// Run - subscribes to state changes and events and delivers them until the context is cancelled
+func (d *Dispatcher) Run(manager *baby.StateManager, ctx utils.GracefulContext) {
+	queues := make([]chan []byte, len(d.Opts.Hooks))
+	for i, hook := range d.Opts.Hooks {
+		queues[i] = make(chan []byte, queueSize)
+
+		_hook := hook
+		_queue := queues[i]
+		ctx.RunAsChild(func(childCtx utils.GracefulContext) {
+			d.runWorker(_hook, _queue, childCtx)
+		})
+	}
+
+	enqueue := func(i int, n Notification) {
+		payload, err := json.Marshal(n)
+		if err != nil {
+			log.Error().Err(err).Msg("Unable to marshal webhook notification")
+			return
+		}
+
+		select {
+		case queues[i] <- payload:
+		default:
+			d.writeDeadLetter(d.Opts.Hooks[i].URL, payload, fmt.Errorf("delivery queue is full"))
+		}
+	}
+
+	// Fields are captured with the update, the state might have moved on by the time it is delivered
+	unsubscribe := manager.SubscribeFields(func(babyUID string, state baby.State, fields map[string]baby.FieldValue) {
+		for i, hook := range d.Opts.Hooks {
+			if n, ok := hook.stateNotification(babyUID, state, fields); ok {
+				enqueue(i, n)
+			}
+		}
+	}, baby.SubscribeOpts{Name: "webhooks"})
+
+	unsubscribeEvents := manager.SubscribeEvents(func(babyUID string, event baby.Event) {
+		for i, hook := range d.Opts.Hooks {
+			if n, ok := hook.eventNotification(babyUID, event); ok {
+				enqueue(i, n)
+			}
+		}
+	}, baby.SubscribeOpts{Name: "webhooks_events"})
+
+	log.Info().Int("hooks", len(d.Opts.Hooks)).Msg("Webhook dispatcher started")
+
+	<-ctx.Done()
+	unsubscribe()
+	unsubscribeEvents()
+}
+
+func (d *Dispatcher) runWorker(hook Hook, queue chan []byte, ctx utils.GracefulContext) {
+	for {
+		select {
+		case <-ctx.Done():
+			// Keep whatever is left for later inspection
+			for {
+				select {
+				case payload := <-queue:
+					d.writeDeadLetter(hook.URL, payload, fmt.Errorf("dispatcher stopped"))
+				default:
+					return
+				}
+			}
+		case payload := <-queue:
+			d.deliver(hook, payload, ctx)
+		}
+	}
+}
+
+// deliver - posts the payload, retrying with backoff, dead-letters it on failure
+func (d *Dispatcher) deliver(hook Hook, payload []byte, ctx utils.GracefulContext) {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = d.post(hook, payload); err == nil {
+			return
+		}
+
+		if attempt >= len(d.Opts.Retries) {
+			break
+		}
+
+		log.Warn().Str("url", utils.AnonymizeURL(hook.URL)).Err(err).Int("attempt", attempt+1).Msg("Webhook delivery failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			d.writeDeadLetter(hook.URL, payload, err)
+			return
+		case <-time.After(d.Opts.Retries[attempt]):
+		}
+	}
+
+	log.Error().Str("url", utils.AnonymizeURL(hook.URL)).Err(err).Msg("Webhook delivery failed, giving up")
+	d.writeDeadLetter(hook.URL, payload, err)
+}
+
+func (d *Dispatcher) post(hook Hook, payload []byte) error {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if hook.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(hook.Secret, payload))
+	}
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %v", res.StatusCode)
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) writeDeadLetter(url string, payload []byte, deliveryErr error) {
+	if d.Opts.DeadLetterFile == "" {
+		return
+	}
+
+	line, err := json.Marshal(deadLetter{
+		Time:    time.Now().UTC(),
+		URL:     utils.AnonymizeURL(url),
+		Error:   deliveryErr.Error(),
+		Payload: payload,
+	})
+
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to marshal webhook dead letter")
+		return
+	}
+
+	d.deadLetterMu.Lock()
+	defer d.deadLetterMu.Unlock()
+
+	f, err := os.OpenFile(d.Opts.DeadLetterFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Error().Str("file", d.Opts.DeadLetterFile).Err(err).Msg("Unable to open webhook dead letter file")
+		return
+	}
+
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Error().Str("file", d.Opts.DeadLetterFile).Err(err).Msg("Unable to write webhook dead letter")
+	}
+}
+
+// Sign - returns HMAC-SHA256 signature of the payload in form "sha256={hex}"
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (hook Hook) matchesBaby(babyUID string) bool {
+	return len(hook.Babies) == 0 || contains(hook.Babies, babyUID)
+}
+
+func (hook Hook) stateNotification(babyUID string, state baby.State, fields map[string]baby.FieldValue) (Notification, bool) {
+	if !hook.matchesBaby(babyUID) || (len(hook.Fields) == 0 && len(hook.EventTypes) > 0) {
+		return Notification{}, false
+	}
+
+	values := state.AsMap(false)
+	if len(hook.Fields) > 0 {
+		for key := range values {
+			if !contains(hook.Fields, key) {
+				delete(values, key)
+			}
+		}
+	}
+
+	if len(values) == 0 {
+		return Notification{}, false
+	}
+
+	changedFields := make(map[string]baby.FieldValue, len(values))
+	for key := range values {
+		if field, ok := fields[key]; ok {
+			changedFields[key] = field
+		}
+	}
+
+	return Notification{Kind: "state", BabyUID: babyUID, Time: time.Now().UTC(), State: values, Fields: changedFields}, true
+}
+
+func (hook Hook) eventNotification(babyUID string, event baby.Event) (Notification, bool) {
+	if !hook.matchesBaby(babyUID) || (len(hook.EventTypes) == 0 && len(hook.Fields) > 0) {
+		return Notification{}, false
+	}
+
+	if len(hook.EventTypes) > 0 && !contains(hook.EventTypes, event.Type) {
+		return Notification{}, false
+	}
+
+	return Notification{Kind: "event", BabyUID: babyUID, Time: event.Time, Event: &event}, true
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,102 @@
+package webhook_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/indiefan/home_assistant_nanit/pkg/baby"
+	"github.com/indiefan/home_assistant_nanit/pkg/utils"
+	"github.com/indiefan/home_assistant_nanit/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatcherDeliversFilteredAndSigned(t *testing.T) {
+	received := make(chan webhook.Notification, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, webhook.Sign("secret", body), r.Header.Get(webhook.SignatureHeader))
+
+		n := webhook.Notification{}
+		json.Unmarshal(body, &n)
+		received <- n
+	}))
+	defer server.Close()
+
+	manager := baby.NewStateManager()
+	dispatcher := webhook.NewDispatcher(webhook.Opts{
+		Hooks:   []webhook.Hook{{URL: server.URL, Secret: "secret", Babies: []string{"b1"}, Fields: []string{"temperature"}}},
+		Timeout: time.Second,
+	})
+
+	runner := utils.RunWithGracefulCancel(func(ctx utils.GracefulContext) {
+		dispatcher.Run(manager, ctx)
+	})
+	defer runner.Cancel()
+
+	time.Sleep(50 * time.Millisecond)
+	manager.Update("b2", *baby.NewState().SetTemperatureMilli(20_000))
+	manager.Update("b1", *baby.NewState().SetIsNight(true))
+	manager.Update("b1", *baby.NewState().SetTemperatureMilli(21_500).SetHumidityMilli(40_000))
+	manager.NotifyEvent("b1", baby.Event{Type: "MOTION", Time: time.Now()})
+
+	select {
+	case n := <-received:
+		assert.Equal(t, "state", n.Kind)
+		assert.Equal(t, "b1", n.BabyUID)
+		assert.Equal(t, map[string]interface{}{"temperature": 21.5}, n.State)
+
+		// Timestamps of the changed fields only
+		if assert.Len(t, n.Fields, 1) {
+			assert.NotNil(t, n.Fields["temperature"].UpdatedAt)
+			assert.False(t, n.Fields["temperature"].Stale)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Webhook not delivered")
+	}
+
+	select {
+	case n := <-received:
+		t.Fatalf("Unexpected notification %+v", n)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestDispatcherDeadLetter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	deadLetterFile := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	manager := baby.NewStateManager()
+	dispatcher := webhook.NewDispatcher(webhook.Opts{
+		Hooks:          []webhook.Hook{{URL: server.URL + "/hook?token=topsecret", EventTypes: []string{"SOUND"}}},
+		DeadLetterFile: deadLetterFile,
+		Timeout:        time.Second,
+		Retries:        []time.Duration{10 * time.Millisecond},
+	})
+
+	runner := utils.RunWithGracefulCancel(func(ctx utils.GracefulContext) {
+		dispatcher.Run(manager, ctx)
+	})
+	defer runner.Cancel()
+
+	time.Sleep(50 * time.Millisecond)
+	manager.NotifyEvent("b1", baby.Event{Type: "SOUND", Time: time.Now()})
+
+	assert.Eventually(t, func() bool {
+		data, _ := os.ReadFile(deadLetterFile)
+		return len(data) > 0
+	}, 2*time.Second, 20*time.Millisecond)
+
+	// Secrets in the URL are not written down
+	data, _ := os.ReadFile(deadLetterFile)
+	assert.Contains(t, string(data), "/hook?token=")
+	assert.NotContains(t, string(data), "topsecret")
+}
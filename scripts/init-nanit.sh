@@ -2,7 +2,7 @@
 
 # Script Defaults
 DEBUG=false
-SESSION_REVISION=3 # Keep in sync with value in pkg/session/session.go
+SESSION_REVISION=5 # Keep in sync with value in pkg/session/session.go
 
 # Read command line flags
 while getopts ":d" o; do